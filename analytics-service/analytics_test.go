@@ -10,6 +10,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 func TestHandleOrderCreated(t *testing.T) {
@@ -17,18 +18,31 @@ func TestHandleOrderCreated(t *testing.T) {
 	defer mockDB.Close()
 	db = mockDB
 
-	event := map[string]string{"event": "order_created", "status": "новый"}
+	event := map[string]string{"event": "order_created", "status": "новый", "tenant_id": "shop-1"}
 	body, _ := json.Marshal(event)
 
-	mock.ExpectExec("UPDATE general_stats SET total_orders = total_orders \\+ 1").
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec("UPDATE general_stats SET active_orders = active_orders \\+ 1").
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO general_stats \\(tenant_id\\) VALUES \\(\\$1\\) ON CONFLICT").
+		WithArgs("shop-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE general_stats SET total_orders = total_orders \\+ 1 WHERE tenant_id = \\$1").
+		WithArgs("shop-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE general_stats SET active_orders = active_orders \\+ 1 WHERE tenant_id = \\$1").
+		WithArgs("shop-1").WillReturnResult(sqlmock.NewResult(0, 1))
 
 	handleOrderCreated(body)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestHandleOrderCancelled(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectExec("UPDATE general_stats SET active_orders = active_orders - 1 WHERE tenant_id = \\$1 AND active_orders > 0").
+		WithArgs("shop-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	handleOrderCancelled([]byte(`{"event":"order_cancelled","order_id":"o-1","tenant_id":"shop-1"}`))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestHandleCourierCreated(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
@@ -38,7 +52,7 @@ func TestHandleCourierCreated(t *testing.T) {
 	body, _ := json.Marshal(event)
 
 	mock.ExpectExec("INSERT INTO courier_stats .*").
-		WithArgs("c1", "Ivan").
+		WithArgs("c1", "Ivan", auth.DefaultTenant).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	handleCourierCreated(body)
@@ -63,16 +77,18 @@ func TestHandleOrderCompleted(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("SELECT completed_orders, average_delivery_time_sec").
-		WithArgs("c1").
+		WithArgs(auth.DefaultTenant, "c1").
 		WillReturnRows(sqlmock.NewRows([]string{"completed_orders", "average_delivery_time_sec"}).
 			AddRow(2, 300.0))
 	mock.ExpectExec("INSERT INTO courier_stats .*").
-		WithArgs("c1", 3, sqlmock.AnyArg()).
+		WithArgs("c1", 3, sqlmock.AnyArg(), auth.DefaultTenant).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO general_stats").
+		WithArgs(auth.DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("UPDATE general_stats SET completed_orders = completed_orders \\+ 1").
-		WillReturnResult(sqlmock.NewResult(0, 1))
+		WithArgs(auth.DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectExec("UPDATE general_stats SET active_orders = active_orders - 1 .*").
-		WillReturnResult(sqlmock.NewResult(0, 1))
+		WithArgs(auth.DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
 	handleOrderCompleted(body)
@@ -84,11 +100,69 @@ func TestHandleDeliveryCalculated(t *testing.T) {
 	defer mockDB.Close()
 	db = mockDB
 
-	event := map[string]interface{}{"event": "delivery_calculated", "courier_id": "c1", "cost": 45.5}
+	event := map[string]interface{}{"event": "delivery_calculated", "courier_id": "c1", "cost": 45.5, "discount": 5.0, "tenant_id": "shop-1"}
+	body, _ := json.Marshal(event)
+
+	mock.ExpectExec("INSERT INTO courier_stats .*").
+		WithArgs("c1", 45.5, 5.0, "shop-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	HandleDeliveryCalculated(body)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleDeliveryCalculatedForeignCurrency(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	reportingCurrency = "RUB"
+
+	event := map[string]interface{}{
+		"event": "delivery_calculated", "courier_id": "c1",
+		"cost": 10.0, "discount": 1.0, "currency": "USD", "base_currency": "RUB", "exchange_rate": 90.0,
+	}
 	body, _ := json.Marshal(event)
 
 	mock.ExpectExec("INSERT INTO courier_stats .*").
-		WithArgs("c1", 45.5).
+		WithArgs("c1", 900.0, 90.0, auth.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	HandleDeliveryCalculated(body)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Событие, которое order-service публикует после вручения (см. TestOrderRevenuePath в order-service).
+func TestHandleDeliveryCalculatedFromOrderService(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	reportingCurrency = "RUB"
+
+	body := []byte(`{"event":"delivery_calculated","order_id":"o-1","courier_id":"c-1","tenant_id":"shop-1",
+		"cost":10,"discount":1,"promo_code":"SALE","currency":"USD","base_currency":"RUB","exchange_rate":90}`)
+	mock.ExpectExec("INSERT INTO courier_stats .*").
+		WithArgs("c-1", 900.0, 90.0, "shop-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	HandleDeliveryCalculated(body)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleDeliveryCalculatedUnconvertible(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	reportingCurrency = "RUB"
+
+	event := map[string]interface{}{
+		"event": "delivery_calculated", "courier_id": "c1",
+		"cost": 10.0, "currency": "USD", "base_currency": "EUR", "exchange_rate": 1.1,
+	}
+	body, _ := json.Marshal(event)
+
+	// выручка не отбрасывается, а копится в исходной валюте
+	mock.ExpectExec("INSERT INTO courier_revenue_unconverted .*ON CONFLICT").
+		WithArgs(auth.DefaultTenant, "c1", "USD", 10.0, 0.0).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	HandleDeliveryCalculated(body)
@@ -100,22 +174,36 @@ func TestGetCourierStats(t *testing.T) {
 	defer mockDB.Close()
 	db = mockDB
 
-	rows := sqlmock.NewRows([]string{"courier_id", "courier_name", "completed_orders", "total_revenue", "average_delivery_time_sec"}).
-		AddRow("c1", "Ivan", 5, 100.0, 300.0)
+	rows := sqlmock.NewRows([]string{"tenant_id", "courier_id", "courier_name", "completed_orders", "total_revenue", "total_discount", "average_delivery_time_sec"}).
+		AddRow("shop-1", "c1", "Ivan", 5, 100.0, 10.0, 300.0)
 
-	mock.ExpectQuery("SELECT courier_id.*FROM courier_stats").
+	mock.ExpectQuery("SELECT tenant_id, courier_id.*FROM courier_stats\\s+WHERE tenant_id = \\$1").
+		WithArgs("shop-1").
 		WillReturnRows(rows)
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 
-	req := httptest.NewRequest("GET", "/analytics/couriers", nil)
+	req := httptest.NewRequest("GET", "/analytics/couriers?tenant_id=shop-2", nil)
 	c.Request = req
+	c.Set("claims", &auth.Claims{Role: auth.RoleAnalyst, TenantID: "shop-1"})
+
+	mock.ExpectQuery("SELECT tenant_id, courier_id, currency, revenue, discount\\s+FROM courier_revenue_unconverted\\s+WHERE tenant_id = \\$1").
+		WithArgs("shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "courier_id", "currency", "revenue", "discount"}).
+			AddRow("shop-1", "c1", "USD", 12.5, 0.0).
+			AddRow("shop-1", "c2", "KZT", 3000.0, 100.0))
 
 	getCourierStats(c)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Ivan")
+	var list []CourierStat
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 2)
+	assert.Equal(t, []UnconvertedRevenue{{Currency: "USD", Revenue: 12.5}}, list[0].Unconverted)
+	assert.Equal(t, "c2", list[1].CourierID, "курьер только с непереведённой выручкой тоже в отчёте")
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestGetGeneralStats(t *testing.T) {
@@ -123,9 +211,9 @@ func TestGetGeneralStats(t *testing.T) {
 	defer mockDB.Close()
 	db = mockDB
 
-	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE created_at >= .*").
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE created_at >= .* AND TRUE").
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
-	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE status <> 'завершён' .*").
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE completed_at IS NULL AND COALESCE\\(status, ''\\) NOT IN \\('возврат', 'отменён'\\) .*").
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
 	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE status = 'завершён' .*").
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(6))
@@ -137,9 +225,48 @@ func TestGetGeneralStats(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/analytics/general?from=2023-01-01&to=2023-12-31", nil)
 	c.Request = req
+	c.Set("claims", &auth.Claims{Role: auth.RoleAdmin})
 
 	getGeneralStats(c)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "total_orders")
 }
+
+func TestGetTenantStats(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT tenant_id, total_orders, active_orders, completed_orders FROM general_stats").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "total_orders", "active_orders", "completed_orders"}).
+			AddRow("default", 10, 2, 8).
+			AddRow("shop-1", 3, 1, 2))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/analytics/tenants", nil)
+
+	getTenantStats(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list []TenantStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, []TenantStats{
+		{TenantID: "default", TotalOrders: 10, ActiveOrders: 2, CompletedOrders: 8},
+		{TenantID: "shop-1", TotalOrders: 3, ActiveOrders: 1, CompletedOrders: 2},
+	}, list)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantStatsRequireCrossTenantAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/analytics/tenants", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleAdmin, TenantID: "shop-1"})
+	}, auth.CrossTenantRequired(), getTenantStats)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/analytics/tenants", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
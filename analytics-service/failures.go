@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streadway/amqp"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// deliveryFailedEvent — событие order.delivery_failed из order-service.
+type deliveryFailedEvent struct {
+	OrderID       string `json:"order_id"`
+	CourierID     string `json:"courier_id"`
+	TenantID      string `json:"tenant_id"`
+	Reason        string `json:"reason"`  // not_home, wrong_address, refused, damaged
+	Attempt       int    `json:"attempt"` // номер неудачной попытки по заказу
+	Outcome       string `json:"outcome"` // rescheduled или returned
+	FailedAt      string `json:"failed_at"`
+	ReturnOrderID string `json:"return_order_id"`
+}
+
+func startDeliveryFailedConsumer() {
+	conn, err := amqp.Dial(os.Getenv("RABBITMQ_URL"))
+	if err != nil {
+		log.Fatalf("Ошибка подключения к RabbitMQ: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Ошибка канала: %v", err)
+	}
+	if _, err = ch.QueueDeclare("order.delivery_failed", true, false, false, false, nil); err != nil {
+		log.Fatalf("Ошибка объявления очереди: %v", err)
+	}
+	msgs, err := ch.Consume("order.delivery_failed", "", true, false, false, false, nil)
+	if err != nil {
+		log.Fatalf("Ошибка consume: %v", err)
+	}
+	go func() {
+		for msg := range msgs {
+			handleDeliveryFailed(msg.Body)
+		}
+	}()
+}
+
+// handleDeliveryFailed сохраняет неудачную попытку доставки. Повторное событие по той же попытке игнорируется.
+// Заказ, возвращённый отправителю, больше не активен: доставку продолжает возвратный заказ со своим order_created.
+func handleDeliveryFailed(body []byte) {
+	var evt deliveryFailedEvent
+	if err := json.Unmarshal(body, &evt); err != nil {
+		log.Printf("Некорректный JSON в order.delivery_failed: %v", err)
+		return
+	}
+	failedAt, err := time.Parse(time.RFC3339, evt.FailedAt)
+	if err != nil || evt.OrderID == "" || evt.Attempt <= 0 {
+		log.Printf("Неполное событие order.delivery_failed: %s", body)
+		return
+	}
+	tenantID := eventTenant(evt.TenantID)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Ошибка начала транзакции: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		INSERT INTO delivery_failures (tenant_id, order_id, attempt, courier_id, reason, outcome, return_order_id, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		ON CONFLICT (tenant_id, order_id, attempt) DO NOTHING
+	`, tenantID, evt.OrderID, evt.Attempt, evt.CourierID, evt.Reason, evt.Outcome, evt.ReturnOrderID, failedAt)
+	if err != nil {
+		log.Printf("Ошибка записи неудачной попытки доставки %s: %v", evt.OrderID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 1 && evt.Outcome == "returned" {
+		if err := closeActiveOrder(tx, tenantID); err != nil {
+			log.Printf("Ошибка уменьшения active_orders: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Ошибка коммита: %v", err)
+	}
+}
+
+// CourierFailureStat — неудачные попытки курьера за период.
+type CourierFailureStat struct {
+	CourierID      string `json:"courier_id"`
+	CourierName    string `json:"courier_name"`
+	FailedAttempts int    `json:"failed_attempts"`
+}
+
+// FailedDeliveriesResponse — ответ GET /analytics/failed-deliveries.
+type FailedDeliveriesResponse struct {
+	FailedAttempts int                  `json:"failed_attempts"`
+	Rescheduled    int                  `json:"rescheduled"`
+	Returned       int                  `json:"returned"` // заказов, возвращённых отправителю
+	ByReason       map[string]int       `json:"by_reason"`
+	Couriers       []CourierFailureStat `json:"couriers"`
+}
+
+// getFailedDeliveries — GET /analytics/failed-deliveries?from=&to=: неудачные попытки доставки
+// по причинам и курьерам, сколько заказов перенесено и сколько возвращено отправителю.
+func getFailedDeliveries(c *gin.Context) {
+	from, to, ok := parsePeriod(c)
+	if !ok {
+		return
+	}
+	tenant, tenantArgs := auth.TenantScope(c, 3)
+	args := append([]any{from, to}, tenantArgs...)
+	failures := `SELECT * FROM delivery_failures WHERE failed_at >= $1 AND failed_at <= $2 AND ` + tenant
+
+	resp := FailedDeliveriesResponse{ByReason: map[string]int{}, Couriers: []CourierFailureStat{}}
+	rows, err := db.Query(`SELECT reason, outcome, COUNT(*) FROM (`+failures+`) f GROUP BY reason, outcome`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reason, outcome string
+		var n int
+		if err := rows.Scan(&reason, &outcome, &n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.FailedAttempts += n
+		resp.ByReason[reason] += n
+		switch outcome {
+		case "rescheduled":
+			resp.Rescheduled += n
+		case "returned":
+			resp.Returned += n
+		}
+	}
+
+	rows, err = db.Query(`
+		SELECT f.courier_id, COALESCE(cs.courier_name, ''), COUNT(*)
+		FROM (`+failures+`) f
+		LEFT JOIN courier_stats cs ON cs.tenant_id = f.tenant_id AND cs.courier_id = f.courier_id
+		GROUP BY f.courier_id, cs.courier_name
+		ORDER BY COUNT(*) DESC, f.courier_id`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s CourierFailureStat
+		if err := rows.Scan(&s.CourierID, &s.CourierName, &s.FailedAttempts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.Couriers = append(resp.Couriers, s)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestHandleDeliveryFailed(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	failedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body, _ := json.Marshal(map[string]any{
+		"event": "order.delivery_failed", "order_id": "o-1", "courier_id": "c1", "tenant_id": "shop-1",
+		"reason": "refused", "attempt": 1, "outcome": "returned", "return_order_id": "o-1-R",
+		"failed_at": failedAt.Format(time.RFC3339),
+	})
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO delivery_failures").
+		WithArgs("shop-1", "o-1", 1, "c1", "refused", "returned", "o-1-R", failedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE general_stats SET active_orders = active_orders - 1").WithArgs("shop-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	handleDeliveryFailed(body)
+
+	// повтор того же события не уменьшает активные заказы второй раз
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO delivery_failures").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	handleDeliveryFailed(body)
+
+	// событие без времени и номера попытки не записывается
+	handleDeliveryFailed([]byte(`{"order_id":"o-2"}`))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFailedDeliveries(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery("SELECT reason, outcome, COUNT\\(\\*\\) FROM \\(SELECT \\* FROM delivery_failures WHERE .* AND tenant_id = \\$3\\)").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"reason", "outcome", "count"}).
+			AddRow("not_home", "rescheduled", 5).
+			AddRow("not_home", "returned", 1).
+			AddRow("damaged", "returned", 2))
+	mock.ExpectQuery("SELECT f.courier_id.*LEFT JOIN courier_stats").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"courier_id", "courier_name", "count"}).
+			AddRow("c1", "Ivan", 6).
+			AddRow("c2", "", 2))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/analytics/failed-deliveries?from=2024-05-01", nil)
+	c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+
+	getFailedDeliveries(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp FailedDeliveriesResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 8, resp.FailedAttempts)
+	assert.Equal(t, 5, resp.Rescheduled)
+	assert.Equal(t, 3, resp.Returned)
+	assert.Equal(t, map[string]int{"not_home": 6, "damaged": 2}, resp.ByReason)
+	assert.Len(t, resp.Couriers, 2)
+	assert.Equal(t, "Ivan", resp.Couriers[0].CourierName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
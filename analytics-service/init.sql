@@ -13,6 +13,57 @@ CREATE TABLE IF NOT EXISTS courier_stats (
     average_delivery_time_sec NUMERIC NOT NULL DEFAULT 0
 );
 
+-- Сумма скидок по промокодам; total_revenue учитывается уже за вычетом скидок
+ALTER TABLE courier_stats
+  ADD COLUMN IF NOT EXISTS total_discount NUMERIC NOT NULL DEFAULT 0;
+
 INSERT INTO general_stats (total_orders)
 SELECT 0
 WHERE NOT EXISTS (SELECT 1 FROM general_stats);
+
+-- Организации (tenants): статистика ведётся отдельно по каждой, старые данные относятся к 'default'
+ALTER TABLE general_stats
+  ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_general_stats_tenant ON general_stats (tenant_id);
+
+ALTER TABLE courier_stats
+  ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE courier_stats DROP CONSTRAINT IF EXISTS courier_stats_pkey;
+ALTER TABLE courier_stats ADD PRIMARY KEY (tenant_id, courier_id);
+
+-- Соблюдение SLA: по одной строке на завершённый заказ, у которого был обещанный срок (due_at)
+CREATE TABLE IF NOT EXISTS sla_outcomes (
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    order_id TEXT NOT NULL,
+    courier_id TEXT NOT NULL,
+    due_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP NOT NULL,
+    met BOOLEAN NOT NULL,
+    PRIMARY KEY (tenant_id, order_id)
+);
+CREATE INDEX IF NOT EXISTS idx_sla_outcomes_completed ON sla_outcomes (tenant_id, completed_at);
+
+-- Неудачные попытки доставки (order.delivery_failed): по одной строке на попытку
+CREATE TABLE IF NOT EXISTS delivery_failures (
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    order_id TEXT NOT NULL,
+    attempt INT NOT NULL,
+    courier_id TEXT NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    return_order_id TEXT,
+    failed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, order_id, attempt)
+);
+CREATE INDEX IF NOT EXISTS idx_delivery_failures_failed_at ON delivery_failures (tenant_id, failed_at);
+
+-- Выручка в валютах, которые нельзя привести к валюте отчётности (delivery_calculated без пригодного курса):
+-- хранится в исходной валюте и показывается в статистике курьеров отдельно, а не отбрасывается
+CREATE TABLE IF NOT EXISTS courier_revenue_unconverted (
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    courier_id TEXT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    revenue NUMERIC NOT NULL DEFAULT 0,
+    discount NUMERIC NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, courier_id, currency)
+);
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"time"
@@ -13,16 +14,33 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
 	"github.com/streadway/amqp"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 var db *sql.DB
 
+// reportingCurrency — валюта, к которой приводятся все денежные показатели аналитики.
+var reportingCurrency = "RUB"
+
 type CourierStat struct {
+	TenantID              string  `json:"tenant_id"`
 	CourierID             string  `json:"courier_id"`
 	CourierName           string  `json:"courier_name"`
 	CompletedOrders       int     `json:"completed_orders"`
 	TotalRevenue          float64 `json:"total_revenue"`
+	TotalDiscount         float64 `json:"total_discount"`
+	Currency              string  `json:"currency"`
 	AverageDeliveryTimeSec float64 `json:"average_delivery_time_sec"`
+	// Unconverted — выручка в валютах, которые не удалось привести к валюте отчётности;
+	// в total_revenue она не входит.
+	Unconverted []UnconvertedRevenue `json:"unconverted,omitempty"`
+}
+
+// UnconvertedRevenue — выручка и скидки курьера в исходной валюте расчёта.
+type UnconvertedRevenue struct {
+	Currency string  `json:"currency"`
+	Revenue  float64 `json:"revenue"`
+	Discount float64 `json:"discount"`
 }
 
 // initDB устанавливает соединение с базой данных аналитики.
@@ -133,6 +151,35 @@ func startOrderCompletedConsumer() {
 	}()
 }
 
+func startOrderCancelledConsumer() {
+	rabbitURL := os.Getenv("RABBITMQ_URL")
+	conn, err := amqp.Dial(rabbitURL)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к RabbitMQ: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Ошибка канала: %v", err)
+	}
+
+	_, err = ch.QueueDeclare("order_cancelled", true, false, false, false, nil)
+	if err != nil {
+		log.Fatalf("Ошибка объявления очереди: %v", err)
+	}
+
+	msgs, err := ch.Consume("order_cancelled", "", true, false, false, false, nil)
+	if err != nil {
+		log.Fatalf("Ошибка consume: %v", err)
+	}
+
+	go func() {
+		for msg := range msgs {
+			log.Printf("Получена отмена заказа: %s", msg.Body)
+			handleOrderCancelled(msg.Body)
+		}
+	}()
+}
+
 func startDeliveryCalculatedConsumer() {
 	rabbitURL := os.Getenv("RABBITMQ_URL")
 	conn, err := amqp.Dial(rabbitURL)
@@ -162,15 +209,40 @@ func startDeliveryCalculatedConsumer() {
 	}()
 }
 
+// eventTenant — организация из события; события старых версий сервисов без tenant_id
+// относятся к организации по умолчанию.
+func eventTenant(tenantID string) string {
+	if tenantID == "" {
+		return auth.DefaultTenant
+	}
+	return tenantID
+}
+
+// execer покрывает *sql.DB и *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// ensureGeneralStats создаёт строку общей статистики организации, если её ещё нет.
+func ensureGeneralStats(exec execer, tenantID string) error {
+	_, err := exec.Exec(`INSERT INTO general_stats (tenant_id) VALUES ($1) ON CONFLICT (tenant_id) DO NOTHING`, tenantID)
+	return err
+}
+
 func handleOrderCreated(body []byte) {
 	var evt map[string]string
 	if err := json.Unmarshal(body, &evt); err != nil {
 		log.Printf("Некорректный JSON в событии: %v", err)
 		return
 	}
+	tenantID := eventTenant(evt["tenant_id"])
+	if err := ensureGeneralStats(db, tenantID); err != nil {
+		log.Printf("Ошибка создания general_stats для %s: %v", tenantID, err)
+		return
+	}
 
 	if evt["event"] == "order_created" {
-		_, err := db.Exec(`UPDATE general_stats SET total_orders = total_orders + 1`)
+		_, err := db.Exec(`UPDATE general_stats SET total_orders = total_orders + 1 WHERE tenant_id = $1`, tenantID)
 		if err != nil {
 			log.Printf("Ошибка увеличения total_orders: %v", err)
 		} else {
@@ -179,7 +251,7 @@ func handleOrderCreated(body []byte) {
 	}
 
 	if evt["status"] == "новый" {
-		_, err := db.Exec(`UPDATE general_stats SET active_orders = active_orders + 1`)
+		_, err := db.Exec(`UPDATE general_stats SET active_orders = active_orders + 1 WHERE tenant_id = $1`, tenantID)
 		if err != nil {
 			log.Printf("Ошибка увеличения active_orders: %v", err)
 		} else {
@@ -201,12 +273,13 @@ func handleCourierCreated(body []byte) {
 
 	courierID := evt["courier_id"]
 	courierName := evt["courier_name"]
+	tenantID := eventTenant(evt["tenant_id"])
 
 	_, err := db.Exec(`
-		INSERT INTO courier_stats (courier_id, courier_name, completed_orders, total_revenue, average_delivery_time_sec)
-		VALUES ($1, $2, 0, 0, 0)
-		ON CONFLICT (courier_id) DO NOTHING
-	`, courierID, courierName)
+		INSERT INTO courier_stats (courier_id, courier_name, completed_orders, total_revenue, average_delivery_time_sec, tenant_id)
+		VALUES ($1, $2, 0, 0, 0, $3)
+		ON CONFLICT (tenant_id, courier_id) DO NOTHING
+	`, courierID, courierName, tenantID)
 
 	if err != nil {
 		log.Printf("Ошибка вставки courier_stats: %v", err)
@@ -216,6 +289,27 @@ func handleCourierCreated(body []byte) {
 }
 
 
+// closeActiveOrder уменьшает число активных заказов организации: заказ вручён, возвращён или отменён.
+func closeActiveOrder(exec execer, tenantID string) error {
+	_, err := exec.Exec(`UPDATE general_stats SET active_orders = active_orders - 1 WHERE tenant_id = $1 AND active_orders > 0`, tenantID)
+	return err
+}
+
+// handleOrderCancelled снимает отменённый заказ из активных. order-service отменяет заказ только один раз.
+func handleOrderCancelled(body []byte) {
+	var evt map[string]string
+	if err := json.Unmarshal(body, &evt); err != nil {
+		log.Printf("Некорректный JSON в order_cancelled: %v", err)
+		return
+	}
+	if evt["event"] != "order_cancelled" {
+		return
+	}
+	if err := closeActiveOrder(db, eventTenant(evt["tenant_id"])); err != nil {
+		log.Printf("Ошибка уменьшения active_orders: %v", err)
+	}
+}
+
 func handleOrderCompleted(body []byte) {
 	var evt map[string]string
 	if err := json.Unmarshal(body, &evt); err != nil {
@@ -235,6 +329,7 @@ func handleOrderCompleted(body []byte) {
 			log.Println("Нет courier_id в событии завершения заказа")
 			return
 		}
+		tenantID := eventTenant(evt["tenant_id"])
 
 		createdAt, err1 := time.Parse(time.RFC3339, evt["created_at"])
 		completedAt, err2 := time.Parse(time.RFC3339, evt["completed_at"])
@@ -248,8 +343,8 @@ func handleOrderCompleted(body []byte) {
 		var currentAvg float64
 		err = db.QueryRow(`
 			SELECT completed_orders, average_delivery_time_sec
-			FROM courier_stats WHERE courier_id = $1
-		`, courierID).Scan(&currentCount, &currentAvg)
+			FROM courier_stats WHERE tenant_id = $1 AND courier_id = $2
+		`, tenantID, courierID).Scan(&currentCount, &currentAvg)
 
 		if err != nil && err != sql.ErrNoRows {
 			log.Printf("Ошибка получения текущих данных courier_stats: %v", err)
@@ -261,30 +356,40 @@ func handleOrderCompleted(body []byte) {
 
 		// Вставка или обновление
 		_, err = db.Exec(`
-			INSERT INTO courier_stats (courier_id, completed_orders, average_delivery_time_sec, total_revenue)
-			VALUES ($1, $2, $3, 0)
-			ON CONFLICT (courier_id) DO UPDATE
+			INSERT INTO courier_stats (courier_id, completed_orders, average_delivery_time_sec, total_revenue, tenant_id)
+			VALUES ($1, $2, $3, 0, $4)
+			ON CONFLICT (tenant_id, courier_id) DO UPDATE
 			SET completed_orders = $2,
 				average_delivery_time_sec = $3
-		`, courierID, newCount, newAvg)
+		`, courierID, newCount, newAvg, tenantID)
 
 		if err != nil {
 			log.Printf("Ошибка обновления courier_stats: %v", err)
 		} else {
 			log.Printf("Обновлена статистика курьера %s: заказов %d, среднее время %.2f сек", courierID, newCount, newAvg)
 		}
-		_, err = tx.Exec(`UPDATE general_stats SET completed_orders = completed_orders + 1`)
+		if err := ensureGeneralStats(tx, tenantID); err != nil {
+			log.Printf("Ошибка создания general_stats для %s: %v", tenantID, err)
+			return
+		}
+		_, err = tx.Exec(`UPDATE general_stats SET completed_orders = completed_orders + 1 WHERE tenant_id = $1`, tenantID)
 		if err != nil {
 			log.Printf("Ошибка обновления completed_orders: %v", err)
 			return
 		}
 
-		_, err = tx.Exec(`UPDATE general_stats SET active_orders = active_orders - 1 WHERE active_orders > 0`)
-		if err != nil {
+		if err := closeActiveOrder(tx, tenantID); err != nil {
 			log.Printf("Ошибка уменьшения active_orders: %v", err)
 			return
 		}
 
+		if dueAt, err := time.Parse(time.RFC3339, evt["due_at"]); err == nil {
+			if err := recordSLAOutcome(tx, tenantID, evt["order_id"], courierID, dueAt, completedAt); err != nil {
+				log.Printf("Ошибка записи SLA заказа %s: %v", evt["order_id"], err)
+				return
+			}
+		}
+
 		if err := tx.Commit(); err != nil {
 			log.Printf("Ошибка коммита: %v", err)
 		} else {
@@ -306,33 +411,78 @@ func HandleDeliveryCalculated(body []byte) {
 
 	courierID, ok1 := evt["courier_id"].(string)
 	cost, ok2 := evt["cost"].(float64)
+	// discount — скидка по промокоду; cost уже приходит за вычетом скидки
+	discount, _ := evt["discount"].(float64)
+	evtCurrency, _ := evt["currency"].(string)
+	baseCurrency, _ := evt["base_currency"].(string)
+	rate, _ := evt["exchange_rate"].(float64)
+	tenantID, _ := evt["tenant_id"].(string)
+	tenantID = eventTenant(tenantID)
 
 	if !ok1 || !ok2 || courierID == "" {
 		log.Printf("Пропущены поля в delivery_calculated: courier_id или cost")
 		return
 	}
 
+	converted, ok := toReportingCurrency(cost, evtCurrency, baseCurrency, rate)
+	if !ok {
+		// Сумма не теряется и не смешивается с валютой отчётности: копится отдельно в исходной валюте
+		// и показывается в статистике курьера как unconverted.
+		log.Printf("Нельзя привести %s к %s (база %s, курс %v), выручка учтена в исходной валюте", evtCurrency, reportingCurrency, baseCurrency, rate)
+		_, err := db.Exec(`
+			INSERT INTO courier_revenue_unconverted (tenant_id, courier_id, currency, revenue, discount)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (tenant_id, courier_id, currency) DO UPDATE
+			SET revenue = courier_revenue_unconverted.revenue + $4,
+				discount = courier_revenue_unconverted.discount + $5
+		`, tenantID, courierID, evtCurrency, cost, discount)
+		if err != nil {
+			log.Printf("Ошибка записи выручки в %s: %v", evtCurrency, err)
+		}
+		return
+	}
+	cost = converted
+	discount, _ = toReportingCurrency(discount, evtCurrency, baseCurrency, rate)
+
 	_, err := db.Exec(`
-		INSERT INTO courier_stats (courier_id, total_revenue, completed_orders, average_delivery_time_sec)
-		VALUES ($1, $2, 0, 0)
-		ON CONFLICT (courier_id) DO UPDATE
-		SET total_revenue = courier_stats.total_revenue + $2
-	`, courierID, cost)
+		INSERT INTO courier_stats (courier_id, total_revenue, total_discount, completed_orders, average_delivery_time_sec, tenant_id)
+		VALUES ($1, $2, $3, 0, 0, $4)
+		ON CONFLICT (tenant_id, courier_id) DO UPDATE
+		SET total_revenue = courier_stats.total_revenue + $2,
+			total_discount = courier_stats.total_discount + $3
+	`, courierID, cost, discount, tenantID)
 
 	if err != nil {
 		log.Printf("Ошибка обновления revenue в courier_stats: %v", err)
 		return
 	}
 
-	log.Printf("Обновлена выручка курьера %s: +%.2f", courierID, cost)
+	log.Printf("Обновлена выручка курьера %s: +%.2f (скидка %.2f)", courierID, cost, discount)
 }
 
+// toReportingCurrency переводит сумму события в валюту отчётности.
+// Курс в событии задан к базовой валюте тарифа, поэтому перевести можно, если валюта события
+// или базовая валюта совпадает с валютой отчётности. События без валюты считаются
+// рассчитанными в валюте отчётности (так публиковались старые версии delivery-service).
+func toReportingCurrency(amount float64, currency, baseCurrency string, rate float64) (float64, bool) {
+	switch {
+	case currency == "" || currency == reportingCurrency:
+		return amount, true
+	case baseCurrency == reportingCurrency && rate > 0:
+		return math.Round(amount*rate*100) / 100, true
+	}
+	return 0, false
+}
+
+// getCourierStats — статистика курьеров своей организации (администратору платформы — всех или ?tenant_id=).
 func getCourierStats(c *gin.Context) {
+	where, args := auth.TenantScope(c, 1)
 	rows, err := db.Query(`
-		SELECT courier_id, COALESCE(courier_name, ''), completed_orders, total_revenue, average_delivery_time_sec
+		SELECT tenant_id, courier_id, COALESCE(courier_name, ''), completed_orders, total_revenue, total_discount, average_delivery_time_sec
 		FROM courier_stats
+		WHERE `+where+`
 		ORDER BY total_revenue DESC
-	`)
+	`, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -342,12 +492,41 @@ func getCourierStats(c *gin.Context) {
 	var list []CourierStat
 	for rows.Next() {
 		var stat CourierStat
-		if err := rows.Scan(&stat.CourierID, &stat.CourierName, &stat.CompletedOrders, &stat.TotalRevenue, &stat.AverageDeliveryTimeSec); err != nil {
+		if err := rows.Scan(&stat.TenantID, &stat.CourierID, &stat.CourierName, &stat.CompletedOrders, &stat.TotalRevenue, &stat.TotalDiscount, &stat.AverageDeliveryTimeSec); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		stat.Currency = reportingCurrency
 		list = append(list, stat)
 	}
+
+	rows, err = db.Query(`
+		SELECT tenant_id, courier_id, currency, revenue, discount
+		FROM courier_revenue_unconverted
+		WHERE `+where+`
+		ORDER BY tenant_id, courier_id, currency
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tenantID, courierID string
+		var u UnconvertedRevenue
+		if err := rows.Scan(&tenantID, &courierID, &u.Currency, &u.Revenue, &u.Discount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		i := 0
+		for i < len(list) && (list[i].TenantID != tenantID || list[i].CourierID != courierID) {
+			i++
+		}
+		if i == len(list) {
+			list = append(list, CourierStat{TenantID: tenantID, CourierID: courierID, Currency: reportingCurrency})
+		}
+		list[i].Unconverted = append(list[i].Unconverted, u)
+	}
 	c.JSON(http.StatusOK, list)
 }
 
@@ -357,11 +536,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Ошибка подключения к БД: %v", err)
 	}
+	if rc := os.Getenv("REPORTING_CURRENCY"); rc != "" {
+		reportingCurrency = rc
+	}
 	go startRabbitConsumer()
 	go startCourierCreatedConsumer()
 	go startOrderCompletedConsumer()
+	go startOrderCancelledConsumer()
 	go startDeliveryCalculatedConsumer()
+	go startDeliveryFailedConsumer()
+
 
+	// Список отозванных токенов подтягивается из auth-service (AUTH_URL).
+	auth.StartRevocationSync()
 
 	r := gin.Default()
 	corsConfig := cors.Config{
@@ -380,8 +567,14 @@ func main() {
 	})
 
 	// Общая статистика заказов с фильтром по периоду
-	r.GET("/analytics/general", getGeneralStats)
-	r.GET("/analytics/couriers", getCourierStats)
+	r.GET("/analytics/general", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getGeneralStats)
+	r.GET("/analytics/couriers", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getCourierStats)
+	// Соблюдение SLA по курьерам и периодам
+	r.GET("/analytics/sla", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getSLAStats)
+	// Неудачные попытки доставки и возвраты отправителю
+	r.GET("/analytics/failed-deliveries", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getFailedDeliveries)
+	// Сводка по всем организациям — только администратору платформы
+	r.GET("/analytics/tenants", auth.Required(auth.RoleAdmin), auth.CrossTenantRequired(), getTenantStats)
 
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("Ошибка запуска сервера: %v", err)
@@ -396,50 +589,58 @@ type GeneralStatsResponse struct {
 	AverageCompletionTimeSecs float64 `json:"average_completion_time_seconds"`
 }
 
-// getGeneralStats вычисляет метрики по заказам за выбранный период.
-func getGeneralStats(c *gin.Context) {
-	// Парсим параметры периода
-	fromParam := c.Query("from")
-	toParam := c.Query("to")
-	var fromTime, toTime time.Time
-	var err error
-	if fromParam != "" {
-		fromTime, err = time.Parse("2006-01-02", fromParam)
+// parsePeriod разбирает параметры периода ?from=&to= (YYYY-MM-DD, to — включительно).
+// Без from — с начала времён, без to — по текущий момент. При ошибке отвечает 400 и возвращает ok=false.
+func parsePeriod(c *gin.Context) (from, to time.Time, ok bool) {
+	if p := c.Query("from"); p != "" {
+		t, err := time.Parse("2006-01-02", p)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date format, use YYYY-MM-DD"})
-			return
+			return from, to, false
 		}
-	} else {
-		fromTime = time.Time{} // минимум
+		from = t
 	}
-	if toParam != "" {
-		// конец дня
-		tmp, err := time.Parse("2006-01-02", toParam)
+	to = time.Now()
+	if p := c.Query("to"); p != "" {
+		t, err := time.Parse("2006-01-02", p)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date format, use YYYY-MM-DD"})
-			return
+			return from, to, false
 		}
-		toTime = tmp.Add(24*time.Hour - time.Nanosecond)
-	} else {
-		toTime = time.Now()
+		// конец дня
+		to = t.Add(24*time.Hour - time.Nanosecond)
 	}
+	return from, to, true
+}
+
+// getGeneralStats вычисляет метрики по заказам за выбранный период.
+func getGeneralStats(c *gin.Context) {
+	fromTime, toTime, ok := parsePeriod(c)
+	if !ok {
+		return
+	}
+	var err error
+
+	// Заказы только своей организации (администратору платформы — всех или ?tenant_id=)
+	tenant, tenantArgs := auth.TenantScope(c, 3)
+	period := append([]any{fromTime, toTime}, tenantArgs...)
 
 	// 1. Общее количество заказов
 	var total int
 	err = db.QueryRow(
-		`SELECT COUNT(*) FROM orders WHERE created_at >= $1 AND created_at <= $2`,
-		fromTime, toTime,
+		`SELECT COUNT(*) FROM orders WHERE created_at >= $1 AND created_at <= $2 AND `+tenant,
+		period...,
 	).Scan(&total)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 2. Активные заказы (status != 'завершён')
+	// 2. Активные заказы: не вручены, не возвращены и не отменены
 	var active int
 	err = db.QueryRow(
-		`SELECT COUNT(*) FROM orders WHERE status <> 'завершён' AND created_at >= $1 AND created_at <= $2`,
-		fromTime, toTime,
+		`SELECT COUNT(*) FROM orders WHERE completed_at IS NULL AND COALESCE(status, '') NOT IN ('возврат', 'отменён') AND created_at >= $1 AND created_at <= $2 AND `+tenant,
+		period...,
 	).Scan(&active)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -449,8 +650,8 @@ func getGeneralStats(c *gin.Context) {
 	// 3. Выполненные заказы
 	var completed int
 	err = db.QueryRow(
-		`SELECT COUNT(*) FROM orders WHERE status = 'завершён' AND completed_at >= $1 AND completed_at <= $2`,
-		fromTime, toTime,
+		`SELECT COUNT(*) FROM orders WHERE status = 'завершён' AND completed_at >= $1 AND completed_at <= $2 AND `+tenant,
+		period...,
 	).Scan(&completed)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -460,8 +661,8 @@ func getGeneralStats(c *gin.Context) {
 	// 4. Среднее время выполнения (сек)
 	var avgSecs sql.NullFloat64
 	err = db.QueryRow(
-		`SELECT EXTRACT(EPOCH FROM AVG(completed_at - created_at)) FROM orders WHERE status = 'завершён' AND completed_at >= $1 AND completed_at <= $2`,
-		fromTime, toTime,
+		`SELECT EXTRACT(EPOCH FROM AVG(completed_at - created_at)) FROM orders WHERE status = 'завершён' AND completed_at >= $1 AND completed_at <= $2 AND `+tenant,
+		period...,
 	).Scan(&avgSecs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -480,3 +681,32 @@ func getGeneralStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// TenantStats — накопленные счётчики заказов одной организации.
+type TenantStats struct {
+	TenantID        string `json:"tenant_id"`
+	TotalOrders     int    `json:"total_orders"`
+	ActiveOrders    int    `json:"active_orders"`
+	CompletedOrders int    `json:"completed_orders"`
+}
+
+// getTenantStats — сводка general_stats по всем организациям для администратора платформы.
+func getTenantStats(c *gin.Context) {
+	rows, err := db.Query(`SELECT tenant_id, total_orders, active_orders, completed_orders FROM general_stats ORDER BY tenant_id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	list := []TenantStats{}
+	for rows.Next() {
+		var s TenantStats
+		if err := rows.Scan(&s.TenantID, &s.TotalOrders, &s.ActiveOrders, &s.CompletedOrders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, s)
+	}
+	c.JSON(http.StatusOK, list)
+}
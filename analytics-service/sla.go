@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// SLAStat — соблюдение SLA: сколько завершённых заказов имели срок и сколько доставлены вовремя.
+type SLAStat struct {
+	TrackedOrders int     `json:"tracked_orders"`
+	MetOrders     int     `json:"met_orders"`
+	CompliancePct float64 `json:"compliance_pct"`
+}
+
+// CourierSLAStat — соблюдение SLA курьером за период.
+type CourierSLAStat struct {
+	CourierID   string `json:"courier_id"`
+	CourierName string `json:"courier_name"`
+	SLAStat
+}
+
+// PeriodSLAStat — соблюдение SLA за день, неделю или месяц (начало интервала в Period).
+type PeriodSLAStat struct {
+	Period time.Time `json:"period"`
+	SLAStat
+}
+
+// SLAStatsResponse — ответ GET /analytics/sla.
+type SLAStatsResponse struct {
+	Overall  SLAStat          `json:"overall"`
+	GroupBy  string           `json:"group_by"`
+	Periods  []PeriodSLAStat  `json:"periods"`
+	Couriers []CourierSLAStat `json:"couriers"`
+}
+
+// slaGroups — допустимые значения ?group_by= (подставляются в date_trunc).
+var slaGroups = map[string]bool{"day": true, "week": true, "month": true}
+
+func newSLAStat(tracked, met int) SLAStat {
+	s := SLAStat{TrackedOrders: tracked, MetOrders: met}
+	if tracked > 0 {
+		s.CompliancePct = math.Round(float64(met)*1000/float64(tracked)) / 10
+	}
+	return s
+}
+
+// recordSLAOutcome сохраняет, уложился ли завершённый заказ в обещанный срок. Повторное событие по заказу игнорируется.
+func recordSLAOutcome(exec execer, tenantID, orderID, courierID string, dueAt, completedAt time.Time) error {
+	_, err := exec.Exec(`
+		INSERT INTO sla_outcomes (tenant_id, order_id, courier_id, due_at, completed_at, met)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, order_id) DO NOTHING
+	`, tenantID, orderID, courierID, dueAt, completedAt, !completedAt.After(dueAt))
+	return err
+}
+
+// getSLAStats — GET /analytics/sla?from=&to=&group_by=day|week|month: доля заказов, доставленных в срок,
+// по периодам и по курьерам. Учитываются заказы со сроком, завершённые в выбранном периоде.
+func getSLAStats(c *gin.Context) {
+	from, to, ok := parsePeriod(c)
+	if !ok {
+		return
+	}
+	group := c.DefaultQuery("group_by", "day")
+	if !slaGroups[group] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by: day, week или month"})
+		return
+	}
+	tenant, tenantArgs := auth.TenantScope(c, 3)
+	args := append([]any{from, to}, tenantArgs...)
+	outcomes := `SELECT * FROM sla_outcomes WHERE completed_at >= $1 AND completed_at <= $2 AND ` + tenant
+
+	resp := SLAStatsResponse{GroupBy: group, Periods: []PeriodSLAStat{}, Couriers: []CourierSLAStat{}}
+	rows, err := db.Query(`
+		SELECT date_trunc('`+group+`', completed_at) AS period, COUNT(*), COUNT(*) FILTER (WHERE met)
+		FROM (`+outcomes+`) s
+		GROUP BY period ORDER BY period`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var tracked, met int
+	for rows.Next() {
+		var p PeriodSLAStat
+		var n, m int
+		if err := rows.Scan(&p.Period, &n, &m); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		p.SLAStat = newSLAStat(n, m)
+		resp.Periods = append(resp.Periods, p)
+		tracked, met = tracked+n, met+m
+	}
+	resp.Overall = newSLAStat(tracked, met)
+
+	rows, err = db.Query(`
+		SELECT s.courier_id, COALESCE(cs.courier_name, ''), COUNT(*), COUNT(*) FILTER (WHERE s.met)
+		FROM (`+outcomes+`) s
+		LEFT JOIN courier_stats cs ON cs.tenant_id = s.tenant_id AND cs.courier_id = s.courier_id
+		GROUP BY s.courier_id, cs.courier_name
+		ORDER BY s.courier_id`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s CourierSLAStat
+		var n, m int
+		if err := rows.Scan(&s.CourierID, &s.CourierName, &n, &m); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.SLAStat = newSLAStat(n, m)
+		resp.Couriers = append(resp.Couriers, s)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestNewSLAStat(t *testing.T) {
+	assert.Equal(t, SLAStat{TrackedOrders: 3, MetOrders: 2, CompliancePct: 66.7}, newSLAStat(3, 2))
+	assert.Equal(t, SLAStat{}, newSLAStat(0, 0))
+}
+
+func TestHandleOrderCompletedRecordsSLA(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	createdAt := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+	completedAt := time.Now().UTC().Truncate(time.Second)
+	dueAt := completedAt.Add(-time.Minute) // опоздание на минуту
+	body, _ := json.Marshal(map[string]string{
+		"event": "order_completed", "status": "завершён", "order_id": "o-1", "courier_id": "c1", "tenant_id": "shop-1",
+		"created_at": createdAt.Format(time.RFC3339), "completed_at": completedAt.Format(time.RFC3339),
+		"due_at": dueAt.Format(time.RFC3339),
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT completed_orders, average_delivery_time_sec").
+		WillReturnRows(sqlmock.NewRows([]string{"completed_orders", "average_delivery_time_sec"}))
+	mock.ExpectExec("INSERT INTO courier_stats").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO general_stats").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE general_stats SET completed_orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE general_stats SET active_orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO sla_outcomes").
+		WithArgs("shop-1", "o-1", "c1", dueAt, completedAt, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handleOrderCompleted(body)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSLAStats(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	day1 := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("date_trunc\\('week', completed_at\\).*FROM \\(SELECT \\* FROM sla_outcomes WHERE .* AND tenant_id = \\$3\\)").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "count", "met"}).
+			AddRow(day1, 4, 3).
+			AddRow(day1.AddDate(0, 0, 7), 6, 6))
+	mock.ExpectQuery("SELECT s.courier_id.*LEFT JOIN courier_stats").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"courier_id", "courier_name", "count", "met"}).
+			AddRow("c1", "Ivan", 10, 9))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/analytics/sla?from=2024-05-01&to=2024-05-31&group_by=week", nil)
+	c.Set("claims", &auth.Claims{Role: auth.RoleAnalyst, TenantID: "shop-1"})
+
+	getSLAStats(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp SLAStatsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, SLAStat{TrackedOrders: 10, MetOrders: 9, CompliancePct: 90}, resp.Overall)
+	assert.Len(t, resp.Periods, 2)
+	assert.Equal(t, 75.0, resp.Periods[0].CompliancePct)
+	assert.Equal(t, []CourierSLAStat{{CourierID: "c1", CourierName: "Ivan", SLAStat: SLAStat{TrackedOrders: 10, MetOrders: 9, CompliancePct: 90}}}, resp.Couriers)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSLAStatsValidation(t *testing.T) {
+	for _, q := range []string{"?group_by=year", "?from=01.05.2024"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/analytics/sla"+q, nil)
+		c.Set("claims", &auth.Claims{Role: auth.RoleAdmin})
+		getSLAStats(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code, q)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// APIKey — ключ B2B-клиента (организации) для работы с API без входа в дашборд. Сам ключ
+// показывается один раз при создании, в БД хранится только его SHA-256.
+type APIKey struct {
+	ID         string     `json:"id"`
+	TenantID   string     `json:"tenant_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"` // запросов в минуту, 0 — без ограничения
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// apiKeyPrefix отличает API-ключи от прочих секретов (например, при поиске утечек в логах).
+// Формат ключа: klk_<id>_<секрет>, id — открытая часть, по которой ключ ищется в БД.
+const apiKeyPrefix = "klk_"
+
+var allScopes = []string{auth.ScopeOrdersRead, auth.ScopeOrdersWrite}
+
+// defaultAPIKeyRateLimit — лимит для ключей, созданных без явного rate_limit (env API_KEY_RATE_LIMIT).
+var defaultAPIKeyRateLimit = 60
+
+const apiKeyColumns = "id, tenant_id, name, scopes, rate_limit, created_by, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.TenantID, &k.Name, pq.Array(&k.Scopes), &k.RateLimit, &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// splitAPIKey выделяет из ключа его id.
+func splitAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	return id, ok && id != "" && secret != ""
+}
+
+// validScopes проверяет, что scopes непусты и все известны.
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, s := range scopes {
+		known := false
+		for _, a := range allScopes {
+			known = known || s == a
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// localAPIKey проверяет ключ по БД; так же, через POST /api-keys/introspect, его проверяют остальные сервисы.
+// Неизвестный, отозванный или неверный ключ — не ошибка, а Active: false.
+func localAPIKey(key string) (auth.APIKeyInfo, error) {
+	id, ok := splitAPIKey(key)
+	if !ok {
+		return auth.APIKeyInfo{}, nil
+	}
+	var hash string
+	var revoked bool
+	info := auth.APIKeyInfo{KeyID: id}
+	err := db.QueryRow("SELECT key_hash, tenant_id, scopes, rate_limit, revoked_at IS NOT NULL FROM api_keys WHERE id = $1", id).
+		Scan(&hash, &info.TenantID, pq.Array(&info.Scopes), &info.RateLimit, &revoked)
+	if err == sql.ErrNoRows {
+		return auth.APIKeyInfo{}, nil
+	}
+	if err != nil {
+		return auth.APIKeyInfo{}, err
+	}
+	if revoked || subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(key))) != 1 {
+		return auth.APIKeyInfo{}, nil
+	}
+	if _, err := db.Exec("UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", id); err != nil {
+		log.Printf("Не удалось обновить last_used_at ключа %s: %v", id, err)
+	}
+	info.Active = true
+	return info, nil
+}
+
+// POST /api-keys/introspect — проверка ключа для других сервисов (только роль service).
+func introspectAPIKeyHandler(c *gin.Context) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	info, err := localAPIKey(req.Key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// GET /api-keys?tenant_id= — ключи своей организации (администратору платформы — всех или одной).
+func listAPIKeysHandler(c *gin.Context) {
+	where, args := auth.TenantScope(c, 1)
+	rows, err := db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE "+where+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// POST /api-keys — выпускает ключ для организации (администратор организации — только для своей).
+// Ключ возвращается один раз в поле key.
+func createAPIKeyHandler(c *gin.Context) {
+	var req struct {
+		TenantID  string   `json:"tenant_id"`
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit *int     `json:"rate_limit"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	claims := auth.CurrentClaims(c)
+	k := APIKey{
+		TenantID:  claims.TenantID,
+		Name:      strings.TrimSpace(req.Name),
+		Scopes:    req.Scopes,
+		RateLimit: defaultAPIKeyRateLimit,
+		CreatedBy: claims.Subject,
+		CreatedAt: time.Now(),
+	}
+	if auth.CrossTenant(claims) {
+		k.TenantID = strings.TrimSpace(req.TenantID)
+	}
+	if k.TenantID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указана организация"})
+		return
+	}
+	if !validScopes(k.Scopes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Допустимые scopes: " + strings.Join(allScopes, ", ")})
+		return
+	}
+	if req.RateLimit != nil {
+		if *req.RateLimit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rate_limit не может быть отрицательным"})
+			return
+		}
+		k.RateLimit = *req.RateLimit
+	}
+	id, err := randomToken(9)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// в id не должно быть '_': это разделитель частей ключа
+	k.ID = strings.ReplaceAll(id, "_", "-")
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key := apiKeyPrefix + k.ID + "_" + secret
+	_, err = db.Exec(`INSERT INTO api_keys (id, key_hash, tenant_id, name, scopes, rate_limit, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		k.ID, hashToken(key), k.TenantID, k.Name, pq.Array(k.Scopes), k.RateLimit, k.CreatedBy, k.CreatedAt)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Администратор %s выпустил API-ключ %s для %s", k.CreatedBy, k.ID, k.TenantID)
+	c.JSON(http.StatusCreated, gin.H{"api_key": k, "key": key})
+}
+
+// DELETE /api-keys/:id — отзывает ключ. Сервисы перестают его принимать после истечения кэша (apiKeyCacheTTL в pkg/auth).
+func revokeAPIKeyHandler(c *gin.Context) {
+	where, args := auth.TenantScope(c, 2)
+	res, err := db.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL AND "+where,
+		append([]any{c.Param("id")}, args...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ключ не найден или уже отозван"})
+		return
+	}
+	log.Printf("Администратор %s отозвал API-ключ %s", auth.CurrentClaims(c).Subject, c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"status": "Ключ отозван"})
+}
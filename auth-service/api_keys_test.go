@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func setupAPIKeysRouter(mockDB *sql.DB) *gin.Engine {
+	r := setupTestRouter(mockDB)
+	r.POST("/api-keys/introspect", auth.Required(auth.RoleService), introspectAPIKeyHandler)
+	keys := r.Group("/api-keys", auth.Required(auth.RoleAdmin))
+	keys.POST("", createAPIKeyHandler)
+	keys.DELETE("/:id", revokeAPIKeyHandler)
+	return r
+}
+
+func expectAPIKeySelect(mock sqlmock.Sqlmock, id, key string, revoked bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT key_hash, tenant_id, scopes, rate_limit, revoked_at IS NOT NULL FROM api_keys WHERE id = $1")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"key_hash", "tenant_id", "scopes", "rate_limit", "revoked"}).
+			AddRow(hashToken(key), "shop-1", "{orders:write}", 60, revoked))
+}
+
+func TestCreateAPIKey_ReturnsKeyOnceAndStoresHash(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_keys")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1", "Интеграция", sqlmock.AnyArg(), 120, "root", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := doAuthorized(t, setupAPIKeysRouter(mockDB), "POST", "/api-keys", testAdmin,
+		gin.H{"tenant_id": "shop-1", "name": "Интеграция", "scopes": []string{auth.ScopeOrdersWrite}, "rate_limit": 120})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		APIKey APIKey `json:"api_key"`
+		Key    string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, strings.HasPrefix(resp.Key, apiKeyPrefix+resp.APIKey.ID+"_"))
+	id, ok := splitAPIKey(resp.Key)
+	assert.True(t, ok)
+	assert.Equal(t, resp.APIKey.ID, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAPIKey_Validation(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	r := setupAPIKeysRouter(mockDB)
+
+	for _, body := range []gin.H{
+		{"scopes": []string{auth.ScopeOrdersRead}},
+		{"tenant_id": "shop-1"},
+		{"tenant_id": "shop-1", "scopes": []string{"orders:delete"}},
+		{"tenant_id": "shop-1", "scopes": []string{auth.ScopeOrdersRead}, "rate_limit": -1},
+	} {
+		w := doAuthorized(t, r, "POST", "/api-keys", testAdmin, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLocalAPIKey(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	key := apiKeyPrefix + "abc_secret"
+
+	expectAPIKeySelect(mock, "abc", key, false)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE api_keys SET last_used_at = NOW() WHERE id = $1")).
+		WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	info, err := localAPIKey(key)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.APIKeyInfo{Active: true, KeyID: "abc", TenantID: "shop-1", Scopes: []string{auth.ScopeOrdersWrite}, RateLimit: 60}, info)
+
+	// тот же id, но другой секрет
+	expectAPIKeySelect(mock, "abc", key, false)
+	info, err = localAPIKey(apiKeyPrefix + "abc_forged")
+	assert.NoError(t, err)
+	assert.False(t, info.Active)
+
+	expectAPIKeySelect(mock, "abc", key, true)
+	info, err = localAPIKey(key)
+	assert.NoError(t, err)
+	assert.False(t, info.Active)
+
+	info, err = localAPIKey("not-a-key")
+	assert.NoError(t, err)
+	assert.False(t, info.Active)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIntrospectAPIKey_RequiresServiceRole(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	r := setupAPIKeysRouter(mockDB)
+
+	w := doAuthorized(t, r, "POST", "/api-keys/introspect", testAdmin, gin.H{"key": "klk_abc_secret"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	expectAPIKeySelect(mock, "abc", "klk_abc_secret", true)
+	w = doAuthorized(t, r, "POST", "/api-keys/introspect", User{Username: "service:order-service", Role: auth.RoleService}, gin.H{"key": "klk_abc_secret"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"active": false}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE api_keys SET revoked_at = NOW()")).
+		WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	r := setupAPIKeysRouter(mockDB)
+
+	assert.Equal(t, http.StatusOK, doAuthorized(t, r, "DELETE", "/api-keys/abc", testAdmin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doAuthorized(t, r, "DELETE", "/api-keys/abc", testAdmin, nil).Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeys_TenantAdminBoundToOwnTenant(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	tenantAdmin := User{ID: 2, Username: "shop-admin", Role: auth.RoleAdmin, TenantID: "shop-1"}
+	// tenant_id из запроса игнорируется: ключ выпускается для своей организации
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_keys")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "shop-1", "", sqlmock.AnyArg(), defaultAPIKeyRateLimit, "shop-admin", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL AND tenant_id = $2")).
+		WithArgs("abc", "shop-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	r := setupAPIKeysRouter(mockDB)
+
+	w := doAuthorized(t, r, "POST", "/api-keys", tenantAdmin, gin.H{"tenant_id": "shop-2", "scopes": []string{auth.ScopeOrdersRead}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, http.StatusNotFound, doAuthorized(t, r, "DELETE", "/api-keys/abc", tenantAdmin, nil).Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
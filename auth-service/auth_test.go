@@ -3,15 +3,19 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func setupTestRouter(mockDB *sql.DB) *gin.Engine {
@@ -19,20 +23,7 @@ func setupTestRouter(mockDB *sql.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.Default()
 
-	r.POST("/register", func(c *gin.Context) {
-		var creds Credentials
-		if err := c.BindJSON(&creds); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
-			return
-		}
-		err := createUser(creds.Username, creds.Password, "admin")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusCreated, gin.H{"status": "Пользователь зарегистрирован"})
-	})
-
+	r.POST("/register", registerHandler)
 	r.POST("/login", loginHandler)
 
 	return r
@@ -41,13 +32,15 @@ func setupTestRouter(mockDB *sql.DB) *gin.Engine {
 func TestRegisterUser(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (username, password, role) VALUES ($1, $2, $3)")).
-		WithArgs("testuser", "testpass", "admin").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (username, password, role, email, email_verified, tenant_id) VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, '')) RETURNING id")).
+		WithArgs("testuser", bcryptHashArg{password: "testpass1"}, "customer", "test@example.com", false, auth.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectUserTokenInsert(mock, 1, purposeVerifyEmail)
+	sent := stubPublishEmail(t)
 
 	r := setupTestRouter(mockDB)
-	body := Credentials{Username: "testuser", Password: "testpass"}
-	jsonBody, _ := json.Marshal(body)
+	// организация из тела игнорируется — клиент всегда попадает в auth.DefaultTenant
+	jsonBody := []byte(`{"username":"testuser","password":"testpass1","email":"Test@Example.com","tenant_id":"shop-1"}`)
 	req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
@@ -57,16 +50,20 @@ func TestRegisterUser(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.Contains(t, w.Body.String(), "Пользователь зарегистрирован")
 	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, *sent, 1) {
+		assert.Equal(t, "test@example.com", (*sent)[0].Recipient)
+		assert.True(t, (*sent)[0].Sensitive)
+	}
 }
 
 func TestLoginUser_Success(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, password, role FROM users WHERE username = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
 	WithArgs("testuser").
-	WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "role"}).
-			AddRow(1, "testuser", "testpass", "admin"))
+	WillReturnRows(userRow(1, "testuser", mustHash(t, "testpass"), "admin", 0, nil))
+	expectRefreshTokenInsert(mock, 1)
 
 	r := setupTestRouter(mockDB)
 	body := Credentials{Username: "testuser", Password: "testpass"}
@@ -79,6 +76,7 @@ func TestLoginUser_Success(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "token")
+	assert.Contains(t, w.Body.String(), "refresh_token")
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -86,10 +84,12 @@ func TestLoginUser_WrongPassword(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, password, role FROM users WHERE username = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
 	WithArgs("testuser").
-	WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "role"}).
-			AddRow(1, "testuser", "correctpass", "admin"))
+	WillReturnRows(userRow(1, "testuser", mustHash(t, "correctpass"), "admin", 0, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users SET failed_attempts = failed_attempts + 1 WHERE id = $1 RETURNING failed_attempts")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
 
 	r := setupTestRouter(mockDB)
 	body := Credentials{Username: "testuser", Password: "wrongpass"}
@@ -109,7 +109,7 @@ func TestLoginUser_NotFound(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, password, role FROM users WHERE username = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
 	WithArgs("nonexistent").
 	WillReturnError(sql.ErrNoRows)
 
@@ -126,3 +126,107 @@ func TestLoginUser_NotFound(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "Неверный логин или пароль")
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestRegisterUser_WeakPassword(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	r := setupTestRouter(mockDB)
+	body := Credentials{Username: "test@example.com", Password: "short"}
+	jsonBody, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "не менее")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginUser_UpgradesPlaintextPassword(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("legacy").
+		WillReturnRows(userRow(7, "legacy", "plainpass", "admin", 2, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password = $1, failed_attempts = 0, locked_until = NULL WHERE id = $2")).
+		WithArgs(bcryptHashArg{password: "plainpass"}, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRefreshTokenInsert(mock, 7)
+
+	r := setupTestRouter(mockDB)
+	jsonBody, _ := json.Marshal(Credentials{Username: "legacy", Password: "plainpass"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginUser_LocksAfterMaxFailures(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	maxFailedLogins = 5
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("testuser").
+		WillReturnRows(userRow(1, "testuser", mustHash(t, "correctpass"), "admin", 0, nil))
+	// счётчик в БД уже дошёл до 4 параллельными попытками, хотя прочитан был 0
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users SET failed_attempts = failed_attempts + 1 WHERE id = $1 RETURNING failed_attempts")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(5))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_attempts = 0, locked_until = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := setupTestRouter(mockDB)
+	jsonBody, _ := json.Marshal(Credentials{Username: "testuser", Password: "wrongpass"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginUser_Locked(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("testuser").
+		WillReturnRows(userRow(1, "testuser", mustHash(t, "testpass"), "admin", 0, time.Now().Add(10*time.Minute)))
+
+	r := setupTestRouter(mockDB)
+	jsonBody, _ := json.Marshal(Credentials{Username: "testuser", Password: "testpass"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// bcryptHashArg проверяет, что в БД уходит bcrypt-хэш нужного пароля, а не открытый текст.
+type bcryptHashArg struct{ password string }
+
+func (a bcryptHashArg) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(a.password)) == nil
+}
+
+func mustHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NoError(t, err)
+	return string(hash)
+}
@@ -4,3 +4,115 @@ CREATE TABLE IF NOT EXISTS users (
   password VARCHAR(255) NOT NULL,
   role VARCHAR(50) NOT NULL
 );
+
+-- Пароли хранятся как bcrypt-хэши; учёт неудачных входов для временной блокировки
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+-- Роли: admin, dispatcher, courier, customer, analyst. Для курьера — ссылка на запись курьера в order-service
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS courier_id VARCHAR(50);
+
+-- Refresh-токены (хранится только SHA-256). Токены, полученные ротацией от одного входа, образуют семейство
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash CHAR(64) UNIQUE NOT NULL,
+  family_id VARCHAR(64) NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens (user_id);
+
+-- Отозванные access-токены (по jti) до истечения их срока действия
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti VARCHAR(64) PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- Профиль и администрирование пользователей
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS full_name VARCHAR(255),
+  ADD COLUMN IF NOT EXISTS email VARCHAR(255),
+  ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW();
+-- Одна запись курьера — одна учётная запись
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_courier_id ON users (courier_id) WHERE courier_id IS NOT NULL;
+
+-- Подтверждение email. Существующие пользователи считаются подтверждёнными, новые — нет
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users
+  ALTER COLUMN email_verified SET DEFAULT FALSE;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email) WHERE email IS NOT NULL;
+
+-- Одноразовые токены из писем (подтверждение email, сброс пароля); хранится только SHA-256
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  purpose VARCHAR(32) NOT NULL,
+  token_hash CHAR(64) UNIQUE NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens (user_id, purpose);
+
+-- Учётные данные внутренних сервисов (client credentials); секрет хранится как bcrypt-хэш
+CREATE TABLE IF NOT EXISTS service_clients (
+  client_id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  secret_hash VARCHAR(255) NOT NULL,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Организации (арендаторы). Пользователи без организации — администраторы платформы,
+-- остальные существующие пользователи относятся к организации default
+CREATE TABLE IF NOT EXISTS tenants (
+  id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+INSERT INTO tenants (id, name) VALUES ('default', 'Организация по умолчанию') ON CONFLICT (id) DO NOTHING;
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) REFERENCES tenants(id);
+UPDATE users SET tenant_id = 'default' WHERE tenant_id IS NULL AND role <> 'admin';
+CREATE INDEX IF NOT EXISTS idx_users_tenant ON users (tenant_id);
+
+-- API-ключи B2B-клиентов; хранится только SHA-256 ключа
+CREATE TABLE IF NOT EXISTS api_keys (
+  id VARCHAR(32) PRIMARY KEY,
+  key_hash CHAR(64) UNIQUE NOT NULL,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+  name VARCHAR(255) NOT NULL DEFAULT '',
+  scopes TEXT[] NOT NULL,
+  rate_limit INTEGER NOT NULL DEFAULT 60,
+  created_by VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_tenant ON api_keys (tenant_id);
+
+-- Учёт разовых миграций данных, которые нельзя безопасно повторять
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  id VARCHAR(64) PRIMARY KEY,
+  applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- До появления организаций /register создавал администраторов, и миграция выше оставила их без организации,
+-- то есть администраторами платформы. Переносим всех пользователей без организации в default один раз;
+-- администраторы платформы задаются явно: ADMIN_USERNAME при первом запуске (ensureBootstrapAdmin)
+-- или UPDATE users SET tenant_id = NULL WHERE username = '...'
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM schema_migrations WHERE id = 'legacy_admins_to_default_tenant') THEN
+    UPDATE users SET tenant_id = 'default' WHERE tenant_id IS NULL;
+    INSERT INTO schema_migrations (id) VALUES ('legacy_admins_to_default_tenant');
+  END IF;
+END $$;
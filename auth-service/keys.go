@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// signingKey — закрытый ключ auth-service. Открытая часть публикуется в JWKS под тем же kid.
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+var (
+	signingKeys = map[string]*signingKey{}
+	activeKey   *signingKey // ключ, которым подписываются новые токены
+)
+
+// loadSigningKeys загружает ключи подписи:
+//   - из каталога JWT_KEYS_DIR: каждый файл <kid>.pem — закрытый ключ RSA (PKCS#1/PKCS#8) или Ed25519 (PKCS#8);
+//   - из переменной JWT_PRIVATE_KEY (PEM) с идентификатором JWT_KEY_ID.
+//
+// Подписывает ключ JWT_ACTIVE_KID, а если он не задан — последний по алфавиту kid.
+// Остальные ключи только публикуются в JWKS: так новый ключ можно выложить заранее,
+// а старый убрать, когда истекут подписанные им токены.
+// Без настроенных ключей генерируется временный Ed25519-ключ (только для разработки).
+func loadSigningKeys() error {
+	keys := map[string]*signingKey{}
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+			key, err := parseSigningKey(kid, data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f, err)
+			}
+			keys[kid] = key
+		}
+	}
+	if pemData := os.Getenv("JWT_PRIVATE_KEY"); pemData != "" {
+		kid := getEnv("JWT_KEY_ID", "default")
+		key, err := parseSigningKey(kid, []byte(pemData))
+		if err != nil {
+			return fmt.Errorf("JWT_PRIVATE_KEY: %w", err)
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		key, err := generateDevKey()
+		if err != nil {
+			return err
+		}
+		log.Printf("ВНИМАНИЕ: ключи подписи не заданы, используется временный ключ %s; токены перестанут действовать после перезапуска", key.kid)
+		keys[key.kid] = key
+	}
+
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" {
+		for kid := range keys {
+			if kid > active {
+				active = kid
+			}
+		}
+	}
+	if keys[active] == nil {
+		return fmt.Errorf("ключ подписи %q не найден", active)
+	}
+	signingKeys = keys
+	activeKey = keys[active]
+	log.Printf("Загружено ключей подписи: %d, активный: %s (%s)", len(keys), activeKey.kid, activeKey.method.Alg())
+	return nil
+}
+
+// parseSigningKey разбирает PEM с закрытым ключом RSA или Ed25519.
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("не найден PEM-блок")
+	}
+	var parsed any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA-ключ должен быть не короче 2048 бит")
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %T, нужен RSA или Ed25519", parsed)
+}
+
+func generateDevKey() (*signingKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := randomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: "dev-" + suffix, method: jwt.SigningMethodEdDSA, private: priv, public: pub}, nil
+}
+
+// signToken подписывает claims активным ключом и указывает его kid в заголовке.
+func signToken(claims jwt.Claims) (string, error) {
+	if activeKey == nil {
+		return "", errors.New("ключ подписи не загружен")
+	}
+	token := jwt.NewWithClaims(activeKey.method, claims)
+	token.Header["kid"] = activeKey.kid
+	return token.SignedString(activeKey.private)
+}
+
+// localKey — источник ключей проверки для самого auth-service (без похода в JWKS).
+func localKey(kid string) (crypto.PublicKey, error) {
+	if key, ok := signingKeys[kid]; ok {
+		return key.public, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+}
+
+// toJWK формирует публичное представление ключа для JWKS.
+func (k *signingKey) toJWK() auth.JWK {
+	jwk := auth.JWK{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// jwksHandler публикует открытые ключи всех загруженных ключей подписи.
+func jwksHandler(c *gin.Context) {
+	kids := make([]string, 0, len(signingKeys))
+	for kid := range signingKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	keys := make([]auth.JWK, 0, len(kids))
+	for _, kid := range kids {
+		keys = append(keys, signingKeys[kid].toJWK())
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
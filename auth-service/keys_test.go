@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestMain(m *testing.M) {
+	// без JWT_KEYS_DIR/JWT_PRIVATE_KEY генерируется временный ключ
+	if err := loadSigningKeys(); err != nil {
+		panic(err)
+	}
+	auth.LookupKey = localKey
+	os.Exit(m.Run())
+}
+
+func writeKey(t *testing.T, dir, kid string, key any) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600))
+}
+
+func TestLoadSigningKeysAndRotation(t *testing.T) {
+	t.Cleanup(func() { _ = loadSigningKeys() }) // выполнится после восстановления переменных окружения
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	writeKey(t, dir, "2026-01", rsaKey)
+	writeKey(t, dir, "2026-02", edKey)
+	t.Setenv("JWT_KEYS_DIR", dir)
+
+	// без JWT_ACTIVE_KID подписывает последний по алфавиту ключ
+	assert.NoError(t, loadSigningKeys())
+	assert.Equal(t, "2026-02", activeKey.kid)
+	assert.Equal(t, "EdDSA", activeKey.method.Alg())
+
+	t.Setenv("JWT_ACTIVE_KID", "2026-01")
+	assert.NoError(t, loadSigningKeys())
+	oldToken, err := issueAccessToken(User{Username: "disp", Role: auth.RoleDispatcher})
+	assert.NoError(t, err)
+
+	// ротация: новый ключ стал активным, токен старого ключа всё ещё проверяется
+	t.Setenv("JWT_ACTIVE_KID", "2026-02")
+	assert.NoError(t, loadSigningKeys())
+	claims, err := auth.ParseToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleDispatcher, claims.Role)
+
+	t.Setenv("JWT_ACTIVE_KID", "missing")
+	assert.Error(t, loadSigningKeys())
+}
+
+func TestJWKSHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/.well-known/jwks.json", jwksHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body.Keys, 1)
+	assert.Equal(t, activeKey.kid, body.Keys[0].Kid)
+	assert.NotContains(t, w.Body.String(), `"d"`) // закрытая часть не публикуется
+	pub, err := body.Keys[0].PublicKey()
+	assert.NoError(t, err)
+	assert.Equal(t, activeKey.public, pub)
+}
+
+func TestJWKRoundTripRSA(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwk := (&signingKey{kid: "r", method: jwt.SigningMethodRS256, public: &rsaKey.PublicKey}).toJWK()
+	pub, err := jwk.PublicKey()
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.PublicKey.Equal(pub))
+}
+
+func TestParseTokenRejectsForeignKeysAndAlgorithms(t *testing.T) {
+	claims := &auth.Claims{Role: auth.RoleAdmin, StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+
+	// HS256 с публичным ключом в качестве секрета — классическая подмена алгоритма
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = activeKey.kid
+	forged, err := hs.SignedString([]byte("your_secret_key"))
+	assert.NoError(t, err)
+	_, err = auth.ParseToken(forged)
+	assert.Equal(t, auth.ErrInvalidToken, err)
+
+	// чужой ключ с неизвестным kid
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	other := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	other.Header["kid"] = "unknown"
+	foreign, err := other.SignedString(otherKey)
+	assert.NoError(t, err)
+	_, err = auth.ParseToken(foreign)
+	assert.Equal(t, auth.ErrInvalidToken, err)
+}
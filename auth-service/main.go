@@ -6,29 +6,40 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	_ "github.com/lib/pq"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// jwtKey – секретный ключ для подписывания токенов (в продакшене лучше получать его из переменных окружения)
-var jwtKey = []byte("your_secret_key")
-
 // User представляет пользователя, как он хранится в базе данных.
 type User struct {
-	ID       int
-	Username string
-	Password string // В production системе следует хранить хэш пароля!
-	Role     string
+	ID             int
+	Username       string
+	Password       string // bcrypt-хэш; у старых записей — открытый текст до первого входа
+	Role           string
+	FailedAttempts int
+	LockedUntil    *time.Time
+	CourierID      string // для роли courier — ID записи курьера в order-service
+	FullName       string
+	Email          string
+	Disabled       bool // отключённый пользователь не может войти и обновить токен
+	EmailVerified  bool
+	// MustChangePassword выставляется при сбросе пароля администратором
+	MustChangePassword bool
+	CreatedAt          time.Time
+	TenantID           string // организация; пусто только у администраторов платформы
 }
 
 // Credentials – структура для входа/регистрации.
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"` // при регистрации; если не задан, логин должен быть email
 }
 
 // Глобальное подключение к базе данных.
@@ -62,6 +73,24 @@ func main() {
 		log.Fatalf("Ошибка подключения к БД: %v", err)
 	}
 
+	initPasswordConfig()
+	initTokenConfig()
+	initVerificationConfig()
+	if err := loadSigningKeys(); err != nil {
+		log.Fatalf("Ошибка загрузки ключей подписи: %v", err)
+	}
+	auth.LookupKey = localKey
+	auth.TokenRevoked = isTokenRevokedDB
+	auth.LookupAPIKey = localAPIKey
+	defaultAPIKeyRateLimit = envInt("API_KEY_RATE_LIMIT", defaultAPIKeyRateLimit)
+	go purgeExpiredTokens()
+	if err := ensureBootstrapAdmin(); err != nil {
+		log.Fatalf("Ошибка создания администратора: %v", err)
+	}
+	if err := initServiceClients(); err != nil {
+		log.Fatalf("Ошибка регистрации сервисных клиентов: %v", err)
+	}
+
 	r := gin.Default()
 	corsConfig := cors.Config{
         AllowOrigins:     []string{"http://localhost:3000"},
@@ -78,36 +107,120 @@ func main() {
 	})
 
 	// Endpoint для регистрации нового пользователя.
-	r.POST("/register", func(c *gin.Context) {
-		var creds Credentials
-		if err := c.BindJSON(&creds); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
-			return
-		}
-		// По умолчанию регистрируем пользователя с ролью "admin". Логику можно расширить по необходимости.
-		err := createUser(creds.Username, creds.Password, "admin")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusCreated, gin.H{"status": "Пользователь зарегистрирован"})
-	})
+	r.POST("/register", registerHandler)
 
 	// Endpoint для логина – проверяет данные из БД, сравнивает пароль и генерирует JWT.
 	r.POST("/login", loginHandler)
 
+	// Подтверждение email и восстановление пароля по ссылкам из писем
+	r.GET("/verify-email", verifyEmailHandler)
+	r.POST("/verify-email", verifyEmailHandler)
+	r.POST("/verify-email/resend", resendVerificationHandler)
+	r.POST("/password/forgot", forgotPasswordHandler)
+	r.POST("/password/reset", resetPasswordByTokenHandler)
+
+	// Обновление пары токенов, выход и список отозванных токенов для других сервисов.
+	r.POST("/token/refresh", refreshHandler)
+	r.POST("/logout", auth.Required(), logoutHandler)
+	r.GET("/token/revoked", revokedTokensHandler)
+	r.GET("/.well-known/jwks.json", jwksHandler)
+
+	// Токены для внутренних вызовов между сервисами (OAuth2 client credentials)
+	r.POST("/oauth/token", serviceTokenHandler)
+	services := r.Group("/service-clients", auth.Required(auth.RoleAdmin), auth.CrossTenantRequired())
+	{
+		services.GET("", listServiceClientsHandler)
+		services.POST("", createServiceClientHandler)
+		services.DELETE("/:id", disableServiceClientHandler)
+	}
+
+	// Организации (арендаторы) — только администратор платформы
+	tenants := r.Group("/tenants", auth.Required(auth.RoleAdmin), auth.CrossTenantRequired())
+	{
+		tenants.GET("", listTenantsHandler)
+		tenants.POST("", createTenantHandler)
+		tenants.PUT("/:id", updateTenantHandler)
+	}
+
+	// API-ключи B2B-клиентов: выпуск и отзыв — администратор (своей организации), проверка — другие сервисы
+	r.POST("/api-keys/introspect", auth.Required(auth.RoleService), introspectAPIKeyHandler)
+	apiKeysGroup := r.Group("/api-keys", auth.Required(auth.RoleAdmin))
+	{
+		apiKeysGroup.GET("", listAPIKeysHandler)
+		apiKeysGroup.POST("", createAPIKeyHandler)
+		apiKeysGroup.DELETE("/:id", revokeAPIKeyHandler)
+	}
+
 	// Пример защищённого маршрута, для которого действует middleware проверки токена.
 	authorized := r.Group("/")
-	authorized.Use(authMiddleware())
+	authorized.Use(auth.Required())
 	{
 		authorized.GET("/protected", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "Доступ защищённого ресурса"})
 		})
+
+		// Профиль текущего пользователя и смена пароля
+		authorized.GET("/me", getMeHandler)
+		authorized.PUT("/me", updateMeHandler)
+	}
+
+	// Управление пользователями: администратор организации видит только её пользователей
+	admin := r.Group("/users", auth.Required(auth.RoleAdmin))
+	{
+		admin.GET("", listUsersHandler)
+		admin.POST("", createUserHandler)
+		admin.GET("/:id", getUserHandler)
+		admin.PUT("/:id", updateUserHandler)
+		admin.DELETE("/:id", deleteUserHandler)
+		admin.PUT("/:id/role", setRoleHandler)
+		admin.POST("/:id/disable", setDisabledHandler(true))
+		admin.POST("/:id/enable", setDisabledHandler(false))
+		admin.POST("/:id/reset-password", resetPasswordHandler)
 	}
 
 	r.Run(":8080")
 }
 
+// registerHandler регистрирует пользователя, проверяя пароль по политике.
+func registerHandler(c *gin.Context) {
+	var creds Credentials
+	if err := c.BindJSON(&creds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	creds.Username = strings.TrimSpace(creds.Username)
+	if creds.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан логин"})
+		return
+	}
+	if creds.Email == "" {
+		creds.Email = creds.Username
+	}
+	email, err := normalizeEmail(creds.Email)
+	if err != nil || email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите корректный email: на него придёт ссылка для подтверждения"})
+		return
+	}
+	if err := passwordPolicy.Validate(creds.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Самостоятельно регистрируются только клиенты и только в auth.DefaultTenant: организацию из запроса
+	// не принимаем, иначе любой мог бы войти в чужую организацию. В организацию пользователя
+	// добавляет её администратор (POST /users).
+	tenant := auth.DefaultTenant
+	id, err := createUser(creds.Username, creds.Password, auth.RoleCustomer, email, tenant, false)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := sendVerificationEmail(User{ID: id, Username: creds.Username, Email: email, TenantID: tenant}); err != nil {
+		// пользователь может запросить письмо повторно через /verify-email/resend
+		log.Printf("Ошибка отправки письма подтверждения для %s: %v", creds.Username, err)
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "Пользователь зарегистрирован, подтвердите email по ссылке из письма"})
+}
+
 // loginHandler обрабатывает запрос на логин.
 func loginHandler(c *gin.Context) {
 	var creds Credentials
@@ -117,61 +230,140 @@ func loginHandler(c *gin.Context) {
 	}
 	user, err := getUserByUsername(creds.Username)
 	if err != nil {
+		// сравниваем с фиктивным хэшем, чтобы по времени ответа нельзя было узнать, есть ли такой логин
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(creds.Password))
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный логин или пароль"})
 		return
 	}
-	// Для примера сравнение происходит в открытом виде. В production используется хэширование паролей.
-	if user.Password != creds.Password {
+	if user.Disabled {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(creds.Password))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Учётная запись отключена"})
+		return
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		c.JSON(http.StatusLocked, gin.H{
+			"error":        "Учётная запись временно заблокирована из-за неудачных попыток входа",
+			"locked_until": user.LockedUntil.Format(time.RFC3339),
+		})
+		return
+	}
+	ok, needsUpgrade := checkPassword(user.Password, creds.Password)
+	if !ok {
+		if err := recordFailedLogin(user); err != nil {
+			log.Printf("Ошибка учёта неудачного входа для %s: %v", user.Username, err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный логин или пароль"})
 		return
 	}
-	// Генерация JWT-токена с временем жизни 1 час.
-	expirationTime := time.Now().Add(1 * time.Hour)
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expirationTime.Unix(),
-		Subject:   user.Username,
+	if err := recordSuccessfulLogin(user, creds.Password, needsUpgrade); err != nil {
+		log.Printf("Ошибка обновления данных входа для %s: %v", user.Username, err)
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email не подтверждён, перейдите по ссылке из письма"})
+		return
+	}
+	// Короткоживущий access-токен и refresh-токен для его продления.
+	tokens, err := issueTokens(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании токена"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	// клиент должен предложить сменить пароль через PUT /me
+	tokens["must_change_password"] = user.MustChangePassword
+	c.JSON(http.StatusOK, tokens)
 }
 
-// authMiddleware проверяет наличие и корректность JWT-токена в заголовке Authorization.
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Отсутствует токен"})
-			return
-		}
-		claims := &jwt.StandardClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Некорректный токен"})
-			return
-		}
-		c.Set("username", claims.Subject)
-		c.Next()
-	}
+// userColumns — поля users в порядке, который ожидает scanUser.
+const userColumns = `id, username, password, role, failed_attempts, locked_until, COALESCE(courier_id, ''),
+	COALESCE(full_name, ''), COALESCE(email, ''), disabled, must_change_password, created_at, email_verified, COALESCE(tenant_id, '')`
+
+// rowScanner покрывает *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-// getUserByUsername выполняет запрос к таблице users для поиска пользователя по username.
-func getUserByUsername(username string) (User, error) {
+func scanUser(row rowScanner) (User, error) {
 	var user User
-	query := "SELECT id, username, password, role FROM users WHERE username = $1"
-	err := db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password, &user.Role)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.FailedAttempts, &user.LockedUntil, &user.CourierID,
+		&user.FullName, &user.Email, &user.Disabled, &user.MustChangePassword, &user.CreatedAt, &user.EmailVerified, &user.TenantID)
 	return user, err
 }
 
-// createUser вставляет нового пользователя в таблицу users.
-func createUser(username, password, role string) error {
-	query := "INSERT INTO users (username, password, role) VALUES ($1, $2, $3)"
-	_, err := db.Exec(query, username, password, role)
+// getUserByUsername выполняет запрос к таблице users для поиска пользователя по username.
+func getUserByUsername(username string) (User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+// getUserByID ищет пользователя по id (используется при обновлении токена).
+func getUserByID(id int) (User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+// createUser хэширует пароль и вставляет нового пользователя в таблицу users.
+// Пустой tenantID — пользователь платформы (только для администраторов).
+func createUser(username, password, role, email, tenantID string, emailVerified bool) (int, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	query := "INSERT INTO users (username, password, role, email, email_verified, tenant_id) VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, '')) RETURNING id"
+	err = db.QueryRow(query, username, hash, role, email, emailVerified, tenantID).Scan(&id)
+	return id, err
+}
+
+// recordFailedLogin увеличивает счётчик неудачных попыток и при достижении
+// maxFailedLogins блокирует учётную запись на lockoutDuration.
+func recordFailedLogin(user User) error {
+	// Счётчик увеличивается в БД: параллельные неудачные попытки не перезаписывают друг друга.
+	var attempts int
+	err := db.QueryRow("UPDATE users SET failed_attempts = failed_attempts + 1 WHERE id = $1 RETURNING failed_attempts",
+		user.ID).Scan(&attempts)
+	if err != nil {
+		return err
+	}
+	if maxFailedLogins > 0 && attempts >= maxFailedLogins {
+		_, err = db.Exec("UPDATE users SET failed_attempts = 0, locked_until = $1 WHERE id = $2",
+			time.Now().Add(lockoutDuration), user.ID)
+	}
+	return err
+}
+
+// recordSuccessfulLogin сбрасывает счётчик неудачных попыток и, если пароль хранился
+// в открытом виде, заменяет его на хэш.
+func recordSuccessfulLogin(user User, password string, needsUpgrade bool) error {
+	if needsUpgrade {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("UPDATE users SET password = $1, failed_attempts = 0, locked_until = NULL WHERE id = $2", hash, user.ID)
+		return err
+	}
+	if user.FailedAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	_, err := db.Exec("UPDATE users SET failed_attempts = 0, locked_until = NULL WHERE id = $1", user.ID)
+	return err
+}
+
+// ensureBootstrapAdmin создаёт первого администратора из ADMIN_USERNAME/ADMIN_PASSWORD,
+// если такого пользователя ещё нет. Через /register администратора получить нельзя.
+func ensureBootstrapAdmin() error {
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		return nil
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	log.Printf("Создаётся администратор %s", username)
+	// администратор платформы: без организации, видит данные всех организаций
+	_, err := createUser(username, password, auth.RoleAdmin, os.Getenv("ADMIN_EMAIL"), "", true)
 	return err
 }
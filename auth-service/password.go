@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordPolicy задаёт требования к паролю при регистрации и смене пароля.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxBytes       int // bcrypt учитывает не более 72 байт пароля
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+var (
+	passwordPolicy = PasswordPolicy{MinLength: 8, MaxBytes: 72, RequireLower: true, RequireDigit: true}
+
+	maxFailedLogins = 5                // после стольких неудачных попыток учётная запись блокируется
+	lockoutDuration = 15 * time.Minute // на сколько блокируется учётная запись
+	bcryptCost      = bcrypt.DefaultCost
+)
+
+// dummyHash используется, чтобы время ответа для несуществующего пользователя
+// не отличалось от времени проверки настоящего пароля.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// initPasswordConfig читает политику паролей и параметры блокировки из переменных окружения.
+func initPasswordConfig() {
+	passwordPolicy.MinLength = envInt("PASSWORD_MIN_LENGTH", passwordPolicy.MinLength)
+	passwordPolicy.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER", passwordPolicy.RequireUpper)
+	passwordPolicy.RequireLower = envBool("PASSWORD_REQUIRE_LOWER", passwordPolicy.RequireLower)
+	passwordPolicy.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", passwordPolicy.RequireDigit)
+	passwordPolicy.RequireSpecial = envBool("PASSWORD_REQUIRE_SPECIAL", passwordPolicy.RequireSpecial)
+	maxFailedLogins = envInt("MAX_FAILED_LOGINS", maxFailedLogins)
+	if d, err := time.ParseDuration(getEnv("LOCKOUT_DURATION", "")); err == nil {
+		lockoutDuration = d
+	}
+	bcryptCost = envInt("BCRYPT_COST", bcryptCost)
+}
+
+// Validate проверяет пароль и возвращает понятную пользователю ошибку.
+func (p PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("пароль должен содержать не менее %d символов", p.MinLength)
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return fmt.Errorf("пароль должен быть не длиннее %d байт", p.MaxBytes)
+	}
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSpecial = true
+		}
+	}
+	var missing []string
+	if p.RequireUpper && !hasUpper {
+		missing = append(missing, "заглавную букву")
+	}
+	if p.RequireLower && !hasLower {
+		missing = append(missing, "строчную букву")
+	}
+	if p.RequireDigit && !hasDigit {
+		missing = append(missing, "цифру")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		missing = append(missing, "спецсимвол")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("пароль должен содержать %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// hashPassword возвращает bcrypt-хэш пароля.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return string(hash), err
+}
+
+// isPasswordHash отличает bcrypt-хэш от пароля, сохранённого старой версией сервиса в открытом виде.
+func isPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword сравнивает пароль с сохранённым значением.
+// needsUpgrade == true, если значение хранилось в открытом виде и его нужно перехэшировать.
+func checkPassword(stored, password string) (ok bool, needsUpgrade bool) {
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return ok, ok
+}
+
+// getEnv возвращает значение переменной окружения или defaultVal, если переменная не задана.
+func getEnv(key, defaultVal string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
+	}
+	return val
+}
+
+// envInt читает целочисленную переменную окружения.
+func envInt(key string, defaultVal int) int {
+	v, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
+
+// envBool читает логическую переменную окружения.
+func envBool(key string, defaultVal bool) bool {
+	v, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSpecial: true}
+
+	assert.NoError(t, policy.Validate("Str0ng!pass"))
+	assert.Error(t, policy.Validate("S0!a"))
+	assert.ErrorContains(t, policy.Validate("str0ng!pass"), "заглавную букву")
+	assert.ErrorContains(t, policy.Validate("Strong!pass"), "цифру")
+	assert.ErrorContains(t, policy.Validate("Str0ngpass"), "спецсимвол")
+
+	policy.MaxBytes = 72
+	assert.NoError(t, policy.Validate("Str0ng!"+strings.Repeat("a", 65)))
+	assert.ErrorContains(t, policy.Validate("Str0ng!"+strings.Repeat("я", 33)), "не длиннее 72 байт",
+		"лимит bcrypt — в байтах, а не в символах")
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("secret123")
+	assert.NoError(t, err)
+	assert.True(t, isPasswordHash(hash))
+
+	ok, upgrade := checkPassword(hash, "secret123")
+	assert.True(t, ok)
+	assert.False(t, upgrade)
+
+	ok, _ = checkPassword(hash, "wrong")
+	assert.False(t, ok)
+
+	// запись старого формата в открытом виде
+	ok, upgrade = checkPassword("legacy-plain", "legacy-plain")
+	assert.True(t, ok)
+	assert.True(t, upgrade)
+
+	ok, upgrade = checkPassword("legacy-plain", "other")
+	assert.False(t, ok)
+	assert.False(t, upgrade)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ServiceClient — учётные данные внутреннего сервиса для получения токена с ролью service.
+type ServiceClient struct {
+	ClientID  string    `json:"client_id"`
+	Name      string    `json:"name"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// serviceTokenTTL — время жизни сервисного токена; сервисы обновляют его автоматически.
+var serviceTokenTTL = 10 * time.Minute
+
+var clientIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,63}$`)
+
+// initServiceClients читает SERVICE_TOKEN_TTL и регистрирует клиентов из SERVICE_CLIENTS
+// в формате "order-service=secret1,delivery-service=secret2" (секрет обновляется при каждом запуске).
+func initServiceClients() error {
+	if d, err := time.ParseDuration(getEnv("SERVICE_TOKEN_TTL", "")); err == nil && d > 0 {
+		serviceTokenTTL = d
+	}
+	for _, pair := range strings.Split(os.Getenv("SERVICE_CLIENTS"), ",") {
+		clientID, secret, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || clientID == "" || secret == "" {
+			continue
+		}
+		hash, err := hashPassword(secret)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`INSERT INTO service_clients (client_id, name, secret_hash) VALUES ($1, $1, $2)
+			ON CONFLICT (client_id) DO UPDATE SET secret_hash = EXCLUDED.secret_hash, disabled = FALSE`, clientID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oauthError — ошибки /oauth/token в формате RFC 6749.
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// serviceTokenHandler реализует grant_type=client_credentials: учётные данные передаются
+// через HTTP Basic или полями client_id/client_secret формы.
+func serviceTokenHandler(c *gin.Context) {
+	if grant := c.PostForm("grant_type"); grant != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Поддерживается только client_credentials")
+		return
+	}
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	var hash string
+	var disabled bool
+	err := db.QueryRow("SELECT secret_hash, disabled FROM service_clients WHERE client_id = $1", clientID).Scan(&hash, &disabled)
+	if err != nil && err != sql.ErrNoRows {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if err == sql.ErrNoRows {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(secret))
+	}
+	if err != nil || disabled || bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Неверные учётные данные сервиса")
+		return
+	}
+	token, err := issueServiceToken(clientID)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "Ошибка при создании токена")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(serviceTokenTTL.Seconds()),
+	})
+}
+
+// issueServiceToken выпускает токен с ролью service; subject — "service:<client_id>".
+func issueServiceToken(clientID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return signToken(&auth.Claims{
+		Role: auth.RoleService,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(serviceTokenTTL).Unix(),
+			Subject:   "service:" + clientID,
+		},
+	})
+}
+
+// GET /service-clients
+func listServiceClientsHandler(c *gin.Context) {
+	rows, err := db.Query("SELECT client_id, name, disabled, created_at FROM service_clients ORDER BY client_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	clients := []ServiceClient{}
+	for rows.Next() {
+		var sc ServiceClient
+		if err := rows.Scan(&sc.ClientID, &sc.Name, &sc.Disabled, &sc.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		clients = append(clients, sc)
+	}
+	c.JSON(http.StatusOK, clients)
+}
+
+// POST /service-clients — регистрирует сервис (или выпускает новый секрет существующему).
+// Секрет возвращается один раз, в БД хранится только его bcrypt-хэш.
+func createServiceClientHandler(c *gin.Context) {
+	var req struct {
+		ClientID string `json:"client_id"`
+		Name     string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if !clientIDRe.MatchString(req.ClientID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id: латиница в нижнем регистре, цифры, '-' и '_'"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.ClientID
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := hashPassword(secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = db.Exec(`INSERT INTO service_clients (client_id, name, secret_hash) VALUES ($1, $2, $3)
+		ON CONFLICT (client_id) DO UPDATE SET name = EXCLUDED.name, secret_hash = EXCLUDED.secret_hash, disabled = FALSE`,
+		req.ClientID, req.Name, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Администратор %s выпустил секрет для сервиса %s", auth.CurrentClaims(c).Subject, req.ClientID)
+	c.JSON(http.StatusCreated, gin.H{"client_id": req.ClientID, "name": req.Name, "client_secret": secret})
+}
+
+// DELETE /service-clients/:id — отключает сервис; уже выданные токены доживают serviceTokenTTL.
+func disableServiceClientHandler(c *gin.Context) {
+	res, err := db.Exec("UPDATE service_clients SET disabled = TRUE WHERE client_id = $1", c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сервис не найден"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Сервис отключён"})
+}
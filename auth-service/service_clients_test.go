@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func requestServiceToken(form url.Values, clientID, secret string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/oauth/token", serviceTokenHandler)
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestServiceToken_ClientCredentials(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret_hash, disabled FROM service_clients WHERE client_id = $1")).
+		WithArgs("order-service").
+		WillReturnRows(sqlmock.NewRows([]string{"secret_hash", "disabled"}).AddRow(mustHash(t, "s3cret"), false))
+
+	w := requestServiceToken(url.Values{"grant_type": {"client_credentials"}}, "order-service", "s3cret")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	claims, err := auth.ParseToken(resp.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleService, claims.Role)
+	assert.Equal(t, "service:order-service", claims.Subject)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServiceToken_WrongSecret(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret_hash, disabled FROM service_clients WHERE client_id = $1")).
+		WithArgs("order-service").
+		WillReturnRows(sqlmock.NewRows([]string{"secret_hash", "disabled"}).AddRow(mustHash(t, "s3cret"), false))
+
+	w := requestServiceToken(url.Values{"grant_type": {"client_credentials"}}, "order-service", "guess")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_client")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServiceToken_DisabledClient(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret_hash, disabled FROM service_clients WHERE client_id = $1")).
+		WithArgs("order-service").
+		WillReturnRows(sqlmock.NewRows([]string{"secret_hash", "disabled"}).AddRow(mustHash(t, "s3cret"), true))
+
+	w := requestServiceToken(url.Values{
+		"grant_type": {"client_credentials"}, "client_id": {"order-service"}, "client_secret": {"s3cret"},
+	}, "", "")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServiceToken_UnsupportedGrant(t *testing.T) {
+	w := requestServiceToken(url.Values{"grant_type": {"password"}}, "order-service", "s3cret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported_grant_type")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant — организация (мерчант), для которой выполняются доставки. Её id попадает в JWT
+// как tenant_id, и все сервисы показывают пользователю только данные его организации.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /tenants
+func listTenantsHandler(c *gin.Context) {
+	rows, err := db.Query("SELECT id, name, created_at FROM tenants ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	tenants := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		tenants = append(tenants, t)
+	}
+	c.JSON(http.StatusOK, tenants)
+}
+
+// POST /tenants — id задаётся латиницей (как client_id сервисов) и потом не меняется.
+func createTenantHandler(c *gin.Context) {
+	var t Tenant
+	if err := c.BindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if !clientIDRe.MatchString(t.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id: латиница в нижнем регистре, цифры, '-' и '_'"})
+		return
+	}
+	if t.Name = strings.TrimSpace(t.Name); t.Name == "" {
+		t.Name = t.ID
+	}
+	err := db.QueryRow("INSERT INTO tenants (id, name) VALUES ($1, $2) RETURNING created_at", t.ID, t.Name).Scan(&t.CreatedAt)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+// PUT /tenants/:id — переименование организации.
+func updateTenantHandler(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указано название"})
+		return
+	}
+	t := Tenant{ID: c.Param("id"), Name: strings.TrimSpace(req.Name)}
+	err := db.QueryRow("UPDATE tenants SET name = $1 WHERE id = $2 RETURNING created_at", t.Name, t.ID).Scan(&t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Организация не найдена"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
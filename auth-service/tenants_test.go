@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func setupTenantsRouter(mockDB *sql.DB) *gin.Engine {
+	r := setupTestRouter(mockDB)
+	tenants := r.Group("/tenants", auth.Required(auth.RoleAdmin), auth.CrossTenantRequired())
+	tenants.GET("", listTenantsHandler)
+	tenants.POST("", createTenantHandler)
+	tenants.PUT("/:id", updateTenantHandler)
+	return r
+}
+
+func TestTenants_OnlyPlatformAdmin(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	w := doAuthorized(t, setupTenantsRouter(mockDB), "GET", "/tenants", User{Username: "shop-admin", Role: auth.RoleAdmin, TenantID: "shop-1"}, nil)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTenant(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO tenants (id, name) VALUES ($1, $2) RETURNING created_at")).
+		WithArgs("shop-1", "Магазин").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	r := setupTenantsRouter(mockDB)
+
+	w := doAuthorized(t, r, "POST", "/tenants", testAdmin, gin.H{"id": "shop-1", "name": "Магазин"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":"shop-1"`)
+
+	w = doAuthorized(t, r, "POST", "/tenants", testAdmin, gin.H{"id": "Shop 1"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTenant_NotFound(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE tenants SET name = $1 WHERE id = $2")).
+		WithArgs("Новое имя", "nope").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+
+	w := doAuthorized(t, setupTenantsRouter(mockDB), "PUT", "/tenants/nope", testAdmin, gin.H{"name": "Новое имя"})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+var (
+	accessTokenTTL  = 15 * time.Minute    // время жизни access-токена
+	refreshTokenTTL = 30 * 24 * time.Hour // время жизни refresh-токена
+)
+
+// initTokenConfig читает время жизни токенов из переменных окружения.
+func initTokenConfig() {
+	if d, err := time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "")); err == nil && d > 0 {
+		accessTokenTTL = d
+	}
+	if d, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "")); err == nil && d > 0 {
+		refreshTokenTTL = d
+	}
+}
+
+// execer — общий интерфейс *sql.DB и *sql.Tx для записи refresh-токенов.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// randomToken возвращает n случайных байт в виде строки, пригодной для URL.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken — в БД хранится только SHA-256 refresh-токена, сам токен знает лишь клиент.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueAccessToken выпускает короткоживущий JWT с уникальным jti, по которому его можно отозвать.
+func issueAccessToken(user User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &auth.Claims{
+		Role:      user.Role,
+		CourierID: user.CourierID,
+		TenantID:  user.TenantID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			Subject:   user.Username,
+		},
+	}
+	return signToken(claims)
+}
+
+// storeRefreshToken создаёт refresh-токен в семействе familyID и сохраняет его хэш.
+// Все токены, полученные ротацией от одного входа, принадлежат одному семейству.
+func storeRefreshToken(q execer, userID int, familyID string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)`, userID, hashToken(token), familyID, time.Now().Add(refreshTokenTTL))
+	return token, err
+}
+
+// tokenPair формирует ответ с парой токенов.
+func tokenPair(accessToken, refreshToken string) gin.H {
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	}
+}
+
+// issueTokens выпускает access-токен и refresh-токен нового семейства (при входе).
+func issueTokens(user User) (gin.H, error) {
+	accessToken, err := issueAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := storeRefreshToken(db, user.ID, familyID)
+	if err != nil {
+		return nil, err
+	}
+	return tokenPair(accessToken, refreshToken), nil
+}
+
+// RefreshRequest — тело запросов /token/refresh и /logout.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"` // для /logout: завершить все сессии пользователя
+}
+
+// refreshHandler обменивает refresh-токен на новую пару токенов. Старый refresh-токен
+// погашается; его повторное предъявление означает утечку, и тогда отзывается всё семейство.
+func refreshHandler(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.BindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не передан refresh_token"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		id, userID int
+		familyID   string
+		expiresAt  time.Time
+		revokedAt  *time.Time
+	)
+	err = tx.QueryRow(`SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens
+		WHERE token_hash = $1 FOR UPDATE`, hashToken(req.RefreshToken)).
+		Scan(&id, &userID, &familyID, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Некорректный refresh-токен"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if revokedAt != nil {
+		err := revokeFamily(tx, familyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Ошибка отзыва семейства refresh-токенов %s: %v", familyID, err)
+		} else {
+			log.Printf("Повторное использование refresh-токена пользователя %d, семейство %s отозвано", userID, familyID)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh-токен уже использован, войдите заново"})
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Срок действия refresh-токена истёк"})
+		return
+	}
+	// роль и привязка к курьеру берутся заново: изменения вступают в силу при обновлении токена
+	user, err := getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Учётная запись отключена"})
+		return
+	}
+	if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	refreshToken, err := storeRefreshToken(tx, userID, familyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	accessToken, err := issueAccessToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании токена"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokenPair(accessToken, refreshToken))
+}
+
+// revokeFamily отзывает все ещё действующие refresh-токены семейства.
+func revokeFamily(q execer, familyID string) error {
+	_, err := q.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+// logoutHandler отзывает текущий access-токен (по jti) и переданный refresh-токен вместе с его семейством.
+// При "all": true завершаются все сессии пользователя.
+func logoutHandler(c *gin.Context) {
+	var req RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+			return
+		}
+	}
+	claims := auth.CurrentClaims(c)
+	if claims.Id != "" {
+		if err := revokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var err error
+	switch {
+	case req.All:
+		_, err = db.Exec(`UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE user_id = (SELECT id FROM users WHERE username = $1) AND revoked_at IS NULL`, claims.Subject)
+	case req.RefreshToken != "":
+		_, err = db.Exec(`UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1) AND revoked_at IS NULL`,
+			hashToken(req.RefreshToken))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Выход выполнен"})
+}
+
+// revokeAccessToken заносит jti в список отозванных до истечения срока действия токена.
+func revokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := db.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`, jti, expiresAt)
+	return err
+}
+
+// isTokenRevokedDB проверяет jti по собственной БД auth-service.
+// При ошибке БД токен считается отозванным.
+func isTokenRevokedDB(jti string) bool {
+	var revoked bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	if err != nil {
+		log.Printf("Ошибка проверки отзыва токена: %v", err)
+		return true
+	}
+	return revoked
+}
+
+// revokedTokensHandler отдаёт jti отозванных, но ещё не истёкших токенов.
+// Другие сервисы периодически загружают этот список (см. auth.StartRevocationSync).
+func revokedTokensHandler(c *gin.Context) {
+	rows, err := db.Query("SELECT jti FROM revoked_tokens WHERE expires_at > NOW()")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	revoked := []string{}
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		revoked = append(revoked, jti)
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// purgeExpiredTokens раз в час удаляет истёкшие отозванные, refresh- и одноразовые токены.
+func purgeExpiredTokens() {
+	for range time.Tick(time.Hour) {
+		if _, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()"); err != nil {
+			log.Printf("Ошибка очистки отозванных токенов: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM refresh_tokens WHERE expires_at < NOW()"); err != nil {
+			log.Printf("Ошибка очистки refresh-токенов: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM user_tokens WHERE expires_at < NOW()"); err != nil {
+			log.Printf("Ошибка очистки токенов из писем: %v", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func expectRefreshTokenInsert(mock sqlmock.Sqlmock, userID int) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)")).
+		WithArgs(userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func postRefresh(token string) *httptest.ResponseRecorder {
+	r := setupTestRouter(db)
+	r.POST("/token/refresh", refreshHandler)
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: token})
+	req := httptest.NewRequest("POST", "/token/refresh", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRefreshToken_Rotates(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens")).
+		WithArgs(hashToken("old-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(10, 1, "fam", time.Now().Add(time.Hour), nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(userRow(1, "disp", "hash", auth.RoleDispatcher, 0, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1")).
+		WithArgs(10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WithArgs(1, sqlmock.AnyArg(), "fam", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(11, 1))
+	mock.ExpectCommit()
+
+	w := postRefresh("old-token")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp["token"])
+	assert.NotEqual(t, "old-token", resp["refresh_token"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	usedAt := time.Now().Add(-time.Minute)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens")).
+		WithArgs(hashToken("stolen")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(10, 1, "fam", time.Now().Add(time.Hour), usedAt))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL")).
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	w := postRefresh("stolen")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshToken_Expired(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens")).
+		WithArgs(hashToken("old")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(10, 1, "fam", time.Now().Add(-time.Hour), nil))
+	mock.ExpectRollback()
+
+	w := postRefresh("old")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogout_RevokesAccessAndRefreshTokens(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(revoked func(string) bool) { auth.TokenRevoked = revoked }(auth.TokenRevoked)
+	auth.TokenRevoked = func(string) bool { return false }
+
+	accessToken, err := issueAccessToken(User{ID: 1, Username: "disp", Role: auth.RoleDispatcher})
+	assert.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO revoked_tokens (jti, expires_at)")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)")).
+		WithArgs(hashToken("refresh")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := setupTestRouter(mockDB)
+	r.POST("/logout", auth.Required(), logoutHandler)
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: "refresh"})
+	req := httptest.NewRequest("POST", "/logout", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
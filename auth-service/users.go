@@ -0,0 +1,551 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// UserView — представление пользователя в API (без пароля).
+type UserView struct {
+	ID                 int        `json:"id"`
+	Username           string     `json:"username"`
+	Role               string     `json:"role"`
+	FullName           string     `json:"full_name"`
+	Email              string     `json:"email"`
+	EmailVerified      bool       `json:"email_verified"`
+	CourierID          string     `json:"courier_id,omitempty"`
+	Disabled           bool       `json:"disabled"`
+	MustChangePassword bool       `json:"must_change_password"`
+	LockedUntil        *time.Time `json:"locked_until,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	TenantID           string     `json:"tenant_id,omitempty"`
+}
+
+func (u User) view() UserView {
+	return UserView{
+		ID:                 u.ID,
+		Username:           u.Username,
+		Role:               u.Role,
+		FullName:           u.FullName,
+		Email:              u.Email,
+		EmailVerified:      u.EmailVerified,
+		CourierID:          u.CourierID,
+		Disabled:           u.Disabled,
+		MustChangePassword: u.MustChangePassword,
+		LockedUntil:        u.LockedUntil,
+		CreatedAt:          u.CreatedAt,
+		TenantID:           u.TenantID,
+	}
+}
+
+// UserRequest — тело POST /users и PUT /users/:id. В PUT незаданные поля не меняются.
+type UserRequest struct {
+	Username  string  `json:"username"`
+	Password  string  `json:"password"`
+	Role      string  `json:"role"`
+	FullName  *string `json:"full_name"`
+	Email     *string `json:"email"`
+	CourierID *string `json:"courier_id"`
+	TenantID  *string `json:"tenant_id"` // меняет только администратор платформы
+}
+
+// ProfileRequest — тело PUT /me: профиль и, при необходимости, смена пароля.
+type ProfileRequest struct {
+	FullName        *string `json:"full_name"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"current_password"`
+	NewPassword     string  `json:"new_password"`
+}
+
+var allRoles = []string{auth.RoleAdmin, auth.RoleDispatcher, auth.RoleCourier, auth.RoleCustomer, auth.RoleAnalyst}
+
+var (
+	errUnknownRole    = errors.New("неизвестная роль")
+	errInvalidEmail   = errors.New("некорректный email")
+	errCourierNotUser = errors.New("привязать запись курьера можно только к пользователю с ролью courier")
+	errSelfChange     = errors.New("нельзя отключить, удалить или понизить собственную учётную запись")
+)
+
+func validRole(role string) bool {
+	for _, r := range allRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
+
+// courierLookupURL — адрес order-service для проверки привязываемой записи курьера.
+var courierLookupURL = os.Getenv("ORDER_SERVICE_URL")
+
+// checkCourierExists проверяет, что курьер есть в order-service и относится к организации tenantID,
+// от имени администратора. Без ORDER_SERVICE_URL проверка пропускается.
+func checkCourierExists(courierID, tenantID, authHeader string) error {
+	if courierLookupURL == "" {
+		return nil
+	}
+	lookup := courierLookupURL + "/couriers/" + url.PathEscape(courierID) + "?tenant_id=" + url.QueryEscape(tenantID)
+	req, err := http.NewRequest(http.MethodGet, lookup, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authHeader)
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("order-service недоступен: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("курьер %s не найден в организации %s", courierID, tenantID)
+	}
+	return fmt.Errorf("order-service ответил %s", resp.Status)
+}
+
+// userIDParam разбирает :id и отвечает 400, если он некорректен.
+func userIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// loadUserOr404 загружает пользователя по :id; при ошибке ответ уже отправлен.
+// Пользователи чужой организации для администратора организации не существуют.
+func loadUserOr404(c *gin.Context) (User, bool) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return User{}, false
+	}
+	user, err := getUserByID(id)
+	if err == nil && !auth.SameTenant(auth.CurrentClaims(c), user.TenantID) {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return user, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return user, false
+	}
+	return user, true
+}
+
+// isSelf сообщает, что администратор пытается изменить свою же учётную запись.
+func isSelf(c *gin.Context, user User) bool {
+	return auth.CurrentClaims(c).Subject == user.Username
+}
+
+// userErrorStatus: ошибки валидации и ссылка на несуществующую организацию — 400,
+// нарушение уникальности — 409, остальное — 500.
+func userErrorStatus(err error) int {
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		return http.StatusConflict
+	case errors.As(err, &pqErr) && pqErr.Code == "23503":
+		return http.StatusBadRequest
+	case errors.Is(err, errUnknownRole), errors.Is(err, errInvalidEmail), errors.Is(err, errCourierNotUser):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// GET /users?role=&tenant_id= — список пользователей (tenant_id — для администратора платформы).
+func listUsersHandler(c *gin.Context) {
+	where, args := auth.TenantScope(c, 1)
+	if role := c.Query("role"); role != "" {
+		args = append(args, role)
+		where += fmt.Sprintf(" AND role = $%d", len(args))
+	}
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	users := []UserView{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		users = append(users, u.view())
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// GET /users/:id
+func getUserHandler(c *gin.Context) {
+	user, ok := loadUserOr404(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, user.view())
+}
+
+// POST /users — создание пользователя с любой ролью.
+func createUserHandler(c *gin.Context) {
+	var req UserRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	user := User{Username: strings.TrimSpace(req.Username), Role: req.Role}
+	if user.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан логин"})
+		return
+	}
+	if err := passwordPolicy.Validate(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyUserRequest(c, &user, req); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// адрес, указанный администратором, считается подтверждённым
+	user.EmailVerified = true
+	err = db.QueryRow(`INSERT INTO users (username, password, role, full_name, email, courier_id, email_verified, tenant_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), TRUE, NULLIF($7, '')) RETURNING id, created_at`,
+		user.Username, hash, user.Role, user.FullName, user.Email, user.CourierID, user.TenantID).Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user.view())
+}
+
+// applyUserRequest переносит заданные поля запроса в пользователя и проверяет их.
+func applyUserRequest(c *gin.Context, user *User, req UserRequest) error {
+	if req.Role != "" {
+		user.Role = req.Role
+	}
+	if !validRole(user.Role) {
+		return errUnknownRole
+	}
+	if claims := auth.CurrentClaims(c); !auth.CrossTenant(claims) {
+		user.TenantID = claims.TenantID
+	} else if req.TenantID != nil {
+		user.TenantID = strings.TrimSpace(*req.TenantID)
+	}
+	if user.TenantID == "" && user.Role != auth.RoleAdmin {
+		// без организации может быть только администратор платформы
+		user.TenantID = auth.DefaultTenant
+	}
+	if req.FullName != nil {
+		user.FullName = strings.TrimSpace(*req.FullName)
+	}
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			return err
+		}
+		user.Email = email
+		user.EmailVerified = true
+	}
+	if req.CourierID != nil {
+		courierID := strings.TrimSpace(*req.CourierID)
+		if courierID != "" && user.Role != auth.RoleCourier {
+			return errCourierNotUser
+		}
+		if courierID != "" && courierID != user.CourierID {
+			if err := checkCourierExists(courierID, user.TenantID, c.GetHeader("Authorization")); err != nil {
+				return err
+			}
+		}
+		user.CourierID = courierID
+	}
+	if user.Role != auth.RoleCourier {
+		// при смене роли ссылка на курьера теряет смысл
+		user.CourierID = ""
+	}
+	return nil
+}
+
+// PUT /users/:id — изменение профиля, роли и привязки к курьеру.
+func updateUserHandler(c *gin.Context) {
+	user, ok := loadUserOr404(c)
+	if !ok {
+		return
+	}
+	var req UserRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if req.Role != "" && req.Role != user.Role && isSelf(c, user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfChange.Error()})
+		return
+	}
+	if err := applyUserRequest(c, &user, req); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveUser(user); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user.view())
+}
+
+// PUT /users/:id/role — назначение роли (и для курьера — записи курьера).
+func setRoleHandler(c *gin.Context) {
+	var req struct {
+		Role      string  `json:"role"`
+		CourierID *string `json:"courier_id"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.Role == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указана роль"})
+		return
+	}
+	user, ok := loadUserOr404(c)
+	if !ok {
+		return
+	}
+	if req.Role != user.Role && isSelf(c, user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfChange.Error()})
+		return
+	}
+	if err := applyUserRequest(c, &user, UserRequest{Role: req.Role, CourierID: req.CourierID}); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveUser(user); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	// новая роль попадёт в токен при следующем обновлении
+	c.JSON(http.StatusOK, user.view())
+}
+
+func saveUser(user User) error {
+	_, err := db.Exec(`UPDATE users SET role = $1, full_name = $2, email = NULLIF($3, ''), courier_id = NULLIF($4, ''),
+		email_verified = $5, tenant_id = NULLIF($6, '') WHERE id = $7`,
+		user.Role, user.FullName, user.Email, user.CourierID, user.EmailVerified, user.TenantID, user.ID)
+	return err
+}
+
+// setDisabledHandler отключает или включает учётную запись. При отключении
+// отзываются все refresh-токены; выданные access-токены доживают свой короткий срок.
+func setDisabledHandler(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := loadUserOr404(c)
+		if !ok {
+			return
+		}
+		if disabled && isSelf(c, user) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errSelfChange.Error()})
+			return
+		}
+		if _, err := db.Exec("UPDATE users SET disabled = $1 WHERE id = $2", disabled, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if disabled {
+			if err := revokeUserSessions(user.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		user.Disabled = disabled
+		c.JSON(http.StatusOK, user.view())
+	}
+}
+
+// POST /users/:id/reset-password — администратор задаёт временный пароль (или получает
+// сгенерированный); пользователь обязан сменить его через PUT /me.
+func resetPasswordHandler(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+			return
+		}
+	}
+	user, ok := loadUserOr404(c)
+	if !ok {
+		return
+	}
+	generated := req.Password == ""
+	if generated {
+		var err error
+		if req.Password, err = randomToken(12); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else if err := passwordPolicy.Validate(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = db.Exec(`UPDATE users SET password = $1, must_change_password = TRUE, failed_attempts = 0, locked_until = NULL
+		WHERE id = $2`, hash, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := revokeUserSessions(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Пароль пользователя %s сброшен администратором %s", user.Username, auth.CurrentClaims(c).Subject)
+	resp := gin.H{"status": "Пароль сброшен, пользователь должен сменить его при входе"}
+	if generated {
+		resp["temporary_password"] = req.Password
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /users/:id
+func deleteUserHandler(c *gin.Context) {
+	user, ok := loadUserOr404(c)
+	if !ok {
+		return
+	}
+	if isSelf(c, user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfChange.Error()})
+		return
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id = $1", user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Пользователь удалён"})
+}
+
+// revokeUserSessions отзывает все refresh-токены пользователя.
+func revokeUserSessions(userID int) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// GET /me — профиль текущего пользователя.
+func getMeHandler(c *gin.Context) {
+	user, err := getUserByUsername(auth.CurrentClaims(c).Subject)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	c.JSON(http.StatusOK, user.view())
+}
+
+// PUT /me — изменение своего профиля. Для смены пароля нужен текущий пароль;
+// после смены прочие сессии завершаются, а в ответе выдаётся новая пара токенов.
+func updateMeHandler(c *gin.Context) {
+	var req ProfileRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	user, err := getUserByUsername(auth.CurrentClaims(c).Subject)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if req.FullName != nil {
+		user.FullName = strings.TrimSpace(*req.FullName)
+	}
+	emailChanged := false
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if email != user.Email {
+			user.Email = email
+			// новый адрес нужно подтвердить
+			if email != "" {
+				user.EmailVerified, emailChanged = false, true
+			}
+		}
+	}
+	if req.NewPassword != "" {
+		if ok, _ := checkPassword(user.Password, req.CurrentPassword); !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Неверный текущий пароль"})
+			return
+		}
+		if err := passwordPolicy.Validate(req.NewPassword); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := saveUser(user); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if emailChanged {
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("Ошибка отправки письма подтверждения для %s: %v", user.Username, err)
+		}
+	}
+	if req.NewPassword == "" {
+		c.JSON(http.StatusOK, user.view())
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := db.Exec("UPDATE users SET password = $1, must_change_password = FALSE WHERE id = $2", hash, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := revokeUserSessions(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании токена"})
+		return
+	}
+	user.MustChangePassword = false
+	tokens["user"] = user.view()
+	c.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+var userRowColumns = []string{"id", "username", "password", "role", "failed_attempts", "locked_until", "courier_id",
+	"full_name", "email", "disabled", "must_change_password", "created_at", "email_verified", "tenant_id"}
+
+func userRow(id int, username, password, role string, failedAttempts int, lockedUntil any) *sqlmock.Rows {
+	return sqlmock.NewRows(userRowColumns).
+		AddRow(id, username, password, role, failedAttempts, lockedUntil, "", "", "", false, false, time.Now(), true, "")
+}
+
+func setupUsersRouter(mockDB *sql.DB) *gin.Engine {
+	r := setupTestRouter(mockDB)
+	admin := r.Group("/users", auth.Required(auth.RoleAdmin))
+	admin.GET("", listUsersHandler)
+	admin.POST("", createUserHandler)
+	admin.PUT("/:id/role", setRoleHandler)
+	admin.POST("/:id/disable", setDisabledHandler(true))
+	r.PUT("/me", auth.Required(), updateMeHandler)
+	return r
+}
+
+func doAuthorized(t *testing.T, r *gin.Engine, method, path string, user User, body any) *httptest.ResponseRecorder {
+	token, err := issueAccessToken(user)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var testAdmin = User{ID: 1, Username: "root", Role: auth.RoleAdmin}
+
+func TestUsersRequireAdmin(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "GET", "/users", User{Username: "disp", Role: auth.RoleDispatcher}, nil)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsers(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE TRUE AND role = $1 ORDER BY id")).
+		WithArgs(auth.RoleCourier).
+		WillReturnRows(userRow(5, "ivan", "hash", auth.RoleCourier, 0, nil))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "GET", "/users?role=courier", testAdmin, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"username":"ivan"`)
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsers_TenantAdminSeesOwnTenant(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	// ?tenant_id= чужой организации администратору организации не помогает
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE tenant_id = $1 ORDER BY id")).
+		WithArgs("shop-1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "GET", "/users?tenant_id=shop-2", User{Username: "shop-admin", Role: auth.RoleAdmin, TenantID: "shop-1"}, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUser_UnknownRole(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "POST", "/users", testAdmin,
+		UserRequest{Username: "x", Password: "password1", Role: "superuser"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetRole_LinksCourier(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(5).
+		WillReturnRows(userRow(5, "ivan", "hash", auth.RoleCustomer, 0, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET role = $1, full_name = $2, email = NULLIF($3, ''), courier_id = NULLIF($4, '')")).
+		WithArgs(auth.RoleCourier, "", "", "c-1", true, auth.DefaultTenant, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	courierID := "c-1"
+	w := doAuthorized(t, setupUsersRouter(mockDB), "PUT", "/users/5/role", testAdmin,
+		map[string]any{"role": auth.RoleCourier, "courier_id": courierID})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"courier_id":"c-1"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetRole_CourierIDRequiresCourierRole(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(5).
+		WillReturnRows(userRow(5, "ivan", "hash", auth.RoleCustomer, 0, nil))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "PUT", "/users/5/role", testAdmin,
+		map[string]any{"role": auth.RoleDispatcher, "courier_id": "c-1"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDisableUser_RevokesSessions(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(5).
+		WillReturnRows(userRow(5, "ivan", "hash", auth.RoleCourier, 0, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET disabled = $1 WHERE id = $2")).
+		WithArgs(true, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1")).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "POST", "/users/5/disable", testAdmin, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"disabled":true`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDisableUser_Self(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(userRow(1, "root", "hash", auth.RoleAdmin, 0, nil))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "POST", "/users/1/disable", testAdmin, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateMe_WrongCurrentPassword(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("ivan").
+		WillReturnRows(userRow(5, "ivan", mustHash(t, "oldpass1"), auth.RoleCourier, 0, nil))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "PUT", "/me", User{Username: "ivan", Role: auth.RoleCourier},
+		ProfileRequest{CurrentPassword: "wrong", NewPassword: "newpass12"})
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginUser_Disabled(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("ivan").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).
+			AddRow(5, "ivan", mustHash(t, "testpass1"), auth.RoleCourier, 0, nil, "", "", "", true, false, time.Now(), true, ""))
+
+	r := setupTestRouter(mockDB)
+	body, _ := json.Marshal(Credentials{Username: "ivan", Password: "testpass1"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantAdminCannotTouchOtherTenantUsers(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	// пользователь платформы (без организации)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(userRow(1, "root", "hash", auth.RoleAdmin, 0, nil))
+
+	w := doAuthorized(t, setupUsersRouter(mockDB), "POST", "/users/1/disable", User{Username: "shop-admin", Role: auth.RoleAdmin, TenantID: "shop-1"}, nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streadway/amqp"
+)
+
+// Назначение одноразовых токенов, отправляемых по email.
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+var (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+
+	// Ссылки в письмах; токен дописывается в конец.
+	verifyEmailURL   = "http://localhost:8081/verify-email?token="
+	passwordResetURL = ""
+)
+
+var errInvalidUserToken = errors.New("ссылка недействительна или устарела")
+
+// initVerificationConfig читает срок жизни токенов и шаблоны ссылок из переменных окружения.
+func initVerificationConfig() {
+	if d, err := time.ParseDuration(getEnv("EMAIL_VERIFICATION_TTL", "")); err == nil && d > 0 {
+		emailVerificationTTL = d
+	}
+	if d, err := time.ParseDuration(getEnv("PASSWORD_RESET_TTL", "")); err == nil && d > 0 {
+		passwordResetTTL = d
+	}
+	verifyEmailURL = getEnv("VERIFY_EMAIL_URL", verifyEmailURL)
+	passwordResetURL = getEnv("PASSWORD_RESET_URL", passwordResetURL)
+}
+
+// EmailMessage — сообщение очереди notifications (формат NotificationMessage notification-service).
+type EmailMessage struct {
+	Type      string `json:"type"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject,omitempty"`
+	Message   string `json:"message"`
+	// Sensitive: текст содержит секрет (ссылку со сбросом пароля) и не сохраняется в журнале уведомлений
+	Sensitive bool   `json:"sensitive,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"` // организация получателя, для журнала уведомлений
+}
+
+var publishEmailFunc = publishEmail
+
+// publishEmail отправляет письмо через очередь notifications.
+func publishEmail(msg EmailMessage) error {
+	conn, err := amqp.Dial(os.Getenv("RABBITMQ_URL"))
+	if err != nil {
+		return fmt.Errorf("ошибка подключения к RabbitMQ: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("ошибка открытия канала: %w", err)
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare("notifications", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("ошибка объявления очереди: %w", err)
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return ch.Publish("", "notifications", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// createUserToken выпускает одноразовый токен для пользователя; предыдущие неиспользованные
+// токены того же назначения погашаются. В БД хранится только хэш.
+func createUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// consumeUserToken погашает токен и возвращает ID пользователя. Повторно токен не сработает.
+func consumeUserToken(tx *sql.Tx, token, purpose string) (int, error) {
+	var userID int
+	err := tx.QueryRow(`UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, hashToken(token), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidUserToken
+	}
+	return userID, err
+}
+
+// sendVerificationEmail отправляет пользователю ссылку для подтверждения email.
+func sendVerificationEmail(user User) error {
+	token, err := createUserToken(user.ID, purposeVerifyEmail, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return publishEmailFunc(EmailMessage{
+		Type:      "email_verification",
+		Recipient: user.Email,
+		TenantID:  user.TenantID,
+		Subject:   "Подтверждение email",
+		Message: fmt.Sprintf("Здравствуйте, %s!\n\nПодтвердите адрес электронной почты, перейдя по ссылке:\n%s%s\n\nСсылка действует %s.",
+			user.Username, verifyEmailURL, token, emailVerificationTTL),
+		Sensitive: true,
+	})
+}
+
+// sendPasswordResetEmail отправляет ссылку (или код) для сброса пароля.
+func sendPasswordResetEmail(user User) error {
+	token, err := createUserToken(user.ID, purposeResetPassword, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	instruction := "Код для сброса пароля: " + token
+	if passwordResetURL != "" {
+		instruction = "Чтобы задать новый пароль, перейдите по ссылке:\n" + passwordResetURL + token
+	}
+	return publishEmailFunc(EmailMessage{
+		Type:      "password_reset",
+		Recipient: user.Email,
+		TenantID:  user.TenantID,
+		Subject:   "Сброс пароля",
+		Message: fmt.Sprintf("Здравствуйте, %s!\n\n%s\n\nСрок действия — %s. Если вы не запрашивали сброс, просто проигнорируйте письмо.",
+			user.Username, instruction, passwordResetTTL),
+		Sensitive: true,
+	})
+}
+
+// verifyEmailHandler подтверждает email по токену из письма (POST {token} или GET ?token=).
+func verifyEmailHandler(c *gin.Context) {
+	token := c.Query("token")
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не передан токен"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	userID, err := consumeUserToken(tx, token, purposeVerifyEmail)
+	if err == errInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE users SET email_verified = TRUE WHERE id = $1", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Email подтверждён, можно войти"})
+}
+
+// EmailRequest — тело /verify-email/resend и /password/forgot.
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// emailRequestHandler отвечает одинаково, есть такой адрес или нет, чтобы
+// по ответу нельзя было узнать зарегистрированные email.
+func emailRequestHandler(send func(User) error, eligible func(User) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EmailRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+			return
+		}
+		email, err := normalizeEmail(req.Email)
+		if err != nil || email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidEmail.Error()})
+			return
+		}
+		user, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1", email))
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			log.Printf("Ошибка поиска пользователя по email: %v", err)
+		case eligible(user):
+			if err := send(user); err != nil {
+				log.Printf("Ошибка отправки письма пользователю %s: %v", user.Username, err)
+			}
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "Если адрес зарегистрирован, на него отправлено письмо"})
+	}
+}
+
+// POST /verify-email/resend — повторная отправка письма подтверждения.
+var resendVerificationHandler = emailRequestHandler(sendVerificationEmail, func(u User) bool {
+	return !u.Disabled && !u.EmailVerified
+})
+
+// POST /password/forgot — запрос ссылки для сброса пароля.
+var forgotPasswordHandler = emailRequestHandler(sendPasswordResetEmail, func(u User) bool {
+	return !u.Disabled
+})
+
+// POST /password/reset — установка нового пароля по токену из письма. Снимает блокировку,
+// подтверждает email (владение почтой доказано) и завершает все сессии пользователя.
+func resetPasswordByTokenHandler(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if err := passwordPolicy.Validate(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	userID, err := consumeUserToken(tx, req.Token, purposeResetPassword)
+	if err == errInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = tx.Exec(`UPDATE users SET password = $1, must_change_password = FALSE, failed_attempts = 0,
+		locked_until = NULL, email_verified = TRUE WHERE id = $2`, hash, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Пароль изменён, войдите с новым паролем"})
+}
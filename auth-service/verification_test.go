@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// stubPublishEmail перехватывает письма вместо отправки в RabbitMQ.
+func stubPublishEmail(t *testing.T) *[]EmailMessage {
+	var sent []EmailMessage
+	publishEmailFunc = func(msg EmailMessage) error {
+		sent = append(sent, msg)
+		return nil
+	}
+	t.Cleanup(func() { publishEmailFunc = publishEmail })
+	return &sent
+}
+
+func expectUserTokenInsert(mock sqlmock.Sqlmock, userID int, purpose string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL")).
+		WithArgs(userID, purpose).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)")).
+		WithArgs(userID, purpose, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+func setupVerificationRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", loginHandler)
+	r.POST("/verify-email", verifyEmailHandler)
+	r.POST("/password/forgot", forgotPasswordHandler)
+	r.POST("/password/reset", resetPasswordByTokenHandler)
+	return r
+}
+
+func postJSON(r *gin.Engine, path string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterUser_RequiresEmail(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	w := postJSON(setupTestRouter(mockDB), "/register", Credentials{Username: "just-a-name", Password: "testpass1"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginUser_EmailNotVerified(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE username = $1")).
+		WithArgs("anna").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).
+			AddRow(3, "anna", mustHash(t, "testpass1"), auth.RoleCustomer, 0, nil, "", "", "anna@example.com", false, false, time.Now(), false, ""))
+
+	w := postJSON(setupVerificationRouter(), "/login", Credentials{Username: "anna", Password: "testpass1"})
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Email не подтверждён")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs(hashToken("tok"), purposeVerifyEmail).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET email_verified = TRUE WHERE id = $1")).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := postJSON(setupVerificationRouter(), "/verify-email", map[string]string{"token": "tok"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmail_UsedOrExpiredToken(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs(hashToken("tok"), purposeVerifyEmail).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	w := postJSON(setupVerificationRouter(), "/verify-email", map[string]string{"token": "tok"})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForgotPassword_UnknownEmailLooksTheSame(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	sent := stubPublishEmail(t)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE email = $1")).
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows(userRowColumns))
+
+	w := postJSON(setupVerificationRouter(), "/password/forgot", EmailRequest{Email: "nobody@example.com"})
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, *sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForgotPassword_SendsResetEmail(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	sent := stubPublishEmail(t)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE email = $1")).
+		WithArgs("anna@example.com").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).
+			AddRow(3, "anna", "hash", auth.RoleCustomer, 0, nil, "", "", "anna@example.com", false, false, time.Now(), true, ""))
+	expectUserTokenInsert(mock, 3, purposeResetPassword)
+
+	w := postJSON(setupVerificationRouter(), "/password/forgot", EmailRequest{Email: "anna@example.com"})
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	if assert.Len(t, *sent, 1) {
+		assert.Equal(t, "password_reset", (*sent)[0].Type)
+		assert.True(t, strings.Contains((*sent)[0].Message, "Код для сброса пароля"))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetPasswordByToken(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs(hashToken("tok"), purposeResetPassword).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password = $1, must_change_password = FALSE")).
+		WithArgs(bcryptHashArg{password: "newpass12"}, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1")).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := postJSON(setupVerificationRouter(), "/password/reset", map[string]string{"token": "tok", "password": "newpass12"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,8 +32,18 @@ type Order struct {
 	Height        float64 `json:"height"`
 	Urgency       int     `json:"urgency"`
 	CourierID     string  `json:"courier_id"`
+	WindowStart   *time.Time `json:"window_start,omitempty"`
+	WindowEnd     *time.Time `json:"window_end,omitempty"`
+	Email         string  `json:"email"`
+	PromoCode     string  `json:"promo_code,omitempty"`
+	Currency      string  `json:"currency,omitempty"`
+	PaymentMethod string  `json:"payment_method,omitempty"`
+	CODAmount     float64 `json:"cod_amount,omitempty"`
 }
 
+// paymentCOD — заказ оплачивается наличными курьеру при вручении.
+const paymentCOD = "cash_on_delivery"
+
 // GeoResult хранит ответ геокодера.
 type GeoResult struct {
 	Lat string `json:"lat"`
@@ -48,12 +63,18 @@ type DeliveryRequest struct {
 	Urgency int     `json:"urgency"`
 	OrderID   string  `json:"order_id"`   // 🆕 ID заказа
 	CourierID string  `json:"courier_id"` // 🆕 ID курьера
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+	PromoCode   string     `json:"promo_code,omitempty"`
+	Customer    string     `json:"customer,omitempty"`
+	Currency    string     `json:"currency,omitempty"`
 }
 
 // DeliveryResponse хранит ответ сервиса расчёта стоимости.
 type DeliveryResponse struct {
 	EstimatedCost float64 `json:"estimated_cost"`
 	Currency      string  `json:"currency"`
+	Discount      float64 `json:"discount"`
 }
 
 // TrackingInfo отправляется в Tracking Service.
@@ -66,6 +87,33 @@ type CourierTracking struct {
 }
 
 // moveTowards плавно перемещает курьера к целевой точке, отправляя трекинг.
+// authToken — JWT курьера из auth-service (POST /login). Сервисы без токена отвечают 401.
+var authToken = os.Getenv("COURIER_TOKEN")
+
+// doRequest выполняет запрос к сервисам платформы с токеном курьера; тело — JSON.
+func doRequest(method, endpoint string, body []byte) (*http.Response, error) {
+	contentType := ""
+	if body != nil {
+		contentType = "application/json"
+	}
+	return doRequestType(method, endpoint, contentType, body)
+}
+
+// doRequestType выполняет запрос с телом типа contentType.
+func doRequestType(method, endpoint, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	return http.DefaultClient.Do(req)
+}
+
 func moveTowards(lat, lon *float64, targetLat, targetLon float64, steps int, orderID, courierID, trackingURL string) {
 	stepLat := (targetLat - *lat) / float64(steps)
 	stepLon := (targetLon - *lon) / float64(steps)
@@ -85,7 +133,7 @@ func moveTowards(lat, lon *float64, targetLat, targetLon float64, steps int, ord
 			log.Printf("Ошибка маршалинга JSON: %v", err)
 			continue
 		}
-		resp, err := http.Post(trackingURL, "application/json", bytes.NewReader(data))
+		resp, err := doRequest("POST", trackingURL, data)
 		if err != nil {
 			log.Printf("Ошибка трекинга: %v", err)
 		} else {
@@ -132,7 +180,10 @@ func main() {
 	deliveryURL := "http://localhost:8086/calculate"
 
 	// 1) Получаем заказ
-	resp, err := http.Get(orderURL)
+	if authToken == "" {
+		log.Println("⚠️ COURIER_TOKEN не задан, запросы к сервисам будут отклонены")
+	}
+	resp, err := doRequest("GET", orderURL, nil)
 	if err != nil || resp.StatusCode != http.StatusOK {
 		log.Fatalf("не удалось получить заказ %s: %v", orderID, err)
 	}
@@ -168,9 +219,14 @@ func main() {
 		Urgency: order.Urgency,
 		OrderID:  order.ID,          // 🟢 добавлено
 		CourierID: order.CourierID,  // 🟢 добавлено
+		WindowStart: order.WindowStart,
+		WindowEnd:   order.WindowEnd,
+		PromoCode:   order.PromoCode,
+		Customer:    order.Email,
+		Currency:    order.Currency,
 	}
 	b, _ := json.Marshal(dReq)
-	dResp, err := http.Post(deliveryURL, "application/json", bytes.NewReader(b))
+	dResp, err := doRequest("POST", deliveryURL, b)
 	if err != nil {
 		log.Fatalf("расчёт доставки: %v", err)
 	}
@@ -179,11 +235,11 @@ func main() {
 	if err := json.NewDecoder(dResp.Body).Decode(&dRes); err != nil {
 		log.Fatalf("декодирование стоимости: %v", err)
 	}
-	log.Printf("Стоимость: %.2f %s", dRes.EstimatedCost, dRes.Currency)
+	log.Printf("Стоимость: %.2f %s (скидка %.2f)", dRes.EstimatedCost, dRes.Currency, dRes.Discount)
 
 	// 4) Текущие координаты курьера
 	ctURL := "http://localhost:8083/couriers/tracking/" + courierID
-	respCT, err := http.Get(ctURL)
+	respCT, err := doRequest("GET", ctURL, nil)
 	if err != nil || respCT.StatusCode != http.StatusOK {
 		log.Fatalf("координаты курьера: %v", err)
 	}
@@ -200,14 +256,70 @@ func main() {
 	moveTowards(&lat, &lon, fromLat, fromLng, 10, orderID, courierID, trackingURL)
 	moveTowards(&lat, &lon, toLat, toLng, 15, orderID, courierID, trackingURL)
 
-	// 6) Завершение заказа
-	finishURL := orderURL + "/finish"
-	rf, _ := http.NewRequest("PUT", finishURL, nil)
-	client := &http.Client{}
-	resF, err := client.Do(rf)
-	if err != nil || resF.StatusCode != http.StatusOK {
+	// 6) Завершение заказа: получатель называет PIN из письма
+	if err := finishOrder(orderURL+"/finish", order, bufio.NewReader(os.Stdin)); err != nil {
 		log.Fatalf("завершение заказа: %v", err)
 	}
-	defer resF.Body.Close()
 	log.Println("Заказ завершён")
 }
+
+// prompt спрашивает значение у курьера.
+func prompt(in *bufio.Reader, label string) (string, error) {
+	fmt.Print(label)
+	s, err := in.ReadString('\n')
+	if err != nil && s == "" {
+		return "", err
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// finishOrder завершает заказ multipart-формой PUT /orders/:id/finish: PIN получателя, а для наложенного
+// платежа — полученная сумма. При неверном PIN спрашивает снова, пока order-service оставляет попытки;
+// после их исчерпания заказ может завершить только диспетчер.
+func finishOrder(finishURL string, order Order, in *bufio.Reader) error {
+	collected := ""
+	if order.PaymentMethod == paymentCOD {
+		amount := strconv.FormatFloat(order.CODAmount, 'f', 2, 64)
+		s, err := prompt(in, fmt.Sprintf("Получено наличными (к оплате %s, Enter — вся сумма): ", amount))
+		if err != nil {
+			return fmt.Errorf("чтение суммы: %w", err)
+		}
+		if collected = s; collected == "" {
+			collected = amount
+		}
+	}
+	for {
+		pin, err := prompt(in, "PIN получателя: ")
+		if err != nil {
+			return fmt.Errorf("чтение PIN: %w", err)
+		}
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("pin", pin)
+		if collected != "" {
+			w.WriteField("collected_amount", collected)
+		}
+		w.Close()
+
+		resp, err := doRequestType("PUT", finishURL, w.FormDataContentType(), body.Bytes())
+		if err != nil {
+			return err
+		}
+		var res struct {
+			Error             string `json:"error"`
+			AttemptsRemaining int    `json:"attempts_remaining"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case resp.StatusCode == http.StatusUnprocessableEntity && res.AttemptsRemaining > 0:
+			log.Printf("❌ %s, осталось попыток: %d", res.Error, res.AttemptsRemaining)
+		case res.Error != "":
+			return errors.New(res.Error)
+		default:
+			return fmt.Errorf("order-service ответил %s", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExchangeRate — курс валюты к базовой валюте тарифа (CURRENCY):
+// сколько единиц базовой валюты стоит одна единица Currency.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var (
+	errUnknownCurrency = errors.New("курс для валюты не задан")
+	currencyCodeRe     = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// normalizeCurrency приводит код валюты к виду ISO 4217; пустой код означает базовую валюту.
+func normalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return currency
+	}
+	return code
+}
+
+// getExchangeRate возвращает курс валюты к базовой; для базовой валюты курс равен 1.
+func getExchangeRate(code string) (float64, error) {
+	if code == currency {
+		return 1, nil
+	}
+	var rate float64
+	err := db.QueryRow(`SELECT rate FROM exchange_rates WHERE currency = $1`, code).Scan(&rate)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownCurrency
+	}
+	return rate, err
+}
+
+// roundMoney округляет сумму до копеек/центов.
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// convertQuote переводит все суммы расчёта из базовой валюты в валюту клиента.
+func convertQuote(resp *DeliveryResponse, code string, rate float64) {
+	resp.BaseCurrency = currency
+	resp.ExchangeRate = rate
+	resp.Currency = code
+	if code == currency {
+		return
+	}
+	resp.BaseCost = roundMoney(resp.BaseCost / rate)
+	resp.Discount = roundMoney(resp.Discount / rate)
+	resp.EstimatedCost = roundMoney(resp.EstimatedCost / rate)
+}
+
+// getExchangeRatesHandler возвращает таблицу курсов.
+func getExchangeRatesHandler(c *gin.Context) {
+	rows, err := db.Query(`SELECT currency, rate, updated_at FROM exchange_rates ORDER BY currency`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	list := []ExchangeRate{}
+	for rows.Next() {
+		var r ExchangeRate
+		if err := rows.Scan(&r.Currency, &r.Rate, &r.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"base_currency": currency, "rates": list})
+}
+
+// putExchangeRateHandler создаёт или обновляет курс валюты.
+func putExchangeRateHandler(c *gin.Context) {
+	code := normalizeCurrency(c.Param("currency"))
+	if !currencyCodeRe.MatchString(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Код валюты должен состоять из трёх букв ISO 4217"})
+		return
+	}
+	if code == currency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Курс базовой валюты всегда равен 1"})
+		return
+	}
+	var body struct {
+		Rate float64 `json:"rate"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.Rate <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Курс должен быть положительным числом"})
+		return
+	}
+	r := ExchangeRate{Currency: code, Rate: body.Rate, UpdatedAt: time.Now()}
+	_, err := db.Exec(`
+		INSERT INTO exchange_rates (currency, rate, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = EXCLUDED.updated_at
+	`, r.Currency, r.Rate, r.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeCurrency(t *testing.T) {
+	currency = "RUB"
+	assert.Equal(t, "RUB", normalizeCurrency(""))
+	assert.Equal(t, "USD", normalizeCurrency(" usd "))
+}
+
+func TestConvertQuote(t *testing.T) {
+	currency = "RUB"
+	resp := DeliveryResponse{BaseCost: 1000, Discount: 100, EstimatedCost: 900, Currency: "RUB"}
+
+	convertQuote(&resp, "USD", 90)
+
+	assert.Equal(t, "USD", resp.Currency)
+	assert.Equal(t, "RUB", resp.BaseCurrency)
+	assert.Equal(t, 90.0, resp.ExchangeRate)
+	assert.InDelta(t, 11.11, resp.BaseCost, 0.001)
+	assert.InDelta(t, 1.11, resp.Discount, 0.001)
+	assert.InDelta(t, 10, resp.EstimatedCost, 0.001)
+}
+
+func TestConvertQuoteBaseCurrency(t *testing.T) {
+	currency = "RUB"
+	resp := DeliveryResponse{BaseCost: 1000, EstimatedCost: 1000, Currency: "RUB"}
+
+	convertQuote(&resp, "RUB", 1)
+
+	assert.Equal(t, "RUB", resp.Currency)
+	assert.Equal(t, 1.0, resp.ExchangeRate)
+	assert.Equal(t, 1000.0, resp.EstimatedCost)
+}
@@ -0,0 +1,56 @@
+-- Журнал расчётов стоимости: какие коэффициенты применены к каждой котировке
+CREATE TABLE IF NOT EXISTS price_quotes (
+    id SERIAL PRIMARY KEY,
+    order_id VARCHAR(50),
+    base_cost NUMERIC NOT NULL,
+    slot_factor NUMERIC NOT NULL DEFAULT 1,
+    surge_multiplier NUMERIC NOT NULL DEFAULT 1,
+    open_orders INTEGER,
+    available_couriers INTEGER,
+    window_start TIMESTAMPTZ,
+    window_end TIMESTAMPTZ,
+    total_cost NUMERIC NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_quotes_order_id ON price_quotes (order_id);
+
+-- Промокоды и скидки
+CREATE TABLE IF NOT EXISTS promo_codes (
+    code VARCHAR(64) PRIMARY KEY,
+    discount_type VARCHAR(10) NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    discount_value NUMERIC NOT NULL,
+    min_order_value NUMERIC NOT NULL DEFAULT 0,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    per_customer_limit INTEGER NOT NULL DEFAULT 0,
+    used_count INTEGER NOT NULL DEFAULT 0,
+    valid_from TIMESTAMPTZ,
+    valid_to TIMESTAMPTZ,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL REFERENCES promo_codes(code),
+    order_id VARCHAR(50) NOT NULL,
+    customer TEXT,
+    discount NUMERIC NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (code, order_id)
+);
+
+ALTER TABLE price_quotes
+  ADD COLUMN IF NOT EXISTS discount NUMERIC NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS promo_code VARCHAR(64);
+
+-- Курсы валют к базовой валюте тарифа (CURRENCY): сколько единиц базовой валюты стоит единица currency
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency VARCHAR(3) PRIMARY KEY,
+    rate NUMERIC NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE price_quotes
+  ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC NOT NULL DEFAULT 1;
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 	"net/http"
@@ -10,7 +12,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
 	"github.com/streadway/amqp"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 // DeliveryRequest содержит входные параметры для расчёта стоимости доставки.
@@ -24,14 +28,33 @@ type DeliveryRequest struct {
 	Width    float64 `json:"width"`     // Ширина посылки (метры)
 	Height   float64 `json:"height"`    // Высота посылки (метры)
 	Urgency  int     `json:"urgency"`   // 1 — стандарт, 2 — экспресс
-	OrderID   string  `json:"order_id"`   // 🆕 ID заказа
-	CourierID string  `json:"courier_id"` // 🆕 ID курьера
+	OrderID   string  `json:"order_id"`   // 🆕 ID заказа — только от order-service
+	CourierID string  `json:"courier_id"` // 🆕 ID курьера — только от order-service
+
+	// Выбранное окно доставки (см. GET /slots); nil — без окна
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+
+	PromoCode string `json:"promo_code,omitempty"` // Промокод клиента
+	Customer  string `json:"customer,omitempty"`   // Автор заказа для лимита на клиента — только от order-service
+	Currency  string `json:"currency,omitempty"`   // Валюта клиента (ISO 4217); пусто — базовая валюта тарифа
+	TenantID  string `json:"tenant_id,omitempty"`  // Организация заказа — учитывается только для сервисных вызовов
 }
 
-// DeliveryResponse содержит рассчитанную стоимость доставки.
+// DeliveryResponse содержит рассчитанную стоимость доставки и применённые коэффициенты.
 type DeliveryResponse struct {
-	EstimatedCost float64 `json:"estimated_cost"`
-	Currency      string  `json:"currency"`
+	EstimatedCost   float64    `json:"estimated_cost"`
+	Currency        string     `json:"currency"`
+	BaseCurrency    string     `json:"base_currency"`
+	ExchangeRate    float64    `json:"exchange_rate"` // единиц базовой валюты за единицу Currency
+	BaseCost        float64    `json:"base_cost"`
+	SlotFactor      float64    `json:"slot_factor"`
+	SurgeMultiplier float64    `json:"surge_multiplier"`
+	WindowStart     *time.Time `json:"window_start,omitempty"`
+	WindowEnd       *time.Time `json:"window_end,omitempty"`
+	Discount        float64    `json:"discount"`
+	PromoCode       string     `json:"promo_code,omitempty"`
+	QuoteID         int        `json:"quote_id,omitempty"`
 }
 
 var (
@@ -40,9 +63,31 @@ var (
 	weightRate    float64
 	volumeRate    float64
 	urgencyFactor float64
-	currency      string
+	currency      string // базовая валюта тарифа, в ней задаются ставки и фиксированные скидки
 )
 
+var db *sql.DB
+
+// initDB устанавливает соединение с базой данных delivery-service.
+func initDB() (*sql.DB, error) {
+	host := os.Getenv("DB_HOST")
+	user := os.Getenv("DB_USER")
+	password := os.Getenv("DB_PASSWORD")
+	dbname := os.Getenv("DB_NAME")
+	port := "5432"
+
+	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, user, password, dbname)
+	database, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err = database.Ping(); err != nil {
+		return nil, err
+	}
+	return database, nil
+}
+
 // getEnv возвращает значение переменной окружения или defaultVal, если переменная не задана.
 func getEnv(key, defaultVal string) string {
 	val := os.Getenv(key)
@@ -111,7 +156,10 @@ func calculateDeliveryCost(req DeliveryRequest) float64 {
 	return cost
 }
 
-func publishDeliveryCalculatedEvent(orderID, courierID string, cost float64) error {
+// publishDeliveryCalculatedEvent публикует рассчитанную стоимость. Суммы передаются в валюте клиента
+// вместе с кодом валюты и курсом к базовой, чтобы потребители могли их нормализовать.
+// tenantID — организация, в которой сделан расчёт (аналитика ведётся по организациям).
+func publishDeliveryCalculatedEvent(orderID, courierID, tenantID string, resp DeliveryResponse) error {
 	rabbitURL := os.Getenv("RABBITMQ_URL")
 	conn, err := amqp.Dial(rabbitURL)
 	if err != nil {
@@ -134,7 +182,13 @@ func publishDeliveryCalculatedEvent(orderID, courierID string, cost float64) err
 		"event":      "delivery_calculated",
 		"order_id":   orderID,
 		"courier_id": courierID,
-		"cost":       cost,
+		"tenant_id":  tenantID,
+		"cost":          resp.EstimatedCost,
+		"discount":      resp.Discount,
+		"promo_code":    resp.PromoCode,
+		"currency":      resp.Currency,
+		"base_currency": resp.BaseCurrency,
+		"exchange_rate": resp.ExchangeRate,
 	}
 	body, _ := json.Marshal(event)
 
@@ -147,6 +201,16 @@ func publishDeliveryCalculatedEvent(orderID, courierID string, cost float64) err
 func main() {
 	// Инициализируем конфигурацию
 	initConfig()
+	initPricingConfig()
+
+	var err error
+	db, err = initDB()
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+
+	// Список отозванных токенов подтягивается из auth-service (AUTH_URL).
+	auth.StartRevocationSync()
 
 	// Создаем роутер Gin
 	r := gin.Default()
@@ -155,25 +219,90 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok", "time": time.Now().Format(time.RFC3339)})
 	})
 
+	// GET /slots?date=YYYY-MM-DD — доступные окна доставки с ценовыми коэффициентами.
+	r.GET("/slots", auth.Required(), func(c *gin.Context) {
+		now := time.Now()
+		date := now
+		if dateParam := c.Query("date"); dateParam != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", dateParam, slotLocation)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата, используйте YYYY-MM-DD"})
+				return
+			}
+			date = parsed
+		}
+		c.JSON(http.StatusOK, slotsForDate(date, now))
+	})
+
 	// POST /calculate — эндпоинт для расчета стоимости доставки.
-	r.POST("/calculate", func(c *gin.Context) {
+	// order-service (auth.RoleService) запрашивает цену заказа перед оплатой от имени организации заказа.
+	r.POST("/calculate", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleCourier, auth.RoleCustomer, auth.RoleService), func(c *gin.Context) {
 		var req DeliveryRequest
 		if err := c.BindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
 			return
 		}
-		cost := calculateDeliveryCost(req)
-		resp := DeliveryResponse{
-			EstimatedCost: cost,
-			Currency:      currency,
+		scopeCalculateRequest(auth.CurrentClaims(c), &req)
+		quoteCurrency := normalizeCurrency(req.Currency)
+		rate, err := getExchangeRate(quoteCurrency)
+		if err != nil {
+			if err == errUnknownCurrency {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error() + ": " + quoteCurrency})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var load *DispatchLoad
+		if surgeEnabled {
+			l, err := fetchDispatchLoad()
+			if err != nil {
+				// без данных о загрузке считаем по обычному тарифу
+				log.Printf("Не удалось получить загрузку диспетчерской: %v", err)
+			} else {
+				load = &l
+			}
+		}
+		resp, err := quoteDelivery(req, load)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.PromoCode != "" {
+			if err := applyPromo(req, &resp); err != nil {
+				if isPromoRejection(err) {
+					c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		convertQuote(&resp, quoteCurrency, rate)
+		if err := saveQuote(req, &resp, load); err != nil {
+			log.Printf("Ошибка сохранения расчёта в журнал: %v", err)
 		}
 		c.JSON(http.StatusOK, resp)
-		if req.OrderID != "" {
-			if err := publishDeliveryCalculatedEvent(req.OrderID, req.CourierID, cost); err != nil {
+		// Выручка без курьера аналитике не нужна (см. scopeCalculateRequest).
+		if req.OrderID != "" && req.CourierID != "" {
+			if err := publishDeliveryCalculatedEvent(req.OrderID, req.CourierID, auth.RecordTenant(auth.CurrentClaims(c), req.TenantID), resp); err != nil {
 				log.Printf("Ошибка публикации delivery_calculated: %v", err)
 			}
 		}
 	})
 
+	// GET /quotes?order_id= — журнал расчётов с применёнными коэффициентами.
+	r.GET("/quotes", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getQuotesHandler)
+
+	// Промокоды
+	r.GET("/promo-codes", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), getPromosHandler)
+	r.POST("/promo-codes", auth.Required(auth.RoleAdmin), createPromoHandler)
+	r.DELETE("/promo-codes/:code", auth.Required(auth.RoleAdmin), deactivatePromoHandler)
+	r.POST("/promo-codes/validate", auth.Required(), validatePromoHandler)
+
+	// Курсы валют
+	r.GET("/exchange-rates", auth.Required(), getExchangeRatesHandler)
+	r.PUT("/exchange-rates/:currency", auth.Required(auth.RoleAdmin), putExchangeRateHandler)
+
 	r.Run(":8080")
 }
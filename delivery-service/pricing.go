@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// DeliverySlot описывает временное окно доставки внутри дня и его ценовой коэффициент.
+type DeliverySlot struct {
+	Start  string  `json:"start"`        // "09:00"
+	End    string  `json:"end"`          // "11:00"
+	Factor float64 `json:"price_factor"` // множитель к базовой стоимости
+}
+
+// SlotOffer — конкретное окно на выбранную дату, которое можно передать в /calculate и в заказ.
+type SlotOffer struct {
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	PriceFactor float64   `json:"price_factor"`
+}
+
+// QuoteRecord — запись журнала расчётов.
+type QuoteRecord struct {
+	ID                int        `json:"id"`
+	OrderID           string     `json:"order_id"`
+	BaseCost          float64    `json:"base_cost"`
+	SlotFactor        float64    `json:"slot_factor"`
+	SurgeMultiplier   float64    `json:"surge_multiplier"`
+	OpenOrders        *int       `json:"open_orders"`
+	AvailableCouriers *int       `json:"available_couriers"`
+	WindowStart       *time.Time `json:"window_start,omitempty"`
+	WindowEnd         *time.Time `json:"window_end,omitempty"`
+	Discount          float64    `json:"discount"`
+	PromoCode         string     `json:"promo_code,omitempty"`
+	TotalCost         float64    `json:"total_cost"`
+	Currency          string     `json:"currency"`
+	ExchangeRate      float64    `json:"exchange_rate"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// DispatchLoad — текущая загрузка диспетчерской из order-service.
+type DispatchLoad struct {
+	OpenOrders        int `json:"open_orders"`
+	AvailableCouriers int `json:"available_couriers"`
+}
+
+const defaultSlots = "09:00-11:00=1.0;11:00-13:00=1.0;13:00-15:00=1.0;15:00-17:00=1.1;17:00-19:00=1.25;19:00-21:00=1.25"
+
+var (
+	deliverySlots []DeliverySlot
+	slotLocation  *time.Location
+
+	surgeEnabled     bool
+	surgeThreshold   float64 // отношение заказов к курьерам, после которого включается surge
+	surgeSensitivity float64 // прирост множителя на единицу превышения порога
+	surgeMax         float64 // верхняя граница множителя
+
+	orderServiceURL string
+	httpClient      = &http.Client{Timeout: 3 * time.Second}
+)
+
+// initPricingConfig читает окна доставки и параметры surge-тарифа из переменных окружения.
+func initPricingConfig() {
+	var err error
+	deliverySlots, err = parseSlots(getEnv("DELIVERY_SLOTS", defaultSlots))
+	if err != nil {
+		log.Printf("Некорректный DELIVERY_SLOTS (%v), используются окна по умолчанию", err)
+		deliverySlots, _ = parseSlots(defaultSlots)
+	}
+	slotLocation, err = time.LoadLocation(getEnv("SLOT_TIMEZONE", "Europe/Moscow"))
+	if err != nil {
+		slotLocation = time.UTC
+	}
+
+	surgeEnabled = getEnv("SURGE_ENABLED", "false") == "true"
+	surgeThreshold, err = strconv.ParseFloat(getEnv("SURGE_THRESHOLD", "1.5"), 64)
+	if err != nil {
+		surgeThreshold = 1.5
+	}
+	surgeSensitivity, err = strconv.ParseFloat(getEnv("SURGE_SENSITIVITY", "0.25"), 64)
+	if err != nil {
+		surgeSensitivity = 0.25
+	}
+	surgeMax, err = strconv.ParseFloat(getEnv("SURGE_MAX", "2.0"), 64)
+	if err != nil || surgeMax < 1 {
+		surgeMax = 2.0
+	}
+	orderServiceURL = os.Getenv("ORDER_SERVICE_URL")
+}
+
+// parseSlots разбирает строку вида "09:00-11:00=1.0;11:00-13:00=1.2".
+func parseSlots(spec string) ([]DeliverySlot, error) {
+	var slots []DeliverySlot
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		window, factorStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("нет коэффициента в %q", part)
+		}
+		start, end, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("некорректное окно %q", window)
+		}
+		startT, err1 := time.Parse("15:04", start)
+		endT, err2 := time.Parse("15:04", end)
+		if err1 != nil || err2 != nil || !endT.After(startT) {
+			return nil, fmt.Errorf("некорректное окно %q", window)
+		}
+		factor, err := strconv.ParseFloat(factorStr, 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("некорректный коэффициент %q", factorStr)
+		}
+		slots = append(slots, DeliverySlot{Start: start, End: end, Factor: factor})
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("не задано ни одного окна")
+	}
+	return slots, nil
+}
+
+// slotBounds возвращает начало и конец окна на указанную дату в часовом поясе окон.
+func slotBounds(slot DeliverySlot, date time.Time) (time.Time, time.Time) {
+	start, _ := time.Parse("15:04", slot.Start)
+	end, _ := time.Parse("15:04", slot.End)
+	y, m, d := date.In(slotLocation).Date()
+	return time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, slotLocation),
+		time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, slotLocation)
+}
+
+// slotsForDate перечисляет окна на дату, ещё не начавшиеся к моменту now.
+func slotsForDate(date, now time.Time) []SlotOffer {
+	offers := []SlotOffer{}
+	for _, slot := range deliverySlots {
+		start, end := slotBounds(slot, date)
+		if !start.After(now) {
+			continue
+		}
+		offers = append(offers, SlotOffer{WindowStart: start, WindowEnd: end, PriceFactor: slot.Factor})
+	}
+	return offers
+}
+
+// findSlot ищет настроенное окно, точно совпадающее с запрошенным.
+func findSlot(windowStart, windowEnd time.Time) (DeliverySlot, bool) {
+	for _, slot := range deliverySlots {
+		start, end := slotBounds(slot, windowStart)
+		if start.Equal(windowStart) && end.Equal(windowEnd) {
+			return slot, true
+		}
+	}
+	return DeliverySlot{}, false
+}
+
+// fetchDispatchLoad запрашивает у order-service число открытых заказов и свободных курьеров.
+func fetchDispatchLoad() (DispatchLoad, error) {
+	var load DispatchLoad
+	if orderServiceURL == "" {
+		return load, fmt.Errorf("ORDER_SERVICE_URL не задан")
+	}
+	req, err := http.NewRequest(http.MethodGet, orderServiceURL+"/dispatch/load", nil)
+	if err != nil {
+		return load, err
+	}
+	if authHeader := auth.ServiceAuthHeader(); authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return load, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return load, fmt.Errorf("order-service ответил %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&load)
+	return load, err
+}
+
+// surgeMultiplier вычисляет повышающий коэффициент по отношению открытых заказов к свободным курьерам.
+// Результат всегда в диапазоне [1, surgeMax].
+func surgeMultiplier(load DispatchLoad) float64 {
+	if load.OpenOrders == 0 {
+		return 1
+	}
+	if load.AvailableCouriers == 0 {
+		return surgeMax
+	}
+	ratio := float64(load.OpenOrders) / float64(load.AvailableCouriers)
+	if ratio <= surgeThreshold {
+		return 1
+	}
+	m := 1 + (ratio-surgeThreshold)*surgeSensitivity
+	return math.Min(math.Round(m*100)/100, surgeMax)
+}
+
+// quoteDelivery рассчитывает итоговую стоимость с учётом окна доставки и surge-коэффициента.
+func quoteDelivery(req DeliveryRequest, load *DispatchLoad) (DeliveryResponse, error) {
+	resp := DeliveryResponse{
+		BaseCost:        calculateDeliveryCost(req),
+		SlotFactor:      1,
+		SurgeMultiplier: 1,
+		Currency:        currency,
+	}
+	if req.WindowStart != nil || req.WindowEnd != nil {
+		if req.WindowStart == nil || req.WindowEnd == nil {
+			return resp, fmt.Errorf("окно доставки должно содержать начало и конец")
+		}
+		slot, ok := findSlot(*req.WindowStart, *req.WindowEnd)
+		if !ok {
+			return resp, fmt.Errorf("окно доставки недоступно")
+		}
+		resp.SlotFactor = slot.Factor
+		resp.WindowStart = req.WindowStart
+		resp.WindowEnd = req.WindowEnd
+	}
+	if load != nil {
+		resp.SurgeMultiplier = surgeMultiplier(*load)
+	}
+	resp.EstimatedCost = math.Round(resp.BaseCost*resp.SlotFactor*resp.SurgeMultiplier*100) / 100
+	return resp, nil
+}
+
+// saveQuote записывает в журнал, какие коэффициенты и скидки применены к расчёту.
+func saveQuote(req DeliveryRequest, resp *DeliveryResponse, load *DispatchLoad) error {
+	var openOrders, availableCouriers *int
+	if load != nil {
+		openOrders, availableCouriers = &load.OpenOrders, &load.AvailableCouriers
+	}
+	return db.QueryRow(`
+		INSERT INTO price_quotes
+			(order_id, base_cost, slot_factor, surge_multiplier, open_orders, available_couriers,
+			 window_start, window_end, discount, promo_code, total_cost, currency, exchange_rate, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW())
+		RETURNING id
+	`, req.OrderID, resp.BaseCost, resp.SlotFactor, resp.SurgeMultiplier, openOrders, availableCouriers,
+		resp.WindowStart, resp.WindowEnd, resp.Discount, resp.PromoCode, resp.EstimatedCost, resp.Currency, resp.ExchangeRate).Scan(&resp.QuoteID)
+}
+
+// getQuotesHandler возвращает журнал расчётов, опционально по одному заказу.
+func getQuotesHandler(c *gin.Context) {
+	query := `
+		SELECT id, COALESCE(order_id, ''), base_cost, slot_factor, surge_multiplier, open_orders, available_couriers,
+			window_start, window_end, discount, COALESCE(promo_code, ''), total_cost, currency, exchange_rate, created_at
+		FROM price_quotes`
+	var args []any
+	if orderID := c.Query("order_id"); orderID != "" {
+		query += " WHERE order_id = $1"
+		args = append(args, orderID)
+	}
+	query += " ORDER BY created_at DESC LIMIT 200"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	list := []QuoteRecord{}
+	for rows.Next() {
+		var q QuoteRecord
+		if err := rows.Scan(&q.ID, &q.OrderID, &q.BaseCost, &q.SlotFactor, &q.SurgeMultiplier, &q.OpenOrders, &q.AvailableCouriers,
+			&q.WindowStart, &q.WindowEnd, &q.Discount, &q.PromoCode, &q.TotalCost, &q.Currency, &q.ExchangeRate, &q.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, q)
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSlots(t *testing.T) {
+	slots, err := parseSlots("09:00-11:00=1.0; 18:00-20:00=1.3")
+	assert.NoError(t, err)
+	assert.Len(t, slots, 2)
+	assert.Equal(t, "18:00", slots[1].Start)
+	assert.InDelta(t, 1.3, slots[1].Factor, 0.0001)
+
+	_, err = parseSlots("11:00-09:00=1.0")
+	assert.Error(t, err)
+	_, err = parseSlots("09:00-11:00")
+	assert.Error(t, err)
+	_, err = parseSlots("")
+	assert.Error(t, err)
+}
+
+func TestSurgeMultiplierCaps(t *testing.T) {
+	initConfig()
+	initPricingConfig()
+	surgeThreshold, surgeSensitivity, surgeMax = 1.5, 0.25, 2.0
+
+	assert.Equal(t, 1.0, surgeMultiplier(DispatchLoad{OpenOrders: 0, AvailableCouriers: 0}))
+	assert.Equal(t, 1.0, surgeMultiplier(DispatchLoad{OpenOrders: 3, AvailableCouriers: 2}))
+	assert.InDelta(t, 1.125, surgeMultiplier(DispatchLoad{OpenOrders: 4, AvailableCouriers: 2}), 0.01)
+	assert.Equal(t, 2.0, surgeMultiplier(DispatchLoad{OpenOrders: 100, AvailableCouriers: 1}))
+	assert.Equal(t, 2.0, surgeMultiplier(DispatchLoad{OpenOrders: 5, AvailableCouriers: 0}))
+}
+
+func TestQuoteDeliveryWithSlotAndSurge(t *testing.T) {
+	initConfig()
+	initPricingConfig()
+	deliverySlots, _ = parseSlots("09:00-11:00=1.0;18:00-20:00=1.5")
+	surgeThreshold, surgeSensitivity, surgeMax = 1.0, 0.5, 2.0
+
+	req := DeliveryRequest{FromLat: 55.75, FromLng: 37.62, ToLat: 55.80, ToLng: 37.70, Weight: 1, Urgency: 1}
+	plain, err := quoteDelivery(req, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, plain.SlotFactor)
+	assert.Equal(t, 1.0, plain.SurgeMultiplier)
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	offers := slotsForDate(tomorrow, time.Now())
+	assert.Len(t, offers, 2)
+	req.WindowStart, req.WindowEnd = &offers[1].WindowStart, &offers[1].WindowEnd
+
+	load := DispatchLoad{OpenOrders: 4, AvailableCouriers: 2}
+	quoted, err := quoteDelivery(req, &load)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, quoted.SlotFactor)
+	assert.Equal(t, 1.5, quoted.SurgeMultiplier)
+	assert.InDelta(t, plain.BaseCost*1.5*1.5, quoted.EstimatedCost, 0.01)
+
+	shifted := offers[1].WindowStart.Add(30 * time.Minute)
+	req.WindowStart = &shifted
+	_, err = quoteDelivery(req, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// PromoCode описывает промокод и ограничения на его использование.
+type PromoCode struct {
+	Code             string     `json:"code"`
+	DiscountType     string     `json:"discount_type"`  // "percent" или "fixed"
+	DiscountValue    float64    `json:"discount_value"` // проценты или сумма в валюте тарифа
+	MinOrderValue    float64    `json:"min_order_value"`
+	MaxUses          int        `json:"max_uses"`           // 0 — без ограничения
+	PerCustomerLimit int        `json:"per_customer_limit"` // 0 — без ограничения
+	UsedCount        int        `json:"used_count"`
+	ValidFrom        *time.Time `json:"valid_from,omitempty"`
+	ValidTo          *time.Time `json:"valid_to,omitempty"`
+	Active           bool       `json:"active"`
+}
+
+// PromoValidationRequest — запрос на проверку промокода без его применения.
+type PromoValidationRequest struct {
+	Code       string  `json:"code"`
+	Customer   string  `json:"customer"`    // учитывается только от order-service, иначе — пользователь из токена
+	OrderValue float64 `json:"order_value"` // 0 — стоимость ещё неизвестна, минимальная сумма не проверяется
+}
+
+var (
+	errPromoNotFound = errors.New("промокод не найден")
+	errPromoInactive = errors.New("промокод не действует")
+	errPromoExpired  = errors.New("срок действия промокода истёк")
+	errPromoExceeded = errors.New("лимит использований промокода исчерпан")
+	errPromoCustomer = errors.New("лимит использований промокода для клиента исчерпан")
+	errPromoMinValue = errors.New("сумма заказа меньше минимальной для промокода")
+)
+
+// validatePromo проверяет период действия, лимиты и минимальную сумму.
+// customerUses — сколько раз клиент уже использовал код.
+func validatePromo(p PromoCode, orderValue float64, customerUses int, now time.Time) error {
+	if !p.Active {
+		return errPromoInactive
+	}
+	if p.ValidFrom != nil && now.Before(*p.ValidFrom) {
+		return errPromoInactive
+	}
+	if p.ValidTo != nil && now.After(*p.ValidTo) {
+		return errPromoExpired
+	}
+	if p.MaxUses > 0 && p.UsedCount >= p.MaxUses {
+		return errPromoExceeded
+	}
+	if p.PerCustomerLimit > 0 && customerUses >= p.PerCustomerLimit {
+		return errPromoCustomer
+	}
+	if orderValue > 0 && orderValue < p.MinOrderValue {
+		return errPromoMinValue
+	}
+	return nil
+}
+
+// promoDiscount считает скидку; она никогда не превышает стоимость заказа.
+func promoDiscount(p PromoCode, orderValue float64) float64 {
+	var discount float64
+	switch p.DiscountType {
+	case "percent":
+		discount = orderValue * p.DiscountValue / 100
+	case "fixed":
+		discount = p.DiscountValue
+	}
+	discount = math.Min(discount, orderValue)
+	return math.Round(discount*100) / 100
+}
+
+// normalizePromoCode приводит код к каноническому виду, в котором он хранится в БД.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// queryer покрывает *sql.DB и *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// loadPromo читает промокод и число его использований клиентом.
+// Суффикс позволяет заблокировать строку (FOR UPDATE) внутри транзакции.
+func loadPromo(q queryer, code, customer, suffix string) (PromoCode, int, error) {
+	var p PromoCode
+	err := q.QueryRow(`
+		SELECT code, discount_type, discount_value, min_order_value, max_uses, per_customer_limit,
+			used_count, valid_from, valid_to, active
+		FROM promo_codes WHERE code = $1 `+suffix, code).Scan(
+		&p.Code, &p.DiscountType, &p.DiscountValue, &p.MinOrderValue, &p.MaxUses, &p.PerCustomerLimit,
+		&p.UsedCount, &p.ValidFrom, &p.ValidTo, &p.Active)
+	if err == sql.ErrNoRows {
+		return p, 0, errPromoNotFound
+	}
+	if err != nil {
+		return p, 0, err
+	}
+	var customerUses int
+	if customer != "" {
+		err = q.QueryRow(`SELECT COUNT(*) FROM promo_redemptions WHERE code = $1 AND customer = $2`,
+			code, customer).Scan(&customerUses)
+		if err != nil {
+			return p, 0, err
+		}
+	}
+	return p, customerUses, nil
+}
+
+// scopeCalculateRequest оставляет в запросе расчёта только проверенные данные о клиенте и заказе.
+// Их передаёт order-service (auth.RoleService) по сохранённому заказу и его автору. Для остальных ролей клиент —
+// пользователь из токена, а расчёт не привязывается к заказу и курьеру: не расходует промокод и не публикует
+// delivery_calculated в аналитику. Цену заказа order-service запрашивает до назначения курьера, поэтому
+// выручку курьера после вручения публикует он сам.
+func scopeCalculateRequest(claims *auth.Claims, req *DeliveryRequest) {
+	if claims.Role == auth.RoleService {
+		return
+	}
+	req.Customer = claims.Subject
+	req.OrderID, req.CourierID = "", ""
+}
+
+// applyPromo проверяет промокод и уменьшает стоимость в resp.
+// Если указан заказ, использование фиксируется в promo_redemptions; повторный расчёт того же заказа
+// не расходует лимит повторно.
+func applyPromo(req DeliveryRequest, resp *DeliveryResponse) error {
+	code := normalizePromoCode(req.PromoCode)
+	if req.OrderID == "" {
+		p, customerUses, err := loadPromo(db, code, req.Customer, "")
+		if err != nil {
+			return err
+		}
+		if err := validatePromo(p, resp.EstimatedCost, customerUses, time.Now()); err != nil {
+			return err
+		}
+		setDiscount(resp, p)
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, customerUses, err := loadPromo(tx, code, req.Customer, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	var already bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM promo_redemptions WHERE code = $1 AND order_id = $2)`,
+		code, req.OrderID).Scan(&already)
+	if err != nil {
+		return err
+	}
+	if !already {
+		if err := validatePromo(p, resp.EstimatedCost, customerUses, time.Now()); err != nil {
+			return err
+		}
+	}
+	setDiscount(resp, p)
+
+	if already {
+		_, err = tx.Exec(`UPDATE promo_redemptions SET discount = $1 WHERE code = $2 AND order_id = $3`,
+			resp.Discount, code, req.OrderID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO promo_redemptions (code, order_id, customer, discount, created_at)
+			VALUES ($1, $2, $3, $4, NOW())
+		`, code, req.OrderID, req.Customer, resp.Discount)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE promo_codes SET used_count = used_count + 1 WHERE code = $1`, code)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setDiscount вычитает скидку по промокоду из итоговой стоимости.
+func setDiscount(resp *DeliveryResponse, p PromoCode) {
+	resp.PromoCode = p.Code
+	resp.Discount = promoDiscount(p, resp.EstimatedCost)
+	resp.EstimatedCost = math.Round((resp.EstimatedCost-resp.Discount)*100) / 100
+}
+
+// isPromoRejection отличает отказ по правилам промокода от внутренней ошибки.
+func isPromoRejection(err error) bool {
+	switch err {
+	case errPromoNotFound, errPromoInactive, errPromoExpired, errPromoExceeded, errPromoCustomer, errPromoMinValue:
+		return true
+	}
+	return false
+}
+
+// createPromoHandler создаёт новый промокод.
+func createPromoHandler(c *gin.Context) {
+	var p PromoCode
+	if err := c.BindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	p.Code = normalizePromoCode(p.Code)
+	if p.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан код"})
+		return
+	}
+	switch {
+	case p.DiscountType == "percent" && (p.DiscountValue <= 0 || p.DiscountValue > 100),
+		p.DiscountType == "fixed" && p.DiscountValue <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный размер скидки"})
+		return
+	case p.DiscountType != "percent" && p.DiscountType != "fixed":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "discount_type должен быть percent или fixed"})
+		return
+	}
+	if p.ValidFrom != nil && p.ValidTo != nil && !p.ValidTo.After(*p.ValidFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid_to должен быть позже valid_from"})
+		return
+	}
+	p.Active = true
+	p.UsedCount = 0
+	_, err := db.Exec(`
+		INSERT INTO promo_codes
+			(code, discount_type, discount_value, min_order_value, max_uses, per_customer_limit, valid_from, valid_to, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE)
+	`, p.Code, p.DiscountType, p.DiscountValue, p.MinOrderValue, p.MaxUses, p.PerCustomerLimit, p.ValidFrom, p.ValidTo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+// getPromosHandler возвращает список промокодов.
+func getPromosHandler(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT code, discount_type, discount_value, min_order_value, max_uses, per_customer_limit,
+			used_count, valid_from, valid_to, active
+		FROM promo_codes ORDER BY code`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	list := []PromoCode{}
+	for rows.Next() {
+		var p PromoCode
+		if err := rows.Scan(&p.Code, &p.DiscountType, &p.DiscountValue, &p.MinOrderValue, &p.MaxUses, &p.PerCustomerLimit,
+			&p.UsedCount, &p.ValidFrom, &p.ValidTo, &p.Active); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, p)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// deactivatePromoHandler отключает промокод; история использований сохраняется.
+func deactivatePromoHandler(c *gin.Context) {
+	res, err := db.Exec(`UPDATE promo_codes SET active = FALSE WHERE code = $1`, normalizePromoCode(c.Param("code")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errPromoNotFound.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// validatePromoHandler проверяет промокод без его использования (например, при создании заказа).
+func validatePromoHandler(c *gin.Context) {
+	var req PromoValidationRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	// клиента для лимита можно указать только от имени order-service, остальные проверяют код для себя
+	if claims := auth.CurrentClaims(c); claims.Role != auth.RoleService {
+		req.Customer = claims.Subject
+	}
+	p, customerUses, err := loadPromo(db, normalizePromoCode(req.Code), req.Customer, "")
+	if err == nil {
+		err = validatePromo(p, req.OrderValue, customerUses, time.Now())
+	}
+	if err != nil {
+		if isPromoRejection(err) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{"valid": true, "code": p.Code, "discount_type": p.DiscountType, "discount_value": p.DiscountValue}
+	if req.OrderValue > 0 {
+		resp["discount"] = promoDiscount(p, req.OrderValue)
+	}
+	c.JSON(http.StatusOK, resp)
+}
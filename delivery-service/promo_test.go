@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestPromoDiscount(t *testing.T) {
+	percent := PromoCode{Code: "SALE10", DiscountType: "percent", DiscountValue: 10}
+	fixed := PromoCode{Code: "MINUS500", DiscountType: "fixed", DiscountValue: 500}
+
+	assert.InDelta(t, 100, promoDiscount(percent, 1000), 0.001)
+	assert.InDelta(t, 500, promoDiscount(fixed, 1000), 0.001)
+	// скидка не может быть больше стоимости
+	assert.InDelta(t, 300, promoDiscount(fixed, 300), 0.001)
+}
+
+func TestValidatePromo(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	base := PromoCode{Code: "B2B", DiscountType: "percent", DiscountValue: 15, Active: true}
+
+	assert.NoError(t, validatePromo(base, 1000, 0, now))
+
+	inactive := base
+	inactive.Active = false
+	assert.Equal(t, errPromoInactive, validatePromo(inactive, 1000, 0, now))
+
+	notStarted := base
+	notStarted.ValidFrom = &future
+	assert.Equal(t, errPromoInactive, validatePromo(notStarted, 1000, 0, now))
+
+	expired := base
+	expired.ValidTo = &past
+	assert.Equal(t, errPromoExpired, validatePromo(expired, 1000, 0, now))
+
+	exhausted := base
+	exhausted.MaxUses, exhausted.UsedCount = 5, 5
+	assert.Equal(t, errPromoExceeded, validatePromo(exhausted, 1000, 0, now))
+
+	perCustomer := base
+	perCustomer.PerCustomerLimit = 1
+	assert.Equal(t, errPromoCustomer, validatePromo(perCustomer, 1000, 1, now))
+
+	minValue := base
+	minValue.MinOrderValue = 2000
+	assert.Equal(t, errPromoMinValue, validatePromo(minValue, 1000, 0, now))
+	// стоимость ещё неизвестна — минимальная сумма не проверяется
+	assert.NoError(t, validatePromo(minValue, 0, 0, now))
+}
+
+func TestSetDiscount(t *testing.T) {
+	resp := DeliveryResponse{EstimatedCost: 1200}
+	setDiscount(&resp, PromoCode{Code: "SALE10", DiscountType: "percent", DiscountValue: 10})
+
+	assert.Equal(t, "SALE10", resp.PromoCode)
+	assert.InDelta(t, 120, resp.Discount, 0.001)
+	assert.InDelta(t, 1080, resp.EstimatedCost, 0.001)
+}
+
+func TestScopeCalculateRequest(t *testing.T) {
+	customer := &auth.Claims{Role: auth.RoleCustomer}
+	customer.Subject = "anna"
+	req := DeliveryRequest{OrderID: "made-up", CourierID: "c-1", Customer: "someone-else", PromoCode: "SALE10"}
+	scopeCalculateRequest(customer, &req)
+	assert.Equal(t, "anna", req.Customer, "лимит на клиента — по пользователю из токена")
+	assert.Empty(t, req.OrderID, "клиент не расходует промокод и не пишет в аналитику чужими номерами заказов")
+	assert.Empty(t, req.CourierID)
+
+	req = DeliveryRequest{OrderID: "o-1", Customer: "anna"}
+	scopeCalculateRequest(&auth.Claims{Role: auth.RoleService}, &req)
+	assert.Equal(t, DeliveryRequest{OrderID: "o-1", Customer: "anna"}, req)
+}
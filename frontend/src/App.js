@@ -1,6 +1,7 @@
 // src/App.js
 
-import React, { useState } from "react";
+import React, { useCallback, useEffect, useState } from "react";
+import API_URLS from "./config";
 import LoginForm from "./components/LoginForm";
 import Dashboard from "./components/Dashboard";
 import "./App.css";
@@ -27,15 +28,51 @@ const theme = createTheme({
 function App() {
   const [token, setToken] = useState(() => localStorage.getItem("token") || "");
 
-  const handleLogin = (newToken) => {
-    localStorage.setItem("token", newToken);
-    setToken(newToken);
-  };
+  // data — ответ /login или /token/refresh: { token, refresh_token, expires_in }
+  const handleLogin = useCallback((data) => {
+    localStorage.setItem("token", data.token);
+    localStorage.setItem("refreshToken", data.refresh_token || "");
+    localStorage.setItem("expiresIn", String(data.expires_in || 900));
+    setToken(data.token);
+  }, []);
 
-  const handleLogout = () => {
+  const handleLogout = useCallback(() => {
+    const refreshToken = localStorage.getItem("refreshToken");
+    if (token) {
+      fetch(`${API_URLS.auth}/logout`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ refresh_token: refreshToken || "" }),
+      }).catch(() => {});
+    }
     localStorage.removeItem("token");
+    localStorage.removeItem("refreshToken");
+    localStorage.removeItem("expiresIn");
     setToken("");
-  };
+  }, [token]);
+
+  // Продлеваем access-токен за минуту до истечения, чтобы не выкидывать диспетчера посреди смены.
+  useEffect(() => {
+    const refreshToken = localStorage.getItem("refreshToken");
+    if (!token || !refreshToken) return;
+    const expiresIn = Number(localStorage.getItem("expiresIn")) || 900;
+    const timer = setTimeout(async () => {
+      try {
+        const res = await fetch(`${API_URLS.auth}/token/refresh`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (!res.ok) throw new Error("refresh failed");
+        handleLogin(await res.json());
+      } catch {
+        localStorage.removeItem("token");
+        localStorage.removeItem("refreshToken");
+        setToken("");
+      }
+    }, Math.max(expiresIn - 60, 10) * 1000);
+    return () => clearTimeout(timer);
+  }, [token, handleLogin]);
 
   return (
     <ThemeProvider theme={theme}>
@@ -23,6 +23,17 @@ export default function CourierStatsTable({ couriers }) {
         </Typography>
       )
     },
+    {
+      // выручка в валютах без курса к валюте отчётности — в «Выручку» не входит
+      field: "unconverted",
+      headerName: "Без пересчёта",
+      width: 200,
+      sortable: false,
+      renderCell: (params) =>
+        (params.value || [])
+          .map((u) => `${u.revenue.toFixed(2)} ${u.currency}`)
+          .join("; ")
+    },
     {
       field: "average_delivery_time_sec",
       headerName: "Сред. время (мин)",
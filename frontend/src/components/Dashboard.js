@@ -249,6 +249,7 @@ export default function Dashboard({ token, onLogout }) {
                 couriers={couriers}
                 onDeleteOrder={handleDeleteOrder}
                 onAssignCourier={handleAssignCourier}
+                token={token}
               />
             </>
           } />
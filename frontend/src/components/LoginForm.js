@@ -32,7 +32,7 @@ const LoginForm = ({ onLogin }) => {
       }
 
       const data = await response.json();
-      onLogin(data.token);
+      onLogin(data);
     } catch (err) {
       setError("Ошибка соединения");
     }
@@ -10,12 +10,31 @@ import {
   Box
 } from "@mui/material";
 import DeleteIcon from "@mui/icons-material/Delete";
+import API_URLS from "../config";
+
+// Отчёт скачивается запросом с заголовком Authorization: токен в ссылке попал бы в журналы и историю браузера.
+async function downloadReport(orderId, token) {
+  const res = await fetch(`${API_URLS.orders}/orders/${encodeURIComponent(orderId)}/report`, {
+    headers: { Authorization: `Bearer ${token}` }
+  });
+  if (!res.ok) {
+    alert("Не удалось получить отчёт");
+    return;
+  }
+  const url = URL.createObjectURL(await res.blob());
+  const link = document.createElement("a");
+  link.href = url;
+  link.download = `report_${orderId}.pdf`;
+  link.click();
+  setTimeout(() => URL.revokeObjectURL(url), 1000);
+}
 
 export default function OrdersTable({
   orders,
   couriers,
   onDeleteOrder,
-  onAssignCourier
+  onAssignCourier,
+  token
 }) {
   const columns = [
     { field: "id", headerName: "ID", width: 120 },
@@ -103,9 +122,11 @@ export default function OrdersTable({
       filterable: false,
       renderCell: (params) => (
         <a
-          href={`http://localhost:8082/orders/${params.row.id}/report`}
-          target="_blank"
-          rel="noopener noreferrer"
+          href="#"
+          onClick={(e) => {
+            e.preventDefault();
+            downloadReport(params.row.id, token);
+          }}
         >
           PDF отчёт
         </a>
@@ -6,3 +6,8 @@ CREATE TABLE IF NOT EXISTS notifications (
     status VARCHAR(50) DEFAULT 'pending',
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
+
+-- Организация (tenant), от имени которой отправлено уведомление
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_notifications_tenant ON notifications (tenant_id);
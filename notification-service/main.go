@@ -12,13 +12,15 @@ import (
 	"net/smtp"
 
 	"github.com/gin-gonic/gin"
-	"github.com/streadway/amqp"
 	_ "github.com/lib/pq"
+	"github.com/streadway/amqp"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 // Notification описывает уведомление.
 type Notification struct {
 	ID        int       `json:"id"`
+	TenantID  string    `json:"tenant_id"` // организация, от имени которой отправлено уведомление
 	Type      string    `json:"type"`      // Тип уведомления (например, "order_completed")
 	Recipient string    `json:"recipient"` // Email или телефон получателя
 	Message   string    `json:"message"`   // Текст уведомления
@@ -31,8 +33,18 @@ type NotificationMessage struct {
 	Type      string `json:"type"`
 	Recipient string `json:"recipient"`
 	Message   string `json:"message"`
+	Subject   string `json:"subject,omitempty"` // тема письма; по умолчанию defaultSubject
+	TenantID  string `json:"tenant_id,omitempty"` // пусто в событиях старых версий сервисов — auth.DefaultTenant
+	// Sensitive — письмо содержит секрет (ссылку сброса пароля и т.п.):
+	// отправляется как есть, но в журнал уведомлений текст не сохраняется
+	Sensitive bool `json:"sensitive,omitempty"`
 }
 
+const (
+	defaultSubject  = "Уведомление от службы доставки"
+	redactedMessage = "[текст скрыт: содержит одноразовую ссылку]"
+)
+
 var db *sql.DB
 var sendEmailFunc = sendEmail
 
@@ -127,8 +139,8 @@ func sendEmail(to, subject, body string) error {
 		"MIME-version: 1.0;\r\nContent-Type: text/plain; charset=\"UTF-8\";\r\n\r\n" +
 		body + "\r\n")
 
-	auth := smtp.PlainAuth("", from, password, host)
-	return smtp.SendMail(addr, auth, from, []string{to}, msg)
+	smtpAuth := smtp.PlainAuth("", from, password, host)
+	return smtp.SendMail(addr, smtpAuth, from, []string{to}, msg)
 }
 // processNotification сохраняет уведомление в БД и имитирует отправку уведомления.
 func processNotification(msg NotificationMessage) error {
@@ -139,17 +151,28 @@ func processNotification(msg NotificationMessage) error {
 		Message:   msg.Message,
 		Status:    "pending",
 		CreatedAt: time.Now(),
+		TenantID:  msg.TenantID,
+	}
+	if n.TenantID == "" {
+		n.TenantID = auth.DefaultTenant
+	}
+	if msg.Sensitive {
+		n.Message = redactedMessage
+	}
+	subject := msg.Subject
+	if subject == "" {
+		subject = defaultSubject
 	}
 	query := `
-			INSERT INTO notifications (type, recipient, message, status, created_at)
-			VALUES ($1, $2, $3, $4, $5)
+			INSERT INTO notifications (type, recipient, message, status, created_at, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
 			RETURNING id
 		`
-	err := db.QueryRow(query, n.Type, n.Recipient, n.Message, n.Status, n.CreatedAt).Scan(&n.ID)
+	err := db.QueryRow(query, n.Type, n.Recipient, n.Message, n.Status, n.CreatedAt, n.TenantID).Scan(&n.ID)
 	if err != nil {
 		return err
 	}
-	err = sendEmailFunc(n.Recipient, "Уведомление от службы доставки", n.Message)
+	err = sendEmailFunc(n.Recipient, subject, msg.Message)
 	if err != nil {
 		log.Printf("Ошибка отправки письма: %v", err)
 		_, _ = db.Exec("UPDATE notifications SET status = $1 WHERE id = $2", "failed", n.ID)
@@ -185,6 +208,9 @@ func main() {
 	// Запускаем получение уведомлений из RabbitMQ в фоне
 	startConsumer()
 
+	// Список отозванных токенов подтягивается из auth-service (AUTH_URL).
+	auth.StartRevocationSync()
+
 	// Инициализируем HTTP API Notification Service.
 	r := gin.Default()
 	// Эндпоинт для проверки работоспособности (health-check)
@@ -212,9 +238,10 @@ func main() {
 		})
 	})
 
-	// Эндпоинт для получения списка уведомлений
-	r.GET("/notifications", func(c *gin.Context) {
-		rows, err := db.Query("SELECT id, type, recipient, message, status, created_at FROM notifications ORDER BY created_at DESC")
+	// Эндпоинт для получения списка уведомлений своей организации (администратору платформы — всех или ?tenant_id=)
+	r.GET("/notifications", auth.Required(auth.RoleAdmin, auth.RoleDispatcher), func(c *gin.Context) {
+		where, args := auth.TenantScope(c, 1)
+		rows, err := db.Query("SELECT id, tenant_id, type, recipient, message, status, created_at FROM notifications WHERE "+where+" ORDER BY created_at DESC", args...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -223,7 +250,7 @@ func main() {
 		var notifications []Notification
 		for rows.Next() {
 			var n Notification
-			if err := rows.Scan(&n.ID, &n.Type, &n.Recipient, &n.Message, &n.Status, &n.CreatedAt); err != nil {
+			if err := rows.Scan(&n.ID, &n.TenantID, &n.Type, &n.Recipient, &n.Message, &n.Status, &n.CreatedAt); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
@@ -232,7 +259,7 @@ func main() {
 		c.JSON(http.StatusOK, notifications)
 	})
 	// Эндпоинт для получения уведомления по ID
-	r.GET("/notifications/:id", func(c *gin.Context) {
+	r.GET("/notifications/:id", auth.Required(auth.RoleAdmin, auth.RoleDispatcher), func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -240,8 +267,9 @@ func main() {
 			return
 		}
 		var n Notification
-		query := "SELECT id, type, recipient, message, status, created_at FROM notifications WHERE id = $1"
-		err = db.QueryRow(query, id).Scan(&n.ID, &n.Type, &n.Recipient, &n.Message, &n.Status, &n.CreatedAt)
+		where, args := auth.TenantScope(c, 2)
+		query := "SELECT id, tenant_id, type, recipient, message, status, created_at FROM notifications WHERE id = $1 AND " + where
+		err = db.QueryRow(query, append([]any{id}, args...)...).Scan(&n.ID, &n.TenantID, &n.Type, &n.Recipient, &n.Message, &n.Status, &n.CreatedAt)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Уведомление не найдено"})
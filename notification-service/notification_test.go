@@ -12,6 +12,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 // Заглушка для email-функции
@@ -41,7 +42,7 @@ func TestProcessNotification_Success(t *testing.T) {
 
 	// Мокаем SQL
 	mock.ExpectQuery("INSERT INTO notifications .* RETURNING id").
-		WithArgs(msg.Type, msg.Recipient, msg.Message, "pending", sqlmock.AnyArg()).
+		WithArgs(msg.Type, msg.Recipient, msg.Message, "pending", sqlmock.AnyArg(), auth.DefaultTenant).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectExec("UPDATE notifications SET status = .*").
 		WithArgs("sent", 1).
@@ -78,6 +79,40 @@ func TestProcessNotification_EmailFailure(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestProcessNotification_SensitiveIsRedacted(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	var sentSubject, sentBody string
+	sendEmailFunc = func(to, subject, body string) error {
+		sentSubject, sentBody = subject, body
+		return nil
+	}
+	defer func() { sendEmailFunc = fakeSendEmail }()
+
+	msg := NotificationMessage{
+		Type:      "password_reset",
+		Recipient: "user@example.com",
+		Subject:   "Сброс пароля",
+		Message:   "Код для сброса пароля: secret",
+		Sensitive: true,
+		TenantID:  "shop-1",
+	}
+
+	mock.ExpectQuery("INSERT INTO notifications .* RETURNING id").
+		WithArgs(msg.Type, msg.Recipient, redactedMessage, "pending", sqlmock.AnyArg(), "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec("UPDATE notifications SET status = .*").
+		WithArgs("sent", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, processNotification(msg))
+	assert.Equal(t, "Сброс пароля", sentSubject)
+	assert.Contains(t, sentBody, "secret")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func setupRouterWithMockDB(mockDB *sql.DB) *gin.Engine {
 	db = mockDB
 	gin.SetMode(gin.TestMode)
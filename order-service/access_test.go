@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestOrderScope(t *testing.T) {
+	customer := &auth.Claims{Role: auth.RoleCustomer, StandardClaims: jwt.StandardClaims{Subject: "anna"}}
+	where, args := orderScope(customer, 1)
+	assert.Equal(t, "created_by = $1", where)
+	assert.Equal(t, []any{"anna"}, args)
+
+	courier := &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1"}
+	where, args = orderScope(courier, 3)
+	assert.Equal(t, "courier_id = $3", where)
+	assert.Equal(t, []any{"c-1"}, args)
+
+	where, args = orderScope(&auth.Claims{Role: auth.RoleDispatcher}, 1)
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+}
+
+func TestCanAccessOrder(t *testing.T) {
+	o := Order{ID: "1", CreatedBy: "anna", CourierID: "c-1", TenantID: "shop-1"}
+
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleCustomer, TenantID: "shop-1", StandardClaims: jwt.StandardClaims{Subject: "anna"}}, o))
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleCustomer, TenantID: "shop-1", StandardClaims: jwt.StandardClaims{Subject: "boris"}}, o))
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"}, o))
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-2", TenantID: "shop-1"}, o))
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleCourier, TenantID: "shop-1"}, Order{ID: "2", TenantID: "shop-1"}))
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleAnalyst, TenantID: "shop-1"}, o))
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleService}, o), "сервису доступен тот же заказ, что и в GET /orders")
+	assert.False(t, canAccessOrder(&auth.Claims{Role: "unknown", TenantID: "shop-1"}, o))
+
+	// чужая организация
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-2"}, o))
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleCustomer, TenantID: "shop-2", StandardClaims: jwt.StandardClaims{Subject: "anna"}}, o))
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleAdmin}, o), "администратор платформы видит все организации")
+
+	assert.True(t, canAccessOrder(&auth.Claims{Role: auth.RoleAPIClient, TenantID: "shop-1"}, o))
+	assert.False(t, canAccessOrder(&auth.Claims{Role: auth.RoleAPIClient, TenantID: "shop-2"}, o))
+}
+
+func TestFindAccessibleOrderHidesForeignOrders(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	// чужой заказ неотличим от несуществующего
+	expectTestOrder(mock, "o-1", "shop-2")
+	_, status, err := findAccessibleOrder(&auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}, "o-1")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.EqualError(t, err, "Заказ не найден")
+
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs("o-2").WillReturnError(sql.ErrNoRows)
+	_, status, err = findAccessibleOrder(&auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}, "o-2")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.EqualError(t, err, "Заказ не найден")
+
+	// заказ своей организации, но не свой для курьера
+	expectTestOrder(mock, "o-1", "shop-1")
+	_, status, _ = findAccessibleOrder(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-2", TenantID: "shop-1"}, "o-1")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	expectTestOrder(mock, "o-1", "shop-1")
+	o, _, err := findAccessibleOrder(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"}, "o-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "o-1", o.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
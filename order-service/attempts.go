@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Причины неудачной попытки доставки.
+const (
+	reasonNotHome      = "not_home"
+	reasonWrongAddress = "wrong_address"
+	reasonRefused      = "refused"
+	reasonDamaged      = "damaged"
+)
+
+// failureReasons — описание причины для писем и истории; retry — имеет ли смысл повторная попытка.
+// При отказе получателя или повреждении посылки заказ сразу возвращается отправителю.
+var failureReasons = map[string]struct {
+	label string
+	retry bool
+}{
+	reasonNotHome:      {"получателя не было дома", true},
+	reasonWrongAddress: {"неверный адрес", true},
+	reasonRefused:      {"получатель отказался от заказа", false},
+	reasonDamaged:      {"посылка повреждена", false},
+}
+
+// Исход неудачной попытки.
+const (
+	outcomeRescheduled = "rescheduled"
+	outcomeReturned    = "returned"
+)
+
+// statusReturned — исходный заказ закрыт возвратом отправителю; доставку продолжает возвратный заказ.
+const statusReturned = "возврат"
+
+var (
+	// maxDeliveryAttempts — после стольких неудачных попыток заказ возвращается отправителю (env DELIVERY_MAX_ATTEMPTS).
+	maxDeliveryAttempts = 3
+	// rescheduleAfter — на сколько переносится доставка после неудачной попытки (env DELIVERY_RESCHEDULE_AFTER).
+	rescheduleAfter = 24 * time.Hour
+)
+
+// History-события попыток доставки.
+const (
+	historyAttemptFailed = "attempt_failed"
+	historyRescheduled   = "rescheduled"
+	historyReturned      = "returned"
+)
+
+// publishDeliveryFailed публикует событие order.delivery_failed для аналитики; переменная — для подмены в тестах.
+var publishDeliveryFailed = publishEventToQueue
+
+// initAttemptsConfig читает настройки повторных попыток из переменных окружения.
+func initAttemptsConfig() {
+	if n, err := strconv.Atoi(os.Getenv("DELIVERY_MAX_ATTEMPTS")); err == nil && n > 0 {
+		maxDeliveryAttempts = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("DELIVERY_RESCHEDULE_AFTER")); err == nil && d > 0 {
+		rescheduleAfter = d
+	}
+}
+
+// attemptOutcome решает, переносить доставку или возвращать заказ после attempt-й неудачной попытки.
+func attemptOutcome(reason string, attempt int) string {
+	if failureReasons[reason].retry && attempt < maxDeliveryAttempts {
+		return outcomeRescheduled
+	}
+	return outcomeReturned
+}
+
+// rescheduleWindow переносит окно доставки на rescheduleAfter (столько раз, чтобы оно не было в прошлом)
+// и пересчитывает обещанный срок. Заказ без окна получает новый срок от момента переноса.
+func rescheduleWindow(o Order, now time.Time) (start, end, due *time.Time) {
+	if o.WindowStart != nil && o.WindowEnd != nil {
+		s, e := o.WindowStart.Add(rescheduleAfter), o.WindowEnd.Add(rescheduleAfter)
+		for !e.After(now) {
+			s, e = s.Add(rescheduleAfter), e.Add(rescheduleAfter)
+		}
+		return &s, &e, &e
+	}
+	next := o
+	next.CreatedAt = now.Add(rescheduleAfter)
+	return nil, nil, slaDueAt(next)
+}
+
+// newReturnOrder — возвратный заказ: посылка едет обратно с address_to на address_from,
+// получателем становится отправитель.
+func newReturnOrder(o Order, now time.Time) (Order, error) {
+	token, err := newTrackingToken()
+	if err != nil {
+		return Order{}, err
+	}
+	r := Order{
+		ID:             o.ID + "-R",
+		SenderName:     o.RecipientName,
+		RecipientName:  o.SenderName,
+		AddressFrom:    o.AddressTo,
+		AddressTo:      o.AddressFrom,
+		Status:         "новый",
+		CreatedAt:      now,
+		Weight:         o.Weight,
+		Length:         o.Length,
+		Width:          o.Width,
+		Height:         o.Height,
+		Urgency:        1,
+		Email:          o.Email,
+		RecipientEmail: o.Email,
+		CreatedBy:      o.CreatedBy,
+		TenantID:       o.TenantID,
+		TrackingToken:  token,
+		FromLat:        o.ToLat,
+		FromLng:        o.ToLng,
+		ToLat:          o.FromLat,
+		ToLng:          o.FromLng,
+		ReturnOf:       o.ID,
+		PaymentMethod:  paymentPrepaid,
+	}
+	r.DueAt = slaDueAt(r)
+	return r, nil
+}
+
+// FailedAttemptResult — ответ POST /orders/:id/attempt-failed.
+type FailedAttemptResult struct {
+	OrderID       string     `json:"order_id"`
+	Attempt       int        `json:"attempt"`
+	Reason        string     `json:"reason"`
+	Outcome       string     `json:"outcome"` // rescheduled или returned
+	WindowStart   *time.Time `json:"window_start,omitempty"`
+	WindowEnd     *time.Time `json:"window_end,omitempty"`
+	DueAt         *time.Time `json:"due_at,omitempty"`
+	ReturnOrderID string     `json:"return_order_id,omitempty"`
+}
+
+// POST /orders/:id/attempt-failed — курьер сообщает о неудачной попытке доставки.
+// Доставка переносится, пока причина допускает повтор и не исчерпан maxDeliveryAttempts,
+// иначе создаётся возвратный заказ на address_from. Курьер освобождается в обоих случаях.
+func attemptFailedHandler(c *gin.Context) {
+	var body struct {
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	reason, ok := failureReasons[body.Reason]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason: not_home, wrong_address, refused или damaged"})
+		return
+	}
+
+	claims := auth.CurrentClaims(c)
+	o, err := scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE id = $1", c.Param("id")))
+	if err == sql.ErrNoRows || (err == nil && !auth.SameTenant(claims, o.TenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Заказ не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if claims.Role == auth.RoleCourier && (claims.CourierID == "" || o.CourierID != claims.CourierID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Заказ назначен другому курьеру"})
+		return
+	}
+	if o.CourierID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Курьер ещё не назначен"})
+		return
+	}
+
+	now := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	res := FailedAttemptResult{OrderID: o.ID, Reason: body.Reason}
+	err = tx.QueryRow(`UPDATE orders SET failed_attempts = failed_attempts + 1
+		WHERE id = $1 AND completed_at IS NULL AND COALESCE(status, '') NOT IN ($2, $3) RETURNING failed_attempts`,
+		o.ID, statusReturned, statusCancelled).Scan(&res.Attempt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ уже закрыт"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res.Outcome = attemptOutcome(body.Reason, res.Attempt)
+	var ret Order
+	var retPIN string
+	if res.Outcome == outcomeRescheduled {
+		res.WindowStart, res.WindowEnd, res.DueAt = rescheduleWindow(o, now)
+		_, err = tx.Exec(`UPDATE orders SET courier_id = NULL, status = 'новый', window_start = $2, window_end = $3,
+			due_at = $4, sla_status = NULL, eta = NULL WHERE id = $1`, o.ID, res.WindowStart, res.WindowEnd, res.DueAt)
+	} else {
+		if ret, err = newReturnOrder(o, now); err == nil {
+			retPIN, err = newDeliveryPIN()
+		}
+		if err == nil {
+			err = insertOrder(tx, ret, hashDeliveryPIN(ret.ID, retPIN))
+		}
+		if err == nil {
+			res.ReturnOrderID = ret.ID
+			_, err = tx.Exec(`UPDATE orders SET courier_id = NULL, status = $2, return_order_id = $3, eta = NULL WHERE id = $1`,
+				o.ID, statusReturned, ret.ID)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE couriers SET status = 'доступен', active_order_id = NULL WHERE id = $1`, o.CourierID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	details := fmt.Sprintf("попытка %d: %s", res.Attempt, reason.label)
+	if body.Comment != "" {
+		details += " (" + body.Comment + ")"
+	}
+	history := [][2]string{{historyAttemptFailed, details}}
+	var senderMsg, recipientMsg string
+	if res.Outcome == outcomeRescheduled {
+		when := "в ближайшее время"
+		if res.WindowStart != nil {
+			when = fmt.Sprintf("%s – %s", res.WindowStart.Format("2006-01-02 15:04"), res.WindowEnd.Format("15:04"))
+		}
+		history = append(history, [2]string{historyRescheduled, when})
+		senderMsg = fmt.Sprintf("Не удалось доставить заказ %s: %s. Повторная доставка: %s.", o.ID, reason.label, when)
+		recipientMsg = senderMsg + trackingLinkText(o.TrackingToken)
+	} else {
+		history = append(history, [2]string{historyReturned, "возвратный заказ " + ret.ID})
+		senderMsg = fmt.Sprintf("Заказ %s возвращается отправителю: %s. Возвратный заказ %s.", o.ID, reason.label, ret.ID) +
+			trackingLinkText(ret.TrackingToken)
+		recipientMsg = fmt.Sprintf("Заказ %s не доставлен (%s) и возвращается отправителю.", o.ID, reason.label)
+	}
+	for _, h := range history {
+		if err := recordOrderHistory(o.ID, h[0], claims.Subject, h[1]); err != nil {
+			log.Printf("Ошибка записи истории заказа %s: %v", o.ID, err)
+		}
+	}
+
+	_ = publishNotification("delivery_failed", o.Email, senderMsg, o.TenantID)
+	if o.RecipientEmail != "" {
+		_ = publishNotification("delivery_failed", o.RecipientEmail, recipientMsg, o.TenantID)
+	}
+	if res.Outcome == outcomeReturned {
+		if err := publishDeliveryPIN(ret, retPIN); err != nil {
+			log.Printf("Ошибка отправки PIN возвратного заказа %s: %v", ret.ID, err)
+		}
+	}
+	event := map[string]any{
+		"event":      "order.delivery_failed",
+		"order_id":   o.ID,
+		"courier_id": o.CourierID,
+		"tenant_id":  o.TenantID,
+		"reason":     body.Reason,
+		"attempt":    res.Attempt,
+		"outcome":    res.Outcome,
+		"failed_at":  now.UTC().Format(time.RFC3339),
+	}
+	if res.ReturnOrderID != "" {
+		event["return_order_id"] = res.ReturnOrderID
+	}
+	if err := publishDeliveryFailed("order.delivery_failed", event); err != nil {
+		log.Printf("Ошибка публикации order.delivery_failed по заказу %s: %v", o.ID, err)
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestAttemptOutcome(t *testing.T) {
+	defer func(n int) { maxDeliveryAttempts = n }(maxDeliveryAttempts)
+	maxDeliveryAttempts = 3
+
+	assert.Equal(t, outcomeRescheduled, attemptOutcome(reasonNotHome, 1))
+	assert.Equal(t, outcomeRescheduled, attemptOutcome(reasonWrongAddress, 2))
+	assert.Equal(t, outcomeReturned, attemptOutcome(reasonNotHome, 3), "попытки исчерпаны")
+	assert.Equal(t, outcomeReturned, attemptOutcome(reasonRefused, 1))
+	assert.Equal(t, outcomeReturned, attemptOutcome(reasonDamaged, 1))
+}
+
+func TestRescheduleWindow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	start, end := now.Add(-time.Hour), now.Add(time.Hour)
+	s, e, due := rescheduleWindow(Order{WindowStart: &start, WindowEnd: &end}, now)
+	assert.Equal(t, start.Add(24*time.Hour), *s)
+	assert.Equal(t, end.Add(24*time.Hour), *e)
+	assert.Equal(t, *e, *due)
+
+	// окно давно прошло — переносится на ближайший такой же слот в будущем
+	old := now.Add(-72 * time.Hour)
+	oldEnd := old.Add(2 * time.Hour)
+	s, e, _ = rescheduleWindow(Order{WindowStart: &old, WindowEnd: &oldEnd}, now)
+	assert.True(t, e.After(now))
+	assert.Equal(t, 12, s.Hour())
+	assert.True(t, s.Before(now.Add(24*time.Hour)))
+
+	s, _, due = rescheduleWindow(Order{Urgency: 2}, now)
+	assert.Nil(t, s)
+	assert.Equal(t, now.Add(24*time.Hour+slaByUrgency[2]), *due)
+}
+
+func TestNewReturnOrder(t *testing.T) {
+	lat, lng := 55.75, 37.61
+	o := Order{ID: "o-1", SenderName: "ООО Ромашка", RecipientName: "Иван", AddressFrom: "склад", AddressTo: "дом",
+		Email: "shop@example.com", RecipientEmail: "ivan@example.com", TenantID: "shop-1", Urgency: 2, ToLat: &lat, ToLng: &lng}
+	r, err := newReturnOrder(o, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "o-1-R", r.ID)
+	assert.Equal(t, "o-1", r.ReturnOf)
+	assert.Equal(t, "дом", r.AddressFrom)
+	assert.Equal(t, "склад", r.AddressTo)
+	assert.Equal(t, "ООО Ромашка", r.RecipientName)
+	assert.Equal(t, "shop@example.com", pinRecipient(r), "PIN возврата получает отправитель")
+	assert.Equal(t, &lat, r.FromLat)
+	assert.Nil(t, r.ToLat)
+	assert.NotEmpty(t, r.TrackingToken)
+	assert.NotEqual(t, o.TrackingToken, r.TrackingToken)
+}
+
+func TestAttemptFailedHandlerReturnsToSender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	var event map[string]any
+	publishDeliveryFailed = func(queue string, payload any) error {
+		event = payload.(map[string]any)
+		return nil
+	}
+	defer func() { publishDeliveryFailed = publishEventToQueue }()
+
+	claims := &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"}
+	claims.Subject = "courier1"
+	r := gin.New()
+	r.POST("/orders/:id/attempt-failed", func(c *gin.Context) { c.Set("claims", claims) }, attemptFailedHandler)
+
+	expectTestOrder(mock, "o-1", "shop-1")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE orders SET failed_attempts = failed_attempts + 1")).
+		WithArgs("o-1", statusReturned, statusCancelled).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET courier_id = NULL, status = $2, return_order_id = $3")).
+		WithArgs("o-1", statusReturned, "o-1-R").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE couriers SET status = 'доступен'")).WithArgs("c-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyAttemptFailed, "courier1", "попытка 1: получатель отказался от заказа").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyReturned, "courier1", "возвратный заказ o-1-R").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/attempt-failed", strings.NewReader(`{"reason":"refused"}`)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res FailedAttemptResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, outcomeReturned, res.Outcome)
+	assert.Equal(t, "o-1-R", res.ReturnOrderID)
+	assert.Equal(t, "refused", event["reason"])
+	assert.Equal(t, "shop-1", event["tenant_id"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/attempt-failed", strings.NewReader(`{"reason":"lost"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
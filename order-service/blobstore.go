@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore хранит бинарные файлы (фото и подписи подтверждения доставки) по ключу вида "pod/<order_id>/photo.jpg".
+// Реализация выбирается переменной BLOB_STORE; для разработки есть локальная файловая система.
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) ([]byte, error)
+}
+
+// errBlobNotFound — файла с таким ключом нет в хранилище.
+var errBlobNotFound = errors.New("файл не найден")
+
+// blobs — хранилище, которым пользуется сервис; заменяется в тестах.
+var blobs BlobStore = localBlobStore{dir: "data/blobs"}
+
+// initBlobStore настраивает хранилище по BLOB_STORE (по умолчанию local) и BLOB_DIR.
+func initBlobStore() error {
+	switch kind := os.Getenv("BLOB_STORE"); kind {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("каталог хранилища %s: %w", dir, err)
+		}
+		blobs = localBlobStore{dir: dir}
+		return nil
+	default:
+		return fmt.Errorf("неизвестное хранилище BLOB_STORE=%q", kind)
+	}
+}
+
+// localBlobStore хранит файлы в каталоге dir, ключ — относительный путь внутри него.
+// Тип содержимого не сохраняется: вызывающий определяет его по данным.
+type localBlobStore struct {
+	dir string
+}
+
+func (s localBlobStore) path(key string) (string, error) {
+	p := filepath.FromSlash(key)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("недопустимый ключ %q", key)
+	}
+	return filepath.Join(s.dir, p), nil
+}
+
+func (s localBlobStore) Put(key string, data []byte, _ string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+	// Пишем во временный файл и переименовываем, чтобы читатель не увидел файл наполовину.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s localBlobStore) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return data, err
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	s := localBlobStore{dir: t.TempDir()}
+
+	assert.NoError(t, s.Put("pod/1/photo.png", []byte("data"), "image/png"))
+	data, err := s.Get("pod/1/photo.png")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	assert.NoError(t, s.Put("pod/1/photo.png", []byte("new"), "image/png"), "повторная запись заменяет файл")
+	data, _ = s.Get("pod/1/photo.png")
+	assert.Equal(t, []byte("new"), data)
+
+	_, err = s.Get("pod/2/photo.png")
+	assert.ErrorIs(t, err, errBlobNotFound)
+
+	assert.Error(t, s.Put("../escape", []byte("x"), ""), "ключ не может выходить за пределы каталога")
+	_, err = s.Get("/etc/passwd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Действия POST /orders/bulk.
+const (
+	bulkAssign   = "assign"
+	bulkUnassign = "unassign"
+	bulkCancel   = "cancel"
+	bulkUrgency  = "urgency"
+)
+
+// maxBulkOrders — сколько заказов можно передать в одном запросе.
+const maxBulkOrders = 500
+
+// Статусы заказа в ответе POST /orders/bulk.
+const (
+	bulkOK      = "ok"
+	bulkError   = "error"
+	bulkSkipped = "skipped" // не выполнялся: в режиме all_or_nothing ошибка в другом заказе
+)
+
+// History-событие смены срочности.
+const historyUrgencyChanged = "urgency_changed"
+
+// BulkRequest — тело POST /orders/bulk.
+type BulkRequest struct {
+	Action       string   `json:"action"`
+	OrderIDs     []string `json:"order_ids"`
+	CourierID    string   `json:"courier_id,omitempty"` // для assign
+	Urgency      int      `json:"urgency,omitempty"`    // для urgency
+	Reason       string   `json:"reason,omitempty"`     // для cancel
+	AllOrNothing bool     `json:"all_or_nothing"`
+}
+
+// BulkResult — итог по одному заказу; Code — HTTP-статус, который вернул бы одиночный эндпоинт.
+type BulkResult struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkOp — шаги действия над одним заказом, общие с одиночными эндпоинтами.
+type bulkOp struct {
+	check   func(o Order) (int, error)             // проверки до изменений
+	prepare func(o Order) (int, error)             // внешние вызовы до транзакции (возврат платежа); может быть nil
+	apply   func(tx *sql.Tx, o Order) (int, error) // изменения в БД
+	done    func(o Order)                          // уведомления после commit; может быть nil
+}
+
+// checkUrgency — можно ли сменить срочность: заказ не вручён и не закрыт, а стоимость (зависит от срочности)
+// ещё не оплачена картой.
+func checkUrgency(o Order) (int, error) {
+	if o.CompletedAt.Valid || !orderOpen(o.Status) {
+		return http.StatusConflict, errors.New("Заказ уже закрыт")
+	}
+	switch o.PaymentStatus {
+	case paymentPending, paymentAuthorized, paymentCaptured:
+		return http.StatusConflict, errors.New("Стоимость заказа уже оплачена: срочность не меняется")
+	}
+	return 0, nil
+}
+
+// applyUrgency меняет срочность и пересчитывает due_at; стоимость сбрасывается и будет заново
+// рассчитана delivery-service при оплате или выставлении счёта (см. ensureOrderPrice).
+func applyUrgency(tx *sql.Tx, o Order, urgency int) (int, error) {
+	o.Urgency = urgency
+	res, err := tx.Exec(`UPDATE orders SET urgency = $2, due_at = $3, sla_status = NULL, price = NULL, price_currency = NULL,
+		price_discount = NULL, price_base_currency = NULL, price_exchange_rate = NULL
+		WHERE id = $1 AND completed_at IS NULL`, o.ID, urgency, slaDueAt(o))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return http.StatusConflict, errors.New("Заказ уже закрыт")
+	}
+	return 0, nil
+}
+
+// newBulkOp собирает шаги действия req.Action; ошибка — некорректный запрос.
+func newBulkOp(req BulkRequest, actor string) (bulkOp, error) {
+	switch req.Action {
+	case bulkAssign:
+		if req.CourierID == "" {
+			return bulkOp{}, errors.New("courier_id: обязателен для assign")
+		}
+		return bulkOp{
+			check: func(o Order) (int, error) { return checkAssign(o, req.CourierID) },
+			apply: func(tx *sql.Tx, o Order) (int, error) { return applyAssign(tx, o.ID, req.CourierID) },
+			done:  func(o Order) { notifyTrackingAssigned(o.ID, req.CourierID, o.TenantID) },
+		}, nil
+	case bulkUnassign:
+		return bulkOp{
+			check: func(o Order) (int, error) { return checkAssign(o, "") },
+			apply: func(tx *sql.Tx, o Order) (int, error) { return applyAssign(tx, o.ID, "") },
+		}, nil
+	case bulkCancel:
+		refunds := map[string]*Payment{}
+		return bulkOp{
+			check: checkCancel,
+			prepare: func(o Order) (int, error) {
+				refund, err := refundPayment(o.ID)
+				if err != nil {
+					return http.StatusBadGateway, errors.New("Не удалось вернуть платёж: " + err.Error())
+				}
+				refunds[o.ID] = refund
+				return 0, nil
+			},
+			apply: applyCancel,
+			done:  func(o Order) { announceCancel(o, refunds[o.ID], actor, req.Reason) },
+		}, nil
+	case bulkUrgency:
+		if req.Urgency != 1 && req.Urgency != 2 {
+			return bulkOp{}, errors.New("urgency: 1 или 2")
+		}
+		return bulkOp{
+			check: checkUrgency,
+			apply: func(tx *sql.Tx, o Order) (int, error) { return applyUrgency(tx, o, req.Urgency) },
+			done: func(o Order) {
+				details := fmt.Sprintf("%d → %d", o.Urgency, req.Urgency)
+				if err := recordOrderHistory(o.ID, historyUrgencyChanged, actor, details); err != nil {
+					log.Printf("Ошибка записи истории заказа %s: %v", o.ID, err)
+				}
+			},
+		}, nil
+	}
+	return bulkOp{}, fmt.Errorf("action: %s, %s, %s или %s", bulkAssign, bulkUnassign, bulkCancel, bulkUrgency)
+}
+
+// runBulk выполняет op над заказами ids. В режиме allOrNothing изменения применяются одной транзакцией
+// и только если все заказы прошли проверки; иначе каждый заказ — отдельной транзакцией.
+// Возврат платежа при отмене — внешний вызов: он выполняется до транзакции, как и в POST /orders/:id/cancel,
+// и при откате уже возвращённые платежи остаются возвращёнными (отмену можно повторить).
+func runBulk(claims *auth.Claims, ids []string, op bulkOp, allOrNothing bool) []BulkResult {
+	results := make([]BulkResult, len(ids))
+	orders := make([]Order, len(ids))
+	failed := false
+	fail := func(i, code int, err error) {
+		results[i].Status, results[i].Code, results[i].Error = bulkError, code, err.Error()
+		failed = true
+	}
+	// skipRest помечает ещё не выполненные заказы как пропущенные.
+	skipRest := func() {
+		for i := range results {
+			if results[i].Status == "" {
+				results[i].Status = bulkSkipped
+			}
+		}
+	}
+
+	// 1. Загрузка и проверки — без изменений.
+	for i, id := range ids {
+		results[i].OrderID = id
+		o, code, err := findAccessibleOrder(claims, id)
+		if err == nil {
+			code, err = op.check(o)
+		}
+		if err != nil {
+			fail(i, code, err)
+			continue
+		}
+		orders[i] = o
+	}
+	if failed && allOrNothing {
+		skipRest()
+		return results
+	}
+
+	// 2. Внешние вызовы до транзакции.
+	if op.prepare != nil {
+		for i, o := range orders {
+			if results[i].Status != "" {
+				continue
+			}
+			if code, err := op.prepare(o); err != nil {
+				fail(i, code, err)
+				if allOrNothing {
+					skipRest()
+					return results
+				}
+			}
+		}
+	}
+
+	// 3. Изменения в БД.
+	if allOrNothing {
+		applyBulkTx(orders, results, op)
+	} else {
+		for i := range orders {
+			if results[i].Status == "" {
+				applyBulkTx(orders[i:i+1], results[i:i+1], op)
+			}
+		}
+	}
+
+	// 4. Уведомления по применённым заказам.
+	for i, o := range orders {
+		if results[i].Status == bulkOK && op.done != nil {
+			op.done(o)
+		}
+	}
+	return results
+}
+
+// applyBulkTx применяет op к заказам одной транзакцией; при ошибке откатывает все
+// и помечает остальные заказы пропущенными.
+func applyBulkTx(orders []Order, results []BulkResult, op bulkOp) {
+	setAll := func(status string, code int, err error) {
+		for i := range results {
+			if results[i].Status == "" {
+				results[i].Status, results[i].Code = status, code
+				if err != nil {
+					results[i].Error = err.Error()
+				}
+			}
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		setAll(bulkError, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	for i, o := range orders {
+		if code, err := op.apply(tx, o); err != nil {
+			results[i].Status, results[i].Code, results[i].Error = bulkError, code, err.Error()
+			setAll(bulkSkipped, 0, nil)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		setAll(bulkError, http.StatusInternalServerError, err)
+		return
+	}
+	setAll(bulkOK, http.StatusOK, nil)
+}
+
+// POST /orders/bulk — одно действие над списком заказов: assign (courier_id), unassign, cancel (reason),
+// urgency (urgency). Каждый заказ проходит те же проверки, что и одиночные эндпоинты.
+// Ответ 200 с результатом по каждому заказу; при all_or_nothing и ошибке ничего не меняется и ответ 409.
+func bulkOrdersHandler(c *gin.Context) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Повторы ID выполняются один раз, порядок сохраняется.
+	seen := make(map[string]bool, len(req.OrderIDs))
+	ids := make([]string, 0, len(req.OrderIDs))
+	for _, id := range req.OrderIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || len(ids) > maxBulkOrders {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("order_ids: от 1 до %d заказов", maxBulkOrders)})
+		return
+	}
+	claims := auth.CurrentClaims(c)
+	op, err := newBulkOp(req, claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := runBulk(claims, ids, op, req.AllOrNothing)
+	succeeded, failed := 0, 0
+	for _, r := range results {
+		switch r.Status {
+		case bulkOK:
+			succeeded++
+		case bulkError:
+			failed++
+		}
+	}
+	status := http.StatusOK
+	if req.AllOrNothing && failed > 0 {
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{
+		"action":    req.Action,
+		"succeeded": succeeded,
+		"failed":    failed,
+		"results":   results,
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+type bulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+func bulkTestRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	t.Cleanup(func() { mockDB.Close() })
+	db = mockDB
+
+	claims := &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}
+	claims.Subject = "ops"
+	r := gin.New()
+	r.POST("/orders/bulk", func(c *gin.Context) { c.Set("claims", claims) }, bulkOrdersHandler)
+	return r, mock
+}
+
+func postBulk(r *gin.Engine, body string) (*httptest.ResponseRecorder, bulkResponse) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/bulk", strings.NewReader(body)))
+	var res bulkResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	return w, res
+}
+
+func TestBulkOrdersHandlerBadRequest(t *testing.T) {
+	r, mock := bulkTestRouter(t)
+	for _, body := range []string{
+		`{"action":"delete","order_ids":["o-1"]}`,
+		`{"action":"assign","order_ids":["o-1"]}`,
+		`{"action":"urgency","order_ids":["o-1"],"urgency":3}`,
+		`{"action":"cancel","order_ids":[]}`,
+	} {
+		w, _ := postBulk(r, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBulkAssignPartial(t *testing.T) {
+	r, mock := bulkTestRouter(t)
+
+	expectOpenOrder(mock, "o-1", "", paymentAuthorized, 500)
+	mock.ExpectQuery("SELECT tenant_id FROM couriers").WithArgs("c-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("shop-1"))
+	expectOpenOrder(mock, "o-2", "", paymentUnpaid, 500)
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs("o-3").WillReturnRows(sqlmock.NewRows(testOrderColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET courier_id = \\$1").WithArgs("c-1", "o-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE couriers SET active_order_id").WithArgs("o-1", "c-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w, res := postBulk(r, `{"action":"assign","courier_id":"c-1","order_ids":["o-1","o-2","o-1","o-3"]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, res.Succeeded)
+	assert.Equal(t, 2, res.Failed)
+	assert.Equal(t, []BulkResult{
+		{OrderID: "o-1", Status: bulkOK, Code: http.StatusOK},
+		{OrderID: "o-2", Status: bulkError, Code: http.StatusPaymentRequired, Error: "Заказ не оплачен: курьер назначается после авторизации платежа"},
+		{OrderID: "o-3", Status: bulkError, Code: http.StatusNotFound, Error: "Заказ не найден"},
+	}, res.Results, "повтор o-1 выполняется один раз")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBulkUrgencyAllOrNothing(t *testing.T) {
+	r, mock := bulkTestRouter(t)
+
+	// o-2 уже оплачен — ничего не меняется
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 500)
+	expectOpenOrder(mock, "o-2", "", paymentCaptured, 500)
+	w, res := postBulk(r, `{"action":"urgency","urgency":2,"all_or_nothing":true,"order_ids":["o-1","o-2"]}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, bulkSkipped, res.Results[0].Status)
+	assert.Equal(t, bulkError, res.Results[1].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// ошибка при записи второго заказа откатывает и первый
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 500)
+	expectOpenOrder(mock, "o-2", "", "", 0)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET urgency").WithArgs("o-1", 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET urgency").WithArgs("o-2", 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	w, res = postBulk(r, `{"action":"urgency","urgency":2,"all_or_nothing":true,"order_ids":["o-1","o-2"]}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, 0, res.Succeeded)
+	assert.Equal(t, []BulkResult{
+		{OrderID: "o-1", Status: bulkSkipped},
+		{OrderID: "o-2", Status: bulkError, Code: http.StatusConflict, Error: "Заказ уже закрыт"},
+	}, res.Results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBulkCancel(t *testing.T) {
+	r, mock := bulkTestRouter(t)
+
+	expectOpenOrder(mock, "o-1", "c-1", "", 0)
+	mock.ExpectQuery("FROM payments WHERE order_id").WithArgs("o-1", paymentAuthorized, paymentCaptured, paymentPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET status = \\$2").WithArgs("o-1", statusCancelled).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE couriers SET status").WithArgs("c-1", "o-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyCancelled, "ops", "дубль партии").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, res := postBulk(r, `{"action":"cancel","reason":"дубль партии","all_or_nothing":true,"order_ids":["o-1"]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, res.Succeeded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
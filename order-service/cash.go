@@ -0,0 +1,447 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Способы оплаты заказа.
+const (
+	paymentPrepaid = "prepaid"          // оплачен при оформлении
+	paymentCOD     = "cash_on_delivery" // наличными курьеру при вручении
+	paymentInvoice = "invoice"          // по ежемесячному счёту организации (см. invoices.go)
+)
+
+// Операции кассы курьера: получил наличные у получателя или сдал их в кассу.
+const (
+	cashCollected = "collected"
+	cashHandover  = "handover"
+)
+
+// defaultCashCurrency — валюта наложенного платежа, если у заказа не указана своя.
+const defaultCashCurrency = "RUB"
+
+// cashEpsilon — расхождения меньше копейки не считаются.
+const cashEpsilon = 0.005
+
+// roundMoney округляет сумму до копеек.
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// cashCurrency — валюта, в которой курьер получает наложенный платёж по заказу.
+func cashCurrency(currency string) string {
+	if currency == "" {
+		return defaultCashCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// validatePayment проверяет способ оплаты и сумму наложенного платежа. Пустой способ остаётся пустым:
+// такой заказ, как и созданные до появления оплаты, хранится как prepaid, но онлайн-оплаты не ждёт
+// и сразу может уйти курьеру (см. createOrder).
+func validatePayment(o *Order) error {
+	switch o.PaymentMethod {
+	case "", paymentPrepaid, paymentInvoice:
+		if o.CODAmount != 0 {
+			return errors.New("cod_amount указывается только для оплаты наличными при получении")
+		}
+	case paymentCOD:
+		if o.CODAmount <= 0 || math.IsInf(o.CODAmount, 0) || math.IsNaN(o.CODAmount) {
+			return errors.New("для оплаты при получении нужна положительная cod_amount")
+		}
+		o.CODAmount = roundMoney(o.CODAmount)
+	default:
+		return fmt.Errorf("payment_method: %s, %s или %s", paymentPrepaid, paymentCOD, paymentInvoice)
+	}
+	return nil
+}
+
+// recordCashCollected записывает наличные, полученные курьером при вручении заказа.
+// expected — сумма наложенного платежа по заказу: расхождение покажет сверка смены.
+func recordCashCollected(exec execer, tenantID, courierID, orderID, currency string, collected, expected float64, recordedBy string) error {
+	_, err := exec.Exec(`
+		INSERT INTO courier_cash_ledger (tenant_id, courier_id, order_id, entry_type, amount, expected_amount, currency, recorded_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())`,
+		tenantID, courierID, orderID, cashCollected, roundMoney(collected), expected, cashCurrency(currency), recordedBy)
+	return err
+}
+
+// CashEntry — операция в кассе курьера.
+type CashEntry struct {
+	ID         int64     `json:"id"`
+	OrderID    string    `json:"order_id,omitempty"`
+	Type       string    `json:"type"` // collected или handover
+	Amount     float64   `json:"amount"`
+	Expected   *float64  `json:"expected_amount,omitempty"` // сумма наложенного платежа по заказу
+	Currency   string    `json:"currency"`
+	RecordedBy string    `json:"recorded_by"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CashBalance — сколько наличных числится за курьером в одной валюте.
+type CashBalance struct {
+	Currency   string  `json:"currency"`
+	Collected  float64 `json:"collected"`
+	HandedOver float64 `json:"handed_over"`
+	Balance    float64 `json:"balance"`
+}
+
+// CourierCash — ответ GET /couriers/:id/cash.
+type CourierCash struct {
+	CourierID string        `json:"courier_id"`
+	Balances  []CashBalance `json:"balances"`
+	Entries   []CashEntry   `json:"entries"`
+}
+
+// loadCashEntries возвращает операции курьера за [from, to) в хронологическом порядке.
+func loadCashEntries(courierID string, from, to time.Time) ([]CashEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(order_id, ''), entry_type, amount, expected_amount, currency, recorded_by, COALESCE(comment, ''), created_at
+		FROM courier_cash_ledger
+		WHERE courier_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, id`, courierID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []CashEntry{}
+	for rows.Next() {
+		var e CashEntry
+		if err := rows.Scan(&e.ID, &e.OrderID, &e.Type, &e.Amount, &e.Expected, &e.Currency, &e.RecordedBy, &e.Comment, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// loadCashBalances считает остаток наличных курьера по валютам на момент before (nil — текущий остаток).
+func loadCashBalances(courierID string, before *time.Time) ([]CashBalance, error) {
+	rows, err := db.Query(`
+		SELECT currency,
+		       COALESCE(SUM(amount) FILTER (WHERE entry_type = 'collected'), 0),
+		       COALESCE(SUM(amount) FILTER (WHERE entry_type = 'handover'), 0)
+		FROM courier_cash_ledger
+		WHERE courier_id = $1 AND ($2::timestamp IS NULL OR created_at < $2)
+		GROUP BY currency ORDER BY currency`, courierID, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balances := []CashBalance{}
+	for rows.Next() {
+		var b CashBalance
+		if err := rows.Scan(&b.Currency, &b.Collected, &b.HandedOver); err != nil {
+			return nil, err
+		}
+		b.Balance = roundMoney(b.Collected - b.HandedOver)
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// loadAccessibleCourier проверяет доступ к данным курьера :id (касса, смены, заработок):
+// курьер видит только свои, остальные — в пределах организации.
+// При отказе ответ уже отправлен.
+func loadAccessibleCourier(c *gin.Context) (id, name, tenantID string, ok bool) {
+	id = c.Param("id")
+	claims := auth.CurrentClaims(c)
+	if claims.Role == auth.RoleCourier && claims.CourierID != id {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return "", "", "", false
+	}
+	err := db.QueryRow("SELECT name, tenant_id FROM couriers WHERE id = $1", id).Scan(&name, &tenantID)
+	if err == sql.ErrNoRows || (err == nil && !auth.SameTenant(claims, tenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Курьер не найден"})
+		return "", "", "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", "", "", false
+	}
+	return id, name, tenantID, true
+}
+
+// GET /couriers/:id/cash?from=&to= — остаток наличных у курьера и операции за период (по умолчанию — за сутки).
+func getCourierCashHandler(c *gin.Context) {
+	id, _, _, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if p := c.Query("from"); p != "" {
+		if from, err = time.Parse(time.RFC3339, p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from: ожидается RFC3339"})
+			return
+		}
+	}
+	if p := c.Query("to"); p != "" {
+		if to, err = time.Parse(time.RFC3339, p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to: ожидается RFC3339"})
+			return
+		}
+	}
+	balances, err := loadCashBalances(id, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := loadCashEntries(id, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, CourierCash{CourierID: id, Balances: balances, Entries: entries})
+}
+
+// POST /couriers/:id/cash/handover — диспетчер принимает у курьера наличные в кассу.
+func cashHandoverHandler(c *gin.Context) {
+	id, _, tenantID, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+		Comment  string  `json:"comment"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if body.Amount <= 0 || math.IsInf(body.Amount, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount должна быть положительной"})
+		return
+	}
+	e := CashEntry{Type: cashHandover, Amount: roundMoney(body.Amount), Currency: cashCurrency(body.Currency),
+		RecordedBy: auth.CurrentClaims(c).Subject, Comment: body.Comment}
+	err := db.QueryRow(`
+		INSERT INTO courier_cash_ledger (tenant_id, courier_id, entry_type, amount, currency, recorded_by, comment, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NOW())
+		RETURNING id, created_at`,
+		tenantID, id, e.Type, e.Amount, e.Currency, e.RecordedBy, e.Comment).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// Типы расхождений при сверке смены.
+const (
+	discrepancyShort       = "short_collected"  // курьер получил меньше суммы наложенного платежа
+	discrepancyOver        = "over_collected"   // получил больше
+	discrepancyOutstanding = "cash_outstanding" // к концу смены сдал не всё
+	discrepancyOverHanded  = "over_handed_over" // сдал больше, чем числится за ним
+)
+
+// ReconciliationLine — заказ с наложенным платежом, врученный в смену.
+type ReconciliationLine struct {
+	OrderID    string  `json:"order_id"`
+	Currency   string  `json:"currency"`
+	Expected   float64 `json:"expected"`
+	Collected  float64 `json:"collected"`
+	Difference float64 `json:"difference"` // collected - expected
+}
+
+// Discrepancy — расхождение, требующее разбора.
+type Discrepancy struct {
+	Type     string  `json:"type"`
+	OrderID  string  `json:"order_id,omitempty"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// ShiftTotal — итоги смены в одной валюте; Outstanding — остаток у курьера на конец смены с учётом прошлых дней.
+type ShiftTotal struct {
+	Currency    string  `json:"currency"`
+	Expected    float64 `json:"expected"`
+	Collected   float64 `json:"collected"`
+	HandedOver  float64 `json:"handed_over"`
+	Outstanding float64 `json:"outstanding"`
+}
+
+// Reconciliation — сверка наличных курьера за смену.
+type Reconciliation struct {
+	CourierID     string               `json:"courier_id"`
+	CourierName   string               `json:"courier_name"`
+	Date          string               `json:"date"`
+	Totals        []ShiftTotal         `json:"totals"`
+	Orders        []ReconciliationLine `json:"orders"`
+	Discrepancies []Discrepancy        `json:"discrepancies"`
+	Balanced      bool                 `json:"balanced"`
+}
+
+// buildReconciliation сверяет операции смены с суммами заказов и остатком на конец смены.
+func buildReconciliation(entries []CashEntry, endBalances []CashBalance) Reconciliation {
+	r := Reconciliation{Totals: []ShiftTotal{}, Orders: []ReconciliationLine{}, Discrepancies: []Discrepancy{}}
+	totals := map[string]*ShiftTotal{}
+	total := func(currency string) *ShiftTotal {
+		if totals[currency] == nil {
+			totals[currency] = &ShiftTotal{Currency: currency}
+		}
+		return totals[currency]
+	}
+	for _, e := range entries {
+		t := total(e.Currency)
+		if e.Type == cashHandover {
+			t.HandedOver = roundMoney(t.HandedOver + e.Amount)
+			continue
+		}
+		var expected float64
+		if e.Expected != nil {
+			expected = *e.Expected
+		}
+		t.Collected = roundMoney(t.Collected + e.Amount)
+		t.Expected = roundMoney(t.Expected + expected)
+		line := ReconciliationLine{OrderID: e.OrderID, Currency: e.Currency, Expected: expected, Collected: e.Amount,
+			Difference: roundMoney(e.Amount - expected)}
+		r.Orders = append(r.Orders, line)
+		switch {
+		case line.Difference < -cashEpsilon:
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{Type: discrepancyShort, OrderID: e.OrderID, Currency: e.Currency, Amount: -line.Difference})
+		case line.Difference > cashEpsilon:
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{Type: discrepancyOver, OrderID: e.OrderID, Currency: e.Currency, Amount: line.Difference})
+		}
+	}
+	for _, b := range endBalances {
+		t := total(b.Currency)
+		t.Outstanding = b.Balance
+		switch {
+		case b.Balance > cashEpsilon:
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{Type: discrepancyOutstanding, Currency: b.Currency, Amount: b.Balance})
+		case b.Balance < -cashEpsilon:
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{Type: discrepancyOverHanded, Currency: b.Currency, Amount: -b.Balance})
+		}
+	}
+	for _, t := range totals {
+		r.Totals = append(r.Totals, *t)
+	}
+	sort.Slice(r.Totals, func(i, j int) bool { return r.Totals[i].Currency < r.Totals[j].Currency })
+	r.Balanced = len(r.Discrepancies) == 0
+	return r
+}
+
+// GET /couriers/:id/cash/reconciliation?date=YYYY-MM-DD[&format=pdf] — сверка наличных за смену (календарный день,
+// по умолчанию сегодня): суммы по заказам против наложенных платежей и остаток, не сданный в кассу.
+func cashReconciliationHandler(c *gin.Context) {
+	id, name, _, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	day := time.Now()
+	if p := c.Query("date"); p != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", p, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date: ожидается YYYY-MM-DD"})
+			return
+		}
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 0, 1)
+
+	entries, err := loadCashEntries(id, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	balances, err := loadCashBalances(id, &to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r := buildReconciliation(entries, balances)
+	r.CourierID, r.CourierName, r.Date = id, name, from.Format("2006-01-02")
+
+	if c.Query("format") != "pdf" {
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	pdf := reconciliationPDF(r)
+	if pdf.Err() {
+		log.Printf("Внутренняя ошибка генерации PDF: %v", pdf.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка PDF"})
+		return
+	}
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=cash_%s_%s.pdf", r.CourierID, r.Date))
+	if err := pdf.Output(c.Writer); err != nil {
+		log.Printf("Ошибка вывода PDF: %v", err)
+	}
+}
+
+// discrepancyLabels — описания расхождений для отчёта.
+var discrepancyLabels = map[string]string{
+	discrepancyShort:       "недобор по заказу",
+	discrepancyOver:        "переплата по заказу",
+	discrepancyOutstanding: "не сдано в кассу",
+	discrepancyOverHanded:  "сдано больше, чем числится",
+}
+
+// reconciliationPDF — отчёт о сверке смены для кассира.
+func reconciliationPDF(r Reconciliation) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 20, 15)
+	pdf.AddPage()
+	pdf.AddUTF8Font("DejaVu", "", "assets/fonts/DejaVuSerif.ttf")
+	pdf.SetFont("DejaVu", "", 16)
+	pdf.Cell(0, 10, "Сверка наличных за смену")
+	pdf.Ln(12)
+	pdf.SetFont("DejaVu", "", 12)
+	pdf.Cell(0, 8, fmt.Sprintf("Курьер: %s (%s)", r.CourierName, r.CourierID))
+	pdf.Ln(8)
+	pdf.Cell(0, 8, "Дата: "+r.Date)
+	pdf.Ln(12)
+
+	row := func(cols []string, widths []float64) {
+		for i, col := range cols {
+			pdf.CellFormat(widths[i], 8, col, "1", 0, "", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	money := func(v float64) string { return fmt.Sprintf("%.2f", v) }
+
+	widths := []float64{50, 30, 35, 35, 30}
+	row([]string{"Заказ", "Валюта", "К оплате", "Получено", "Разница"}, widths)
+	for _, l := range r.Orders {
+		row([]string{l.OrderID, l.Currency, money(l.Expected), money(l.Collected), money(l.Difference)}, widths)
+	}
+	pdf.Ln(6)
+
+	widths = []float64{30, 35, 35, 35, 45}
+	row([]string{"Валюта", "К оплате", "Получено", "Сдано", "Остаток на конец"}, widths)
+	for _, t := range r.Totals {
+		row([]string{t.Currency, money(t.Expected), money(t.Collected), money(t.HandedOver), money(t.Outstanding)}, widths)
+	}
+	pdf.Ln(6)
+
+	if r.Balanced {
+		pdf.Cell(0, 8, "Расхождений нет.")
+		return pdf
+	}
+	pdf.Cell(0, 8, "Расхождения:")
+	pdf.Ln(8)
+	for _, d := range r.Discrepancies {
+		line := fmt.Sprintf("• %s: %s %s", discrepancyLabels[d.Type], money(d.Amount), d.Currency)
+		if d.OrderID != "" {
+			line += ", заказ " + d.OrderID
+		}
+		pdf.MultiCell(0, 7, line, "", "", false)
+	}
+	return pdf
+}
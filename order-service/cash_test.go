@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestValidatePayment(t *testing.T) {
+	o := Order{}
+	assert.NoError(t, validatePayment(&o))
+	assert.Empty(t, o.PaymentMethod, "без способа оплаты заказ не ждёт предоплаты")
+
+	o = Order{PaymentMethod: paymentCOD, CODAmount: 1499.999}
+	assert.NoError(t, validatePayment(&o))
+	assert.Equal(t, 1500.0, o.CODAmount)
+
+	assert.Error(t, validatePayment(&Order{PaymentMethod: paymentCOD}))
+	assert.Error(t, validatePayment(&Order{PaymentMethod: paymentCOD, CODAmount: -5}))
+	assert.Error(t, validatePayment(&Order{CODAmount: 100}), "сумма без наложенного платежа")
+	assert.Error(t, validatePayment(&Order{PaymentMethod: "card"}))
+	assert.NoError(t, validatePayment(&Order{PaymentMethod: paymentInvoice}))
+	assert.Error(t, validatePayment(&Order{PaymentMethod: paymentInvoice, CODAmount: 100}))
+}
+
+func TestBuildReconciliation(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	entries := []CashEntry{
+		{OrderID: "o-1", Type: cashCollected, Amount: 1000, Expected: f(1000), Currency: "RUB"},
+		{OrderID: "o-2", Type: cashCollected, Amount: 450, Expected: f(500), Currency: "RUB"},
+		{OrderID: "o-3", Type: cashCollected, Amount: 20, Expected: f(20), Currency: "USD"},
+		{Type: cashHandover, Amount: 1450, Currency: "RUB"},
+	}
+	// за курьером остались 20 USD, рубли сданы полностью
+	balances := []CashBalance{{Currency: "RUB", Balance: 0}, {Currency: "USD", Balance: 20}}
+
+	r := buildReconciliation(entries, balances)
+	assert.False(t, r.Balanced)
+	assert.Equal(t, []ShiftTotal{
+		{Currency: "RUB", Expected: 1500, Collected: 1450, HandedOver: 1450},
+		{Currency: "USD", Expected: 20, Collected: 20, Outstanding: 20},
+	}, r.Totals)
+	assert.Len(t, r.Orders, 3)
+	assert.Equal(t, -50.0, r.Orders[1].Difference)
+	assert.Equal(t, []Discrepancy{
+		{Type: discrepancyShort, OrderID: "o-2", Currency: "RUB", Amount: 50},
+		{Type: discrepancyOutstanding, Currency: "USD", Amount: 20},
+	}, r.Discrepancies)
+
+	ok := buildReconciliation(entries[:1], []CashBalance{{Currency: "RUB", Balance: 0.001}})
+	assert.True(t, ok.Balanced, "расхождение меньше копейки не считается")
+
+	var out bytes.Buffer
+	pdf := reconciliationPDF(r)
+	assert.NoError(t, pdf.Output(&out))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF")))
+}
+
+func TestCashHandoverHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	claims := &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}
+	claims.Subject = "cashier"
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("claims", claims) })
+	r.POST("/couriers/:id/cash/handover", cashHandoverHandler)
+	r.GET("/couriers/:id/cash", getCourierCashHandler)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, tenant_id FROM couriers")).WithArgs("c-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id"}).AddRow("Иван", "shop-1"))
+	mock.ExpectQuery("INSERT INTO courier_cash_ledger").
+		WithArgs("shop-1", "c-1", cashHandover, 1450.5, "RUB", "cashier", "конец смены").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/couriers/c-1/cash/handover",
+		strings.NewReader(`{"amount": 1450.5, "comment": "конец смены"}`)))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var e CashEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, int64(7), e.ID)
+
+	// чужая организация
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, tenant_id FROM couriers")).WithArgs("c-9").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id"}).AddRow("Пётр", "shop-2"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/couriers/c-9/cash/handover", strings.NewReader(`{"amount": 10}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// курьер видит только свою кассу
+	claims.Role, claims.CourierID = auth.RoleCourier, "c-2"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/couriers/c-1/cash", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// PayRules — правила оплаты курьеров организации. Заработок считается по текущим правилам на момент расчёта.
+type PayRules struct {
+	TenantID     string  `json:"tenant_id"`
+	Currency     string  `json:"currency"`
+	PerDelivery  float64 `json:"per_delivery"`   // за каждую доставку
+	PerKm        float64 `json:"per_km"`         // за километр маршрута (по координатам заказа)
+	PerShiftHour float64 `json:"per_shift_hour"` // за час на смене
+	UrgencyBonus float64 `json:"urgency_bonus"`  // надбавка за экспресс-доставку
+	SLAPenalty   float64 `json:"sla_penalty"`    // удержание за доставку позже обещанного срока
+}
+
+// defaultPayRules — правила организации, которая не задала свои.
+var defaultPayRules = PayRules{Currency: defaultCashCurrency, PerDelivery: 150, PerKm: 12, PerShiftHour: 250, UrgencyBonus: 100, SLAPenalty: 200}
+
+func (r PayRules) validate() error {
+	for _, v := range []float64{r.PerDelivery, r.PerKm, r.PerShiftHour, r.UrgencyBonus, r.SLAPenalty} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("ставки не могут быть отрицательными")
+		}
+	}
+	if len(r.Currency) != 3 {
+		return fmt.Errorf("currency: код валюты ISO 4217")
+	}
+	return nil
+}
+
+// loadPayRules возвращает правила организации или defaultPayRules.
+func loadPayRules(tenantID string) (PayRules, error) {
+	r := PayRules{TenantID: tenantID}
+	err := db.QueryRow(`SELECT currency, per_delivery, per_km, per_shift_hour, urgency_bonus, sla_penalty
+		FROM courier_pay_rules WHERE tenant_id = $1`, tenantID).
+		Scan(&r.Currency, &r.PerDelivery, &r.PerKm, &r.PerShiftHour, &r.UrgencyBonus, &r.SLAPenalty)
+	if err == sql.ErrNoRows {
+		r = defaultPayRules
+		r.TenantID = tenantID
+		return r, nil
+	}
+	return r, err
+}
+
+// GET /pay-rules — правила оплаты курьеров организации (администратору платформы — ?tenant_id=).
+func getPayRulesHandler(c *gin.Context) {
+	r, err := loadPayRules(auth.RecordTenant(auth.CurrentClaims(c), c.Query("tenant_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// PUT /pay-rules — задать правила оплаты курьеров организации.
+func updatePayRulesHandler(c *gin.Context) {
+	var r PayRules
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	if r.Currency == "" {
+		r.Currency = defaultCashCurrency
+	}
+	r.Currency = strings.ToUpper(r.Currency)
+	if err := r.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.TenantID = auth.RecordTenant(auth.CurrentClaims(c), r.TenantID)
+	_, err := db.Exec(`
+		INSERT INTO courier_pay_rules (tenant_id, currency, per_delivery, per_km, per_shift_hour, urgency_bonus, sla_penalty, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (tenant_id) DO UPDATE SET currency = $2, per_delivery = $3, per_km = $4, per_shift_hour = $5,
+			urgency_bonus = $6, sla_penalty = $7, updated_at = NOW()`,
+		r.TenantID, r.Currency, r.PerDelivery, r.PerKm, r.PerShiftHour, r.UrgencyBonus, r.SLAPenalty)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// Shift — смена курьера; EndedAt == nil — смена открыта.
+type Shift struct {
+	ID        int64      `json:"id"`
+	CourierID string     `json:"courier_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// POST /couriers/:id/shift/start — курьер выходит на смену.
+func startShiftHandler(c *gin.Context) {
+	id, _, tenantID, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	s := Shift{CourierID: id}
+	err := db.QueryRow(`
+		INSERT INTO courier_shifts (courier_id, tenant_id, started_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (courier_id) WHERE ended_at IS NULL DO NOTHING
+		RETURNING id, started_at`, id, tenantID).Scan(&s.ID, &s.StartedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Смена уже открыта"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// POST /couriers/:id/shift/end — курьер уходит со смены.
+func endShiftHandler(c *gin.Context) {
+	id, _, _, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	s := Shift{CourierID: id}
+	err := db.QueryRow(`UPDATE courier_shifts SET ended_at = NOW() WHERE courier_id = $1 AND ended_at IS NULL
+		RETURNING id, started_at, ended_at`, id).Scan(&s.ID, &s.StartedAt, &s.EndedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Смена не открыта"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// completedDelivery — доставленный курьером заказ для расчёта заработка.
+type completedDelivery struct {
+	OrderID     string
+	DeliveredAt time.Time
+	Urgency     int
+	Km          float64 // 0, если у заказа нет координат
+	SLABreached bool
+}
+
+// EarningLine — начисление за доставку.
+type EarningLine struct {
+	OrderID      string    `json:"order_id"`
+	DeliveredAt  time.Time `json:"delivered_at"`
+	Km           float64   `json:"km"`
+	Express      bool      `json:"express"`
+	SLABreached  bool      `json:"sla_breached"`
+	Base         float64   `json:"base"`
+	Distance     float64   `json:"distance"`
+	UrgencyBonus float64   `json:"urgency_bonus"`
+	Penalty      float64   `json:"penalty"`
+	Total        float64   `json:"total"`
+}
+
+// CourierEarnings — заработок курьера за период.
+type CourierEarnings struct {
+	CourierID   string        `json:"courier_id"`
+	CourierName string        `json:"courier_name"`
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Rules       PayRules      `json:"rules"`
+	Deliveries  []EarningLine `json:"deliveries"`
+	ShiftHours  float64       `json:"shift_hours"`
+	ShiftPay    float64       `json:"shift_pay"`
+	DeliveryPay float64       `json:"delivery_pay"` // ставка и километраж
+	Bonuses     float64       `json:"bonuses"`
+	Penalties   float64       `json:"penalties"`
+	Total       float64       `json:"total"`
+}
+
+// shiftHours — часы на сменах в пределах [from, to); открытая смена считается до now.
+func shiftHours(shifts []Shift, from, to, now time.Time) float64 {
+	var d time.Duration
+	for _, s := range shifts {
+		start, end := s.StartedAt, now
+		if s.EndedAt != nil {
+			end = *s.EndedAt
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			d += end.Sub(start)
+		}
+	}
+	return math.Round(d.Hours()*100) / 100
+}
+
+// calculateEarnings применяет правила оплаты к доставкам и сменам курьера.
+func calculateEarnings(rules PayRules, deliveries []completedDelivery, hours float64) CourierEarnings {
+	e := CourierEarnings{Rules: rules, Deliveries: []EarningLine{}, ShiftHours: hours}
+	for _, d := range deliveries {
+		l := EarningLine{
+			OrderID:     d.OrderID,
+			DeliveredAt: d.DeliveredAt,
+			Km:          math.Round(d.Km*10) / 10,
+			Express:     d.Urgency == 2,
+			SLABreached: d.SLABreached,
+			Base:        rules.PerDelivery,
+		}
+		l.Distance = roundMoney(l.Km * rules.PerKm)
+		if l.Express {
+			l.UrgencyBonus = rules.UrgencyBonus
+		}
+		if l.SLABreached {
+			l.Penalty = rules.SLAPenalty
+		}
+		l.Total = roundMoney(l.Base + l.Distance + l.UrgencyBonus - l.Penalty)
+		e.Deliveries = append(e.Deliveries, l)
+		e.DeliveryPay = roundMoney(e.DeliveryPay + l.Base + l.Distance)
+		e.Bonuses = roundMoney(e.Bonuses + l.UrgencyBonus)
+		e.Penalties = roundMoney(e.Penalties + l.Penalty)
+	}
+	e.ShiftPay = roundMoney(hours * rules.PerShiftHour)
+	e.Total = roundMoney(e.DeliveryPay + e.Bonuses - e.Penalties + e.ShiftPay)
+	return e
+}
+
+// loadCompletedDeliveries — заказы, врученные курьером в [from, to). Просрочка — по sla_status
+// или по времени вручения позже due_at (если проверка SLA не успела отметить заказ).
+func loadCompletedDeliveries(courierID string, from, to time.Time) ([]completedDelivery, error) {
+	rows, err := db.Query(`SELECT id, completed_at, urgency, from_lat, from_lng, to_lat, to_lng, due_at, COALESCE(sla_status, '')
+		FROM orders WHERE courier_id = $1 AND completed_at >= $2 AND completed_at < $3
+		ORDER BY completed_at, id`, courierID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []completedDelivery
+	for rows.Next() {
+		var d completedDelivery
+		var fromLat, fromLng, toLat, toLng *float64
+		var dueAt *time.Time
+		var sla string
+		if err := rows.Scan(&d.OrderID, &d.DeliveredAt, &d.Urgency, &fromLat, &fromLng, &toLat, &toLng, &dueAt, &sla); err != nil {
+			return nil, err
+		}
+		if fromLat != nil && fromLng != nil && toLat != nil && toLng != nil {
+			d.Km = haversineDistance(*fromLat, *fromLng, *toLat, *toLng) * roadFactor
+		}
+		d.SLABreached = sla == slaStatusBreached || (dueAt != nil && d.DeliveredAt.After(*dueAt))
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// loadShifts — смены курьера, пересекающиеся с [from, to).
+func loadShifts(courierID string, from, to time.Time) ([]Shift, error) {
+	rows, err := db.Query(`SELECT id, started_at, ended_at FROM courier_shifts
+		WHERE courier_id = $1 AND started_at < $3 AND (ended_at IS NULL OR ended_at > $2)
+		ORDER BY started_at`, courierID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Shift
+	for rows.Next() {
+		s := Shift{CourierID: courierID}
+		if err := rows.Scan(&s.ID, &s.StartedAt, &s.EndedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// GET /couriers/:id/earnings?from=YYYY-MM-DD&to=YYYY-MM-DD&format=pdf|csv — заработок курьера за период
+// (to включительно); pdf и csv — расчётный листок с начислениями по каждой доставке.
+func getCourierEarningsHandler(c *gin.Context) {
+	id, name, tenantID, ok := loadAccessibleCourier(c)
+	if !ok {
+		return
+	}
+	from, err := time.ParseInLocation("2006-01-02", c.Query("from"), time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from: ожидается YYYY-MM-DD"})
+		return
+	}
+	last, err := time.ParseInLocation("2006-01-02", c.Query("to"), time.Local)
+	if err != nil || last.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to: ожидается YYYY-MM-DD не раньше from"})
+		return
+	}
+	to := last.AddDate(0, 0, 1)
+
+	rules, err := loadPayRules(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	deliveries, err := loadCompletedDeliveries(id, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	shifts, err := loadShifts(id, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	e := calculateEarnings(rules, deliveries, shiftHours(shifts, from, to, time.Now()))
+	e.CourierID, e.CourierName, e.From, e.To = id, name, from.Format("2006-01-02"), last.Format("2006-01-02")
+	writeEarnings(c, e)
+}
+
+func writeEarnings(c *gin.Context, e CourierEarnings) {
+	filename := fmt.Sprintf("payslip_%s_%s_%s", e.CourierID, e.From, e.To)
+	switch c.Query("format") {
+	case "pdf":
+		pdf := payslipPDF(e)
+		if pdf.Err() {
+			log.Printf("Внутренняя ошибка генерации PDF: %v", pdf.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка PDF"})
+			return
+		}
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", "inline; filename="+filename+".pdf")
+		if err := pdf.Output(c.Writer); err != nil {
+			log.Printf("Ошибка вывода PDF: %v", err)
+		}
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		if err := writePayslipCSV(c.Writer, e); err != nil {
+			log.Printf("Ошибка вывода CSV: %v", err)
+		}
+	default:
+		c.JSON(http.StatusOK, e)
+	}
+}
+
+// writePayslipCSV — строка на каждую доставку, затем оплата смен и итог.
+func writePayslipCSV(w io.Writer, e CourierEarnings) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"type", "order_id", "delivered_at", "km", "express", "sla_breached", "base", "distance", "urgency_bonus", "penalty", "total", "currency"})
+	for _, l := range e.Deliveries {
+		_ = cw.Write([]string{"delivery", csvText(l.OrderID), l.DeliveredAt.Format("2006-01-02 15:04"), strconv.FormatFloat(l.Km, 'f', 1, 64),
+			strconv.FormatBool(l.Express), strconv.FormatBool(l.SLABreached),
+			money(l.Base), money(l.Distance), money(l.UrgencyBonus), money(l.Penalty), money(l.Total), e.Rules.Currency})
+	}
+	_ = cw.Write([]string{"shifts", "", "", "", "", "", "", "", "", "", money(e.ShiftPay), e.Rules.Currency})
+	_ = cw.Write([]string{"total", "", "", "", "", "", money(e.DeliveryPay), "", money(e.Bonuses), money(e.Penalties), money(e.Total), e.Rules.Currency})
+	cw.Flush()
+	return cw.Error()
+}
+
+func payslipPDF(e CourierEarnings) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 20, 15)
+	pdf.AddPage()
+	pdf.AddUTF8Font("DejaVu", "", "assets/fonts/DejaVuSerif.ttf")
+	pdf.SetFont("DejaVu", "", 16)
+	pdf.Cell(0, 10, "Расчётный листок курьера")
+	pdf.Ln(12)
+	pdf.SetFont("DejaVu", "", 12)
+	pdf.Cell(0, 8, fmt.Sprintf("Курьер: %s (%s)", e.CourierName, e.CourierID))
+	pdf.Ln(8)
+	pdf.Cell(0, 8, fmt.Sprintf("Период: %s – %s", e.From, e.To))
+	pdf.Ln(8)
+	r := e.Rules
+	pdf.Cell(0, 8, fmt.Sprintf("Ставки, %s: доставка %s, км %s, час смены %s, экспресс +%s, просрочка −%s",
+		r.Currency, money(r.PerDelivery), money(r.PerKm), money(r.PerShiftHour), money(r.UrgencyBonus), money(r.SLAPenalty)))
+	pdf.Ln(12)
+
+	pdf.SetFont("DejaVu", "", 9)
+	row := func(cols []string, widths []float64) {
+		for i, col := range cols {
+			pdf.CellFormat(widths[i], 7, col, "1", 0, "", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	widths := []float64{36, 28, 14, 22, 22, 20, 18, 20}
+	row([]string{"Заказ", "Вручён", "Км", "Ставка", "За км", "Экспресс", "Штраф", "Итого"}, widths)
+	for _, l := range e.Deliveries {
+		row([]string{l.OrderID, l.DeliveredAt.Format("02.01 15:04"), strconv.FormatFloat(l.Km, 'f', 1, 64),
+			money(l.Base), money(l.Distance), money(l.UrgencyBonus), money(l.Penalty), money(l.Total)}, widths)
+	}
+	pdf.Ln(6)
+
+	pdf.SetFont("DejaVu", "", 12)
+	for _, t := range [][2]string{
+		{"Доставки (ставка и км):", money(e.DeliveryPay)},
+		{fmt.Sprintf("Смены, %.2f ч:", e.ShiftHours), money(e.ShiftPay)},
+		{"Надбавки за экспресс:", money(e.Bonuses)},
+		{"Удержания за просрочку:", "−" + money(e.Penalties)},
+		{"К выплате:", money(e.Total) + " " + r.Currency},
+	} {
+		pdf.CellFormat(140, 8, t[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 8, t[1], "", 1, "R", false, 0, "")
+	}
+	return pdf
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestShiftHours(t *testing.T) {
+	day := time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local)
+	at := func(h int) time.Time { return day.Add(time.Duration(h) * time.Hour) }
+	end := func(h int) *time.Time { v := at(h); return &v }
+	shifts := []Shift{
+		{StartedAt: at(-2), EndedAt: end(4)}, // ночная смена — в период попадают 4 ч
+		{StartedAt: at(9), EndedAt: end(17)},
+		{StartedAt: at(20)}, // открыта
+	}
+	assert.Equal(t, 4+8+2.5, shiftHours(shifts, day, day.AddDate(0, 0, 1), at(22).Add(30*time.Minute)))
+	assert.Equal(t, 0.0, shiftHours(shifts, at(5), at(9), at(22)))
+}
+
+func TestCalculateEarnings(t *testing.T) {
+	rules := PayRules{Currency: "RUB", PerDelivery: 150, PerKm: 12, PerShiftHour: 250, UrgencyBonus: 100, SLAPenalty: 200}
+	deliveries := []completedDelivery{
+		{OrderID: "o-1", Urgency: 1, Km: 5.04},
+		{OrderID: "o-2", Urgency: 2, Km: 10},
+		{OrderID: "o-3", Urgency: 1, SLABreached: true},
+	}
+	e := calculateEarnings(rules, deliveries, 8)
+	assert.Len(t, e.Deliveries, 3)
+	assert.Equal(t, 5.0, e.Deliveries[0].Km, "км округляются до десятых")
+	assert.Equal(t, 210.0, e.Deliveries[0].Total)
+	assert.Equal(t, 370.0, e.Deliveries[1].Total)
+	assert.Equal(t, -50.0, e.Deliveries[2].Total)
+	assert.Equal(t, 150*3+60+120.0, e.DeliveryPay)
+	assert.Equal(t, 100.0, e.Bonuses)
+	assert.Equal(t, 200.0, e.Penalties)
+	assert.Equal(t, 2000.0, e.ShiftPay)
+	assert.Equal(t, 630+100-200+2000.0, e.Total)
+
+	assert.NoError(t, rules.validate())
+	rules.SLAPenalty = -1
+	assert.Error(t, rules.validate())
+}
+
+func TestPayslipExports(t *testing.T) {
+	rules := defaultPayRules
+	e := calculateEarnings(rules, []completedDelivery{
+		{OrderID: "o-1", DeliveredAt: time.Date(2026, 9, 1, 12, 30, 0, 0, time.Local), Urgency: 2, Km: 3},
+	}, 1.5)
+	e.CourierID, e.CourierName, e.From, e.To = "c-1", "Иван", "2026-09-01", "2026-09-30"
+
+	var out bytes.Buffer
+	assert.NoError(t, writePayslipCSV(&out, e))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "delivery,o-1,2026-09-01 12:30,3.0,true,false,150.00,36.00,100.00,0.00,286.00,RUB", lines[1])
+	assert.Equal(t, "shifts,,,,,,,,,,375.00,RUB", lines[2])
+	assert.Equal(t, "total,,,,,,186.00,,100.00,0.00,661.00,RUB", lines[3])
+
+	out.Reset()
+	assert.NoError(t, payslipPDF(e).Output(&out))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF")))
+}
+
+func TestGetCourierEarningsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.GET("/couriers/:id/earnings", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"})
+	}, getCourierEarningsHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/couriers/c-2/earnings?from=2026-09-01&to=2026-09-30", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "чужой заработок")
+
+	due := time.Date(2026, 9, 2, 12, 0, 0, 0, time.Local)
+	lat1, lng1, lat2, lng2 := 55.75, 37.61, 55.76, 37.62
+	mock.ExpectQuery("SELECT name, tenant_id FROM couriers").WithArgs("c-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id"}).AddRow("Иван", "shop-1"))
+	mock.ExpectQuery("FROM courier_pay_rules").WithArgs("shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "per_delivery", "per_km", "per_shift_hour", "urgency_bonus", "sla_penalty"}).
+			AddRow("RUB", 100.0, 0.0, 200.0, 50.0, 80.0))
+	mock.ExpectQuery("FROM orders WHERE courier_id = \\$1 AND completed_at >= \\$2").
+		WithArgs("c-1", time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "completed_at", "urgency", "from_lat", "from_lng", "to_lat", "to_lng", "due_at", "sla_status"}).
+			AddRow("o-1", due.Add(time.Hour), 1, lat1, lng1, lat2, lng2, due, "").
+			AddRow("o-2", due, 2, nil, nil, nil, nil, nil, ""))
+	mock.ExpectQuery("FROM courier_shifts").WithArgs("c-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "started_at", "ended_at"}).
+			AddRow(1, due.Add(-4*time.Hour), due.Add(2*time.Hour)))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/couriers/c-1/earnings?from=2026-09-01&to=2026-09-30", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var e CourierEarnings
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, "2026-09-30", e.To)
+	assert.True(t, e.Deliveries[0].SLABreached, "вручён позже due_at")
+	assert.Greater(t, e.Deliveries[0].Km, 0.0)
+	assert.Equal(t, 6.0, e.ShiftHours)
+	assert.Equal(t, 200+50-80+1200.0, e.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartShiftHandlerAlreadyOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.POST("/couriers/:id/shift/start", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"})
+	}, startShiftHandler)
+
+	mock.ExpectQuery("SELECT name, tenant_id FROM couriers").WithArgs("c-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id"}).AddRow("Иван", "shop-1"))
+	mock.ExpectQuery("INSERT INTO courier_shifts").WithArgs("c-1", "shop-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "started_at"}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/couriers/c-1/shift/start", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
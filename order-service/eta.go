@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Скорости по умолчанию (км/ч) для типов транспорта, пока по ним мало истории доставок.
+var defaultSpeedKmh = map[string]float64{"foot": 5, "bike": 15, "car": 25}
+
+const (
+	fallbackSpeedKmh = 15.0 // тип транспорта не указан или неизвестен
+	// roadFactor — во сколько раз путь по улицам длиннее расстояния по прямой.
+	roadFactor = 1.3
+	// etaMinSamples — сколько доставок нужно, чтобы доверять калибровке по часу суток.
+	etaMinSamples = 5
+	// maxPlausibleSpeedKmh отсекает доставки с ошибочными координатами или временем.
+	maxPlausibleSpeedKmh      = 150.0
+	etaCalibrationInterval    = time.Hour
+	etaCalibrationHistoryDays = 90
+)
+
+// ETA — расчётное время прибытия курьера по заказу.
+type ETA struct {
+	OrderID     string    `json:"order_id"`
+	CourierID   string    `json:"courier_id"`
+	TenantID    string    `json:"tenant_id"`
+	ETA         time.Time `json:"eta"`
+	RemainingKm float64   `json:"remaining_km"`
+	SpeedKmh    float64   `json:"speed_kmh"`
+	VehicleType string    `json:"vehicle_type"`
+	ComputedAt  time.Time `json:"computed_at"`
+}
+
+// deliverySample — одна завершённая доставка для калибровки скоростей.
+type deliverySample struct {
+	Vehicle string
+	Start   time.Time
+	Km      float64
+	Hours   float64
+}
+
+// speedModel — средние скорости по типу транспорта и часу суток, откалиброванные по истории.
+type speedModel struct {
+	mu        sync.RWMutex
+	byHour    map[string][24]float64 // 0 — по этому часу мало данных
+	byVehicle map[string]float64
+}
+
+var etaSpeeds = &speedModel{}
+
+// speed возвращает скорость для типа транспорта в заданный час: калибровка по часу,
+// затем средняя по типу транспорта, затем значение по умолчанию.
+func (m *speedModel) speed(vehicle string, hour int) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if v := m.byHour[vehicle][hour]; v > 0 {
+		return v
+	}
+	if v := m.byVehicle[vehicle]; v > 0 {
+		return v
+	}
+	if v, ok := defaultSpeedKmh[vehicle]; ok {
+		return v
+	}
+	return fallbackSpeedKmh
+}
+
+func (m *speedModel) set(byHour map[string][24]float64, byVehicle map[string]float64) {
+	m.mu.Lock()
+	m.byHour, m.byVehicle = byHour, byVehicle
+	m.mu.Unlock()
+}
+
+// calibrateSpeeds усредняет скорости доставок по типу транспорта и часу начала.
+// Час учитывается, только если по нему набралось etaMinSamples доставок.
+func calibrateSpeeds(samples []deliverySample) (map[string][24]float64, map[string]float64) {
+	type acc struct {
+		sum float64
+		n   int
+	}
+	hourly := map[string]*[24]acc{}
+	total := map[string]*acc{}
+	for _, s := range samples {
+		if s.Hours <= 0 || s.Km <= 0 {
+			continue
+		}
+		v := s.Km / s.Hours
+		if v > maxPlausibleSpeedKmh {
+			continue
+		}
+		if hourly[s.Vehicle] == nil {
+			hourly[s.Vehicle], total[s.Vehicle] = &[24]acc{}, &acc{}
+		}
+		h := &hourly[s.Vehicle][s.Start.Hour()]
+		h.sum, h.n = h.sum+v, h.n+1
+		total[s.Vehicle].sum, total[s.Vehicle].n = total[s.Vehicle].sum+v, total[s.Vehicle].n+1
+	}
+
+	byHour := map[string][24]float64{}
+	byVehicle := map[string]float64{}
+	for vehicle, hours := range hourly {
+		var speeds [24]float64
+		for h, a := range hours {
+			if a.n >= etaMinSamples {
+				speeds[h] = a.sum / float64(a.n)
+			}
+		}
+		byHour[vehicle] = speeds
+		if t := total[vehicle]; t.n >= etaMinSamples {
+			byVehicle[vehicle] = t.sum / float64(t.n)
+		}
+	}
+	return byHour, byVehicle
+}
+
+// loadDeliverySamples выбирает завершённые доставки с координатами за последние etaCalibrationHistoryDays дней.
+// Длительность — completed_at - created_at, как в аналитике; расстояние — от адреса забора до адреса доставки.
+func loadDeliverySamples() ([]deliverySample, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT COALESCE(c.vehicle_type, ''), o.created_at, o.completed_at, o.from_lat, o.from_lng, o.to_lat, o.to_lng
+		FROM orders o JOIN couriers c ON c.id = o.courier_id
+		WHERE o.completed_at IS NOT NULL
+		  AND o.from_lat IS NOT NULL AND o.from_lng IS NOT NULL AND o.to_lat IS NOT NULL AND o.to_lng IS NOT NULL
+		  AND o.completed_at > NOW() - INTERVAL '%d days'`, etaCalibrationHistoryDays))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var samples []deliverySample
+	for rows.Next() {
+		var s deliverySample
+		var completedAt time.Time
+		var fromLat, fromLng, toLat, toLng float64
+		if err := rows.Scan(&s.Vehicle, &s.Start, &completedAt, &fromLat, &fromLng, &toLat, &toLng); err != nil {
+			return nil, err
+		}
+		s.Km = haversineDistance(fromLat, fromLng, toLat, toLng) * roadFactor
+		s.Hours = completedAt.Sub(s.Start).Hours()
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// startETACalibration пересчитывает скорости при старте и затем раз в etaCalibrationInterval.
+func startETACalibration() {
+	go func() {
+		for {
+			samples, err := loadDeliverySamples()
+			if err != nil {
+				log.Printf("Калибровка ETA: %v", err)
+			} else {
+				etaSpeeds.set(calibrateSpeeds(samples))
+				log.Printf("Калибровка ETA: учтено доставок %d", len(samples))
+			}
+			time.Sleep(etaCalibrationInterval)
+		}
+	}()
+}
+
+// haversineDistance вычисляет расстояние в километрах между двумя координатами.
+func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// validateCoordinates проверяет, что координаты адресов заданы парами и в допустимых пределах.
+func validateCoordinates(o Order) error {
+	for _, p := range []struct {
+		name     string
+		lat, lng *float64
+	}{{"from", o.FromLat, o.FromLng}, {"to", o.ToLat, o.ToLng}} {
+		if p.lat == nil && p.lng == nil {
+			continue
+		}
+		if p.lat == nil || p.lng == nil {
+			return fmt.Errorf("координаты %s_lat и %s_lng задаются вместе", p.name, p.name)
+		}
+		if math.Abs(*p.lat) > 90 || math.Abs(*p.lng) > 180 {
+			return fmt.Errorf("некорректные координаты %s_lat/%s_lng", p.name, p.name)
+		}
+	}
+	return nil
+}
+
+// estimateETA считает оставшийся путь от позиции курьера до адреса доставки и время прибытия
+// со скоростью для его транспорта в текущий час.
+func estimateETA(o Order, pos Position, vehicle string, now time.Time) ETA {
+	km := haversineDistance(pos.Latitude, pos.Longitude, *o.ToLat, *o.ToLng) * roadFactor
+	speed := etaSpeeds.speed(vehicle, now.Hour())
+	return ETA{
+		OrderID:     o.ID,
+		CourierID:   o.CourierID,
+		TenantID:    o.TenantID,
+		ETA:         now.Add(time.Duration(km / speed * float64(time.Hour))).Truncate(time.Second),
+		RemainingKm: math.Round(km*100) / 100,
+		SpeedKmh:    math.Round(speed*10) / 10,
+		VehicleType: vehicle,
+		ComputedAt:  now,
+	}
+}
+
+// courierVehicle возвращает тип транспорта курьера ("" — не указан).
+func courierVehicle(courierID string) (string, error) {
+	var vehicle string
+	err := db.QueryRow("SELECT COALESCE(vehicle_type, '') FROM couriers WHERE id = $1", courierID).Scan(&vehicle)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return vehicle, err
+}
+
+// saveETA сохраняет расчёт в заказе: его показывают список заказов и публичная страница отслеживания.
+func saveETA(e ETA) error {
+	_, err := db.Exec("UPDATE orders SET eta = $1 WHERE id = $2", e.ETA, e.OrderID)
+	return err
+}
+
+// GET /orders/:id/eta — ETA по текущей позиции курьера из tracking-service.
+func getOrderETAHandler(c *gin.Context) {
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	switch {
+	case o.CompletedAt.Valid:
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ уже доставлен"})
+		return
+	case o.CourierID == "":
+		c.JSON(http.StatusConflict, gin.H{"error": "Курьер ещё не назначен"})
+		return
+	case o.ToLat == nil || o.ToLng == nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "У заказа нет координат адреса доставки"})
+		return
+	}
+	pos, err := courierPosition(o.CourierID, o.TenantID)
+	if err != nil || pos == nil {
+		log.Printf("getOrderETAHandler: координаты курьера %s недоступны: %v", o.CourierID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Нет координат курьера"})
+		return
+	}
+	vehicle, err := courierVehicle(o.CourierID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	e := estimateETA(o, *pos, vehicle, time.Now())
+	if err := saveETA(e); err != nil {
+		log.Printf("getOrderETAHandler: не удалось сохранить ETA заказа %s: %v", o.ID, err)
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// POST /couriers/:id/position — tracking-service сообщает новую позицию курьера;
+// ETA пересчитывается по всем его незавершённым заказам организации tenant_id (координаты хранятся
+// по организации, приславшей их) и возвращается для рассылки по WebSocket.
+func courierPositionHandler(c *gin.Context) {
+	var body struct {
+		Position
+		TenantID string `json:"tenant_id"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	pos := body.Position
+	tenantID := auth.RecordTenant(auth.CurrentClaims(c), body.TenantID)
+	courierID := c.Param("id")
+	vehicle, err := courierVehicle(courierID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := db.Query("SELECT "+orderColumns+` FROM orders
+		WHERE courier_id = $1 AND tenant_id = $2 AND completed_at IS NULL AND to_lat IS NOT NULL AND to_lng IS NOT NULL`, courierID, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+
+	now := time.Now()
+	etas := []ETA{}
+	for _, o := range orders {
+		e := estimateETA(o, pos, vehicle, now)
+		if err := saveETA(e); err != nil {
+			log.Printf("courierPositionHandler: не удалось сохранить ETA заказа %s: %v", o.ID, err)
+			continue
+		}
+		etas = append(etas, e)
+	}
+	c.JSON(http.StatusOK, etas)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestHaversineDistance(t *testing.T) {
+	// Москва — Санкт-Петербург, около 634 км
+	assert.InDelta(t, 634, haversineDistance(55.7558, 37.6173, 59.9343, 30.3351), 5)
+}
+
+func TestCalibrateSpeeds(t *testing.T) {
+	morning := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	evening := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	var samples []deliverySample
+	for i := 0; i < etaMinSamples; i++ {
+		samples = append(samples, deliverySample{Vehicle: "car", Start: morning, Km: 30, Hours: 1})
+	}
+	samples = append(samples,
+		deliverySample{Vehicle: "car", Start: evening, Km: 10, Hours: 1},  // по вечеру мало данных
+		deliverySample{Vehicle: "car", Start: morning, Km: 500, Hours: 1}, // неправдоподобная скорость
+		deliverySample{Vehicle: "car", Start: morning, Km: 5, Hours: 0},   // нулевая длительность
+		deliverySample{Vehicle: "bike", Start: morning, Km: 12, Hours: 1},
+	)
+
+	byHour, byVehicle := calibrateSpeeds(samples)
+	assert.Equal(t, 30.0, byHour["car"][9])
+	assert.Zero(t, byHour["car"][18])
+	assert.InDelta(t, (30.0*etaMinSamples+10)/(etaMinSamples+1), byVehicle["car"], 0.001)
+	assert.Zero(t, byVehicle["bike"], "одной доставки недостаточно")
+
+	m := &speedModel{}
+	m.set(byHour, byVehicle)
+	assert.Equal(t, 30.0, m.speed("car", 9))
+	assert.Equal(t, byVehicle["car"], m.speed("car", 18))
+	assert.Equal(t, defaultSpeedKmh["bike"], m.speed("bike", 9))
+	assert.Equal(t, fallbackSpeedKmh, m.speed("", 9))
+}
+
+func TestEstimateETA(t *testing.T) {
+	defer func(m *speedModel) { etaSpeeds = m }(etaSpeeds)
+	etaSpeeds = &speedModel{}
+
+	toLat, toLng := 55.7558, 37.6173
+	o := Order{ID: "1", CourierID: "c-1", TenantID: "shop-1", ToLat: &toLat, ToLng: &toLng}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// ~1.11 км к югу по прямой
+	pos := Position{Latitude: 55.7458, Longitude: 37.6173}
+
+	e := estimateETA(o, pos, "foot", now)
+	km := haversineDistance(pos.Latitude, pos.Longitude, toLat, toLng) * roadFactor
+	assert.InDelta(t, km, e.RemainingKm, 0.01)
+	assert.Equal(t, defaultSpeedKmh["foot"], e.SpeedKmh)
+	assert.WithinDuration(t, now.Add(time.Duration(km/defaultSpeedKmh["foot"]*float64(time.Hour))), e.ETA, time.Second)
+	assert.Equal(t, "shop-1", e.TenantID)
+
+	car := estimateETA(o, pos, "car", now)
+	assert.True(t, car.ETA.Before(e.ETA), "машина приедет раньше пешего курьера")
+}
+
+func TestValidateCoordinates(t *testing.T) {
+	lat, lng, bad := 55.75, 37.61, 200.0
+	assert.NoError(t, validateCoordinates(Order{}))
+	assert.NoError(t, validateCoordinates(Order{FromLat: &lat, FromLng: &lng, ToLat: &lat, ToLng: &lng}))
+	assert.Error(t, validateCoordinates(Order{ToLat: &lat}))
+	assert.Error(t, validateCoordinates(Order{ToLat: &lat, ToLng: &bad}))
+}
+
+func TestCourierPositionHandlerScopesTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.POST("/couriers/:id/position", func(c *gin.Context) { c.Set("claims", &auth.Claims{Role: auth.RoleService}) }, courierPositionHandler)
+
+	// координаты, присланные другой организацией под тем же courier_id, не трогают ETA заказов владельца
+	mock.ExpectQuery("SELECT COALESCE\\(vehicle_type, ''\\) FROM couriers").WithArgs("c-1").
+		WillReturnRows(sqlmock.NewRows([]string{"vehicle_type"}).AddRow("car"))
+	mock.ExpectQuery("FROM orders\\s+WHERE courier_id = \\$1 AND tenant_id = \\$2").WithArgs("c-1", "shop-2").
+		WillReturnRows(sqlmock.NewRows(testOrderColumns))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/couriers/c-1/position",
+		strings.NewReader(`{"tenant_id":"shop-2","latitude":55.75,"longitude":37.61}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `[]`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
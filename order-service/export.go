@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportFlushEvery — через сколько строк CSV и JSON Lines сбрасываются клиенту.
+const exportFlushEvery = 500
+
+// exportRow — заказ с данными для выгрузки.
+type exportRow struct {
+	Order
+	CourierName string
+}
+
+// deliveryMinutes — время от создания заказа до вручения.
+func (r exportRow) deliveryMinutes() any {
+	if !r.CompletedAt.Valid {
+		return nil
+	}
+	return int(r.CompletedAt.Time.Sub(r.CreatedAt).Minutes())
+}
+
+func optTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// exportColumn — столбец выгрузки: key — имя поля в JSON Lines, ru/en — заголовки CSV и XLSX.
+type exportColumn struct {
+	key, ru, en string
+	value       func(r exportRow) any // nil — пустая ячейка
+}
+
+var exportColumns = []exportColumn{
+	{"id", "Номер", "Order ID", func(r exportRow) any { return r.ID }},
+	{"client_ref", "Номер клиента", "Client ref", func(r exportRow) any { return r.ClientRef }},
+	{"status", "Статус", "Status", func(r exportRow) any { return r.Status }},
+	{"sender_name", "Отправитель", "Sender", func(r exportRow) any { return r.SenderName }},
+	{"recipient_name", "Получатель", "Recipient", func(r exportRow) any { return r.RecipientName }},
+	{"address_from", "Откуда", "From", func(r exportRow) any { return r.AddressFrom }},
+	{"address_to", "Куда", "To", func(r exportRow) any { return r.AddressTo }},
+	{"weight", "Вес, кг", "Weight, kg", func(r exportRow) any { return r.Weight }},
+	{"urgency", "Срочность", "Urgency", func(r exportRow) any { return r.Urgency }},
+	{"courier_id", "ID курьера", "Courier ID", func(r exportRow) any { return r.CourierID }},
+	{"courier_name", "Курьер", "Courier", func(r exportRow) any { return r.CourierName }},
+	{"created_at", "Создан", "Created at", func(r exportRow) any { return r.CreatedAt }},
+	{"window_start", "Окно с", "Window start", func(r exportRow) any { return optTime(r.WindowStart) }},
+	{"window_end", "Окно до", "Window end", func(r exportRow) any { return optTime(r.WindowEnd) }},
+	{"due_at", "Срок", "Due at", func(r exportRow) any { return optTime(r.DueAt) }},
+	{"completed_at", "Доставлен", "Delivered at", func(r exportRow) any {
+		if !r.CompletedAt.Valid {
+			return nil
+		}
+		return r.CompletedAt.Time
+	}},
+	{"delivery_minutes", "Время доставки, мин", "Delivery time, min", func(r exportRow) any { return r.deliveryMinutes() }},
+	{"sla_status", "SLA", "SLA", func(r exportRow) any { return r.SLAStatus }},
+	{"failed_attempts", "Неудачных попыток", "Failed attempts", func(r exportRow) any { return r.FailedAttempts }},
+	{"payment_method", "Оплата", "Payment method", func(r exportRow) any { return r.PaymentMethod }},
+	{"payment_status", "Статус оплаты", "Payment status", func(r exportRow) any { return r.PaymentStatus }},
+	{"price", "Стоимость", "Price", func(r exportRow) any {
+		if r.Price == 0 {
+			return nil
+		}
+		return r.Price
+	}},
+	{"price_currency", "Валюта", "Currency", func(r exportRow) any { return r.PriceCurrency }},
+	{"cod_amount", "Наложенный платёж", "COD amount", func(r exportRow) any {
+		if r.CODAmount == 0 {
+			return nil
+		}
+		return r.CODAmount
+	}},
+}
+
+// exportLang — язык заголовков: ?lang=ru|en, иначе по Accept-Language, по умолчанию русский.
+func exportLang(c *gin.Context) string {
+	lang := c.Query("lang")
+	if lang == "" && strings.HasPrefix(strings.ToLower(c.GetHeader("Accept-Language")), "en") {
+		lang = "en"
+	}
+	if lang == "en" {
+		return "en"
+	}
+	return "ru"
+}
+
+func exportHeaders(lang string) []string {
+	h := make([]string, len(exportColumns))
+	for i, col := range exportColumns {
+		h[i] = col.ru
+		if lang == "en" {
+			h[i] = col.en
+		}
+	}
+	return h
+}
+
+// exportText — значение ячейки CSV/XLSX.
+func exportText(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// csvText защищает текстовую ячейку CSV от выполнения как формулы: Excel и LibreOffice считают формулой
+// значение, начинающееся с =, +, -, @ (а также табуляции и перевода строки), поэтому перед ним ставится '.
+// Только для текстовых значений — числа пишутся как есть.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportScanner дочитывает имя курьера после колонок orderColumns.
+type exportScanner struct {
+	rows        *sql.Rows
+	courierName *string
+}
+
+func (s exportScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.courierName)...)
+}
+
+// orderExporter пишет выгрузку построчно.
+type orderExporter interface {
+	header(lang string) error
+	row(r exportRow) error
+	close() error
+}
+
+type csvExporter struct {
+	cw *csv.Writer
+	w  http.Flusher
+	n  int
+}
+
+func (e *csvExporter) header(lang string) error { return e.cw.Write(exportHeaders(lang)) }
+
+func (e *csvExporter) row(r exportRow) error {
+	cells := make([]string, len(exportColumns))
+	for i, col := range exportColumns {
+		v := col.value(r)
+		if s, ok := v.(string); ok {
+			cells[i] = csvText(s)
+		} else {
+			cells[i] = exportText(v)
+		}
+	}
+	if e.n++; e.n%exportFlushEvery == 0 {
+		e.cw.Flush()
+		e.w.Flush()
+	}
+	return e.cw.Write(cells)
+}
+
+func (e *csvExporter) close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// jsonlExporter — объект на строку, ключи не переводятся.
+type jsonlExporter struct {
+	enc *json.Encoder
+	w   http.Flusher
+	n   int
+}
+
+func (e *jsonlExporter) header(string) error { return nil }
+
+func (e *jsonlExporter) row(r exportRow) error {
+	obj := make(map[string]any, len(exportColumns))
+	for _, col := range exportColumns {
+		obj[col.key] = col.value(r)
+	}
+	if e.n++; e.n%exportFlushEvery == 0 {
+		e.w.Flush()
+	}
+	return e.enc.Encode(obj)
+}
+
+func (e *jsonlExporter) close() error { return nil }
+
+// xlsxExporter пишет лист потоково: excelize держит в памяти только буфер и сбрасывает строки во временный файл.
+type xlsxExporter struct {
+	f   *excelize.File
+	sw  *excelize.StreamWriter
+	out io.Writer
+	n   int
+}
+
+func newXLSXExporter(out io.Writer) (*xlsxExporter, error) {
+	f := excelize.NewFile()
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxExporter{f: f, sw: sw, out: out}, nil
+}
+
+func (e *xlsxExporter) write(cells []any) error {
+	e.n++
+	cell, err := excelize.CoordinatesToCellName(1, e.n)
+	if err != nil {
+		return err
+	}
+	return e.sw.SetRow(cell, cells)
+}
+
+func (e *xlsxExporter) header(lang string) error {
+	h := exportHeaders(lang)
+	cells := make([]any, len(h))
+	for i, v := range h {
+		cells[i] = v
+	}
+	return e.write(cells)
+}
+
+func (e *xlsxExporter) row(r exportRow) error {
+	cells := make([]any, len(exportColumns))
+	for i, col := range exportColumns {
+		switch v := col.value(r).(type) {
+		case float64, int:
+			cells[i] = v
+		default:
+			cells[i] = exportText(v)
+		}
+	}
+	return e.write(cells)
+}
+
+func (e *xlsxExporter) close() error {
+	defer e.f.Close()
+	if err := e.sw.Flush(); err != nil {
+		return err
+	}
+	return e.f.Write(e.out)
+}
+
+// GET /orders/export?format=csv|xlsx|jsonl&lang=ru|en — выгрузка заказов с теми же фильтрами, что и GET /orders.
+// Строки читаются из БД курсором и сразу пишутся в ответ.
+func exportOrdersHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "jsonl":
+		contentType = "application/x-ndjson"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format: csv, xlsx или jsonl"})
+		return
+	}
+	where, args, err := orderListFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := db.Query(`SELECT `+orderColumns+`, COALESCE((SELECT name FROM couriers WHERE couriers.id = orders.courier_id), '')
+		FROM orders WHERE `+where+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var e orderExporter
+	switch format {
+	case "csv":
+		e = &csvExporter{cw: csv.NewWriter(c.Writer), w: c.Writer}
+	case "jsonl":
+		e = &jsonlExporter{enc: json.NewEncoder(c.Writer), w: c.Writer}
+	case "xlsx":
+		if e, err = newXLSXExporter(c.Writer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=orders_%s.%s", time.Now().Format("20060102"), format))
+	c.Status(http.StatusOK)
+
+	// После начала ответа код статуса уже не изменить: ошибки только в лог, выгрузка обрывается.
+	if err := e.header(exportLang(c)); err != nil {
+		log.Printf("Ошибка выгрузки заказов: %v", err)
+		return
+	}
+	for rows.Next() {
+		var r exportRow
+		if r.Order, err = scanOrder(exportScanner{rows: rows, courierName: &r.CourierName}); err == nil {
+			err = e.row(r)
+		}
+		if err != nil {
+			log.Printf("Ошибка выгрузки заказов: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка выгрузки заказов: %v", err)
+		return
+	}
+	if err := e.close(); err != nil {
+		log.Printf("Ошибка выгрузки заказов: %v", err)
+	}
+}
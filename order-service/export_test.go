@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestOrderListFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/orders?status=новый&from=2026-09-01&to=2026-09-30", nil)
+	c.Set("claims", &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"})
+
+	where, args, err := orderListFilter(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant_id = $1 AND courier_id = $2 AND status = $3 AND created_at >= $4 AND created_at < $5", where)
+	assert.Equal(t, []any{"shop-1", "c-1", "новый", time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)}, args, "to включительно")
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/orders?from=01.09.2026", nil)
+	c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher})
+	_, _, err = orderListFilter(c)
+	assert.EqualError(t, err, "from: ожидается YYYY-MM-DD")
+}
+
+func exportTestRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	t.Cleanup(func() { mockDB.Close() })
+	db = mockDB
+
+	r := gin.New()
+	r.GET("/orders/export", func(c *gin.Context) { c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}) }, exportOrdersHandler)
+	return r, mock
+}
+
+func expectExportRows(mock sqlmock.Sqlmock) {
+	created := time.Date(2026, 9, 1, 10, 0, 0, 0, time.Local)
+	mock.ExpectQuery("FROM orders WHERE tenant_id = \\$1 AND TRUE ORDER BY created_at, id").WithArgs("shop-1").
+		WillReturnRows(sqlmock.NewRows(append(testOrderColumns, "courier_name")).
+			AddRow("o-1", "Склад", "Иван", "Москва", "Тверь", "завершён", created, created.Add(90*time.Minute),
+				2.5, 1.0, 1.0, 1.0, 2, "c-1", nil, nil, "", "", "", "", "shop-1", "", nil, nil, nil, nil, nil, nil, "", "",
+				0, "", "", paymentPrepaid, 0.0, 500.0, "RUB", paymentCaptured, "A-1", "Пётр").
+			AddRow("o-2", "Склад", "Анна", "Москва", "Тула", "новый", created, nil,
+				1.0, 1.0, 1.0, 1.0, 1, "", nil, nil, "", "", "", "", "shop-1", "", nil, nil, nil, nil, nil, nil, "", "",
+				0, "", "", paymentCOD, 1200.0, 0.0, "", paymentUnpaid, "", ""))
+}
+
+func TestExportOrdersCSV(t *testing.T) {
+	r, mock := exportTestRouter(t)
+	expectExportRows(mock)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/export?format=csv&lang=en", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "Order ID,Client ref,Status,"))
+	assert.Equal(t, "o-1,A-1,завершён,Склад,Иван,Москва,Тверь,2.5,2,c-1,Пётр,2026-09-01 10:00:00,,,,2026-09-01 11:30:00,90,,0,prepaid,captured,500,RUB,", lines[1])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/export?format=pdf", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCSVText(t *testing.T) {
+	for _, s := range []string{"=HYPERLINK(\"http://evil\")", "+7 999", "-1+2", "@SUM(A1)", "\tx"} {
+		assert.Equal(t, "'"+s, csvText(s), s)
+	}
+	assert.Equal(t, "Иван", csvText("Иван"))
+	assert.Equal(t, "", csvText(""))
+
+	r, mock := exportTestRouter(t)
+	created := time.Date(2026, 9, 1, 10, 0, 0, 0, time.Local)
+	mock.ExpectQuery("FROM orders WHERE").WithArgs("shop-1").
+		WillReturnRows(sqlmock.NewRows(append(testOrderColumns, "courier_name")).
+			AddRow("o-1", "=cmd|' /C calc'!A0", "Иван", "Москва", "Тверь", "новый", created, nil,
+				1.0, 1.0, 1.0, 1.0, 1, "", nil, nil, "", "", "", "", "shop-1", "", nil, nil, nil, nil, nil, nil, "", "",
+				0, "", "", paymentCOD, 0.0, 0.0, "", paymentUnpaid, "", ""))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/export?format=csv", nil))
+	assert.Contains(t, w.Body.String(), ",'=cmd|' /C calc'!A0,", "имя отправителя не выполняется как формула")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportOrdersJSONL(t *testing.T) {
+	r, mock := exportTestRouter(t)
+	expectExportRows(mock)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/export?format=jsonl", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2, "без строки заголовков")
+
+	var row map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &row))
+	assert.Equal(t, "o-2", row["id"])
+	assert.Equal(t, 1200.0, row["cod_amount"])
+	assert.Nil(t, row["completed_at"])
+	assert.Nil(t, row["price"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportOrdersXLSX(t *testing.T) {
+	r, mock := exportTestRouter(t)
+	expectExportRows(mock)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/export?format=xlsx", nil)
+	req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	f, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	assert.NoError(t, err)
+	defer f.Close()
+	rows, err := f.GetRows("Sheet1")
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "Номер", rows[0][0])
+	assert.Equal(t, "Пётр", rows[1][10])
+	assert.Equal(t, "500", rows[1][21])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
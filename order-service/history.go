@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// События истории заказа.
+const (
+	historyPINFailed   = "pin_failed"   // неверный PIN получателя
+	historyPINLocked   = "pin_locked"   // попытки ввода PIN исчерпаны
+	historyPINOverride = "pin_override" // диспетчер подтвердил вручение без PIN
+	historyDelivered   = "delivered"    // заказ завершён
+)
+
+// HistoryEntry — запись журнала действий по заказу.
+type HistoryEntry struct {
+	Event     string    `json:"event"`
+	Actor     string    `json:"actor"` // логин пользователя из JWT
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordOrderHistory добавляет запись в журнал заказа.
+func recordOrderHistory(orderID, event, actor, details string) error {
+	return addOrderHistory(db, orderID, event, actor, details)
+}
+
+// addOrderHistory добавляет запись в журнал заказа через exec — в транзакции вместе с изменением, которое она описывает.
+func addOrderHistory(exec execer, orderID, event, actor, details string) error {
+	_, err := exec.Exec(`INSERT INTO order_history (order_id, event, actor, details, created_at) VALUES ($1, $2, $3, NULLIF($4, ''), NOW())`,
+		orderID, event, actor, details)
+	return err
+}
+
+// GET /orders/:id/history — журнал действий по заказу в хронологическом порядке.
+func getOrderHistoryHandler(c *gin.Context) {
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	rows, err := db.Query(`SELECT event, actor, COALESCE(details, ''), created_at FROM order_history WHERE order_id = $1 ORDER BY created_at, id`, o.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var h HistoryEntry
+		if err := rows.Scan(&h.Event, &h.Actor, &h.Details, &h.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		history = append(history, h)
+	}
+	c.JSON(http.StatusOK, history)
+}
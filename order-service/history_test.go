@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestGetOrderHistoryHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.GET("/orders/:id/history", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+	}, getOrderHistoryHandler)
+
+	expectTestOrder(mock, "o-1", "shop-1")
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM order_history WHERE order_id").WithArgs("o-1").WillReturnRows(
+		sqlmock.NewRows([]string{"event", "actor", "details", "created_at"}).
+			AddRow(historyPINFailed, "courier1", "попытка 1 из 5", at).
+			AddRow(historyPINOverride, "disp1", "подтвердил по телефону", at.Add(time.Minute)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/o-1/history", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var history []HistoryEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history, 2)
+	assert.Equal(t, "disp1", history[1].Actor)
+
+	expectTestOrder(mock, "o-1", "shop-2")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/o-1/history", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "чужая организация")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
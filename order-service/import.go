@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"github.com/xuri/excelize/v2"
+)
+
+// maxImportFileSize — предельный размер загружаемого файла.
+const maxImportFileSize = 10 << 20
+
+var (
+	// importSyncRows — файл до стольких строк обрабатывается в запросе, больший — фоновым заданием (env ORDER_IMPORT_SYNC_ROWS).
+	importSyncRows = 200
+	// importMaxRows — предельное число строк в файле (env ORDER_IMPORT_MAX_ROWS).
+	importMaxRows = 10000
+)
+
+// initImportConfig читает настройки импорта из переменных окружения.
+func initImportConfig() {
+	if n, err := strconv.Atoi(os.Getenv("ORDER_IMPORT_SYNC_ROWS")); err == nil && n >= 0 {
+		importSyncRows = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("ORDER_IMPORT_MAX_ROWS")); err == nil && n > 0 {
+		importMaxRows = n
+	}
+}
+
+// importFields — поля заказа, загружаемые из файла; по умолчанию заголовок столбца совпадает с именем поля.
+var importFields = []string{
+	"client_ref", "sender_name", "recipient_name", "address_from", "address_to", "email", "recipient_email",
+	"weight", "length", "width", "height", "urgency", "window_start", "window_end", "promo_code", "currency",
+	"from_lat", "from_lng", "to_lat", "to_lng", "payment_method", "cod_amount",
+}
+
+// importRequired — поля, без которых строка не импортируется.
+var importRequired = []string{"sender_name", "recipient_name", "address_from", "address_to"}
+
+// Статусы задания импорта.
+const (
+	importRunning = "running"
+	importDone    = "done"
+)
+
+// Результат обработки строки файла.
+const (
+	rowCreated = "created"
+	rowSkipped = "skipped" // заказ с таким client_ref уже есть
+	rowValid   = "valid"   // dry-run: строка была бы импортирована
+	rowError   = "error"
+)
+
+// ImportRowResult — результат по строке файла; Line — номер строки в файле, заголовок — строка 1.
+type ImportRowResult struct {
+	Line      int    `json:"line"`
+	ClientRef string `json:"client_ref,omitempty"`
+	OrderID   string `json:"order_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ImportJob — задание импорта заказов из файла.
+type ImportJob struct {
+	ID         int64             `json:"id"`
+	TenantID   string            `json:"tenant_id"`
+	CreatedBy  string            `json:"created_by"`
+	role       string            // роль автора: от неё зависят допустимые способы оплаты
+	FileName   string            `json:"file_name"`
+	DryRun     bool              `json:"dry_run"`
+	Status     string            `json:"status"`
+	TotalRows  int               `json:"total_rows"`
+	Processed  int               `json:"processed"`
+	Created    int               `json:"created"` // в dry-run — сколько было бы создано
+	Skipped    int               `json:"skipped"`
+	Failed     int               `json:"failed"`
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Rows       []ImportRowResult `json:"rows"`
+}
+
+func (j *ImportJob) add(r ImportRowResult) {
+	j.Rows = append(j.Rows, r)
+	j.Processed++
+	switch r.Status {
+	case rowCreated, rowValid:
+		j.Created++
+	case rowSkipped:
+		j.Skipped++
+	case rowError:
+		j.Failed++
+	}
+}
+
+// readImportTable читает CSV (разделитель «,» или «;») или первый (или указанный) лист XLSX.
+func readImportTable(name string, data []byte, sheet string) ([][]string, error) {
+	if strings.EqualFold(filepath.Ext(name), ".xlsx") || bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("файл XLSX не читается: %w", err)
+		}
+		defer f.Close()
+		if sheet == "" {
+			sheet = f.GetSheetName(0)
+		}
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, fmt.Errorf("лист %q: %w", sheet, err)
+		}
+		return rows, nil
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	r := csv.NewReader(bytes.NewReader(data))
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		r.Comma = ';'
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("файл CSV не читается: %w", err)
+	}
+	return rows, nil
+}
+
+// importColumns сопоставляет поля заказа столбцам по заголовку. mapping — поле → заголовок столбца;
+// поля без сопоставления ищутся по собственному имени, регистр и пробелы по краям не важны.
+func importColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	known := map[string]bool{}
+	for _, f := range importFields {
+		known[f] = true
+	}
+	for f := range mapping {
+		if !known[f] {
+			return nil, fmt.Errorf("mapping: неизвестное поле %q", f)
+		}
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	cols := map[string]int{}
+	for _, f := range importFields {
+		name := f
+		if m, ok := mapping[f]; ok {
+			name = m
+		}
+		if i, ok := index[strings.ToLower(strings.TrimSpace(name))]; ok {
+			cols[f] = i
+		} else if _, mapped := mapping[f]; mapped {
+			return nil, fmt.Errorf("столбец %q для поля %s не найден", name, f)
+		}
+	}
+	for _, f := range importRequired {
+		if _, ok := cols[f]; !ok {
+			return nil, fmt.Errorf("нет обязательного столбца %s", f)
+		}
+	}
+	return cols, nil
+}
+
+// parseImportFloat понимает и десятичную запятую.
+func parseImportFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(s, ",", "."), 64)
+}
+
+// parseImportTime — RFC3339 или «YYYY-MM-DD HH:MM» по местному времени.
+func parseImportTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+}
+
+// importOrder собирает заказ из строки файла.
+func importOrder(row []string, cols map[string]int) (Order, error) {
+	o := Order{Urgency: 1}
+	for _, f := range importFields {
+		i, ok := cols[f]
+		if !ok || i >= len(row) {
+			continue
+		}
+		v := strings.TrimSpace(row[i])
+		if v == "" {
+			continue
+		}
+		var err error
+		switch f {
+		case "client_ref":
+			o.ClientRef = v
+		case "sender_name":
+			o.SenderName = v
+		case "recipient_name":
+			o.RecipientName = v
+		case "address_from":
+			o.AddressFrom = v
+		case "address_to":
+			o.AddressTo = v
+		case "email":
+			o.Email = v
+		case "recipient_email":
+			o.RecipientEmail = v
+		case "weight":
+			o.Weight, err = parseImportFloat(v)
+		case "length":
+			o.Length, err = parseImportFloat(v)
+		case "width":
+			o.Width, err = parseImportFloat(v)
+		case "height":
+			o.Height, err = parseImportFloat(v)
+		case "urgency":
+			o.Urgency, err = strconv.Atoi(v)
+			if err == nil && o.Urgency != 1 && o.Urgency != 2 {
+				err = errors.New("1 или 2")
+			}
+		case "window_start", "window_end":
+			var t time.Time
+			if t, err = parseImportTime(v); err == nil {
+				if f == "window_start" {
+					o.WindowStart = &t
+				} else {
+					o.WindowEnd = &t
+				}
+			}
+		case "promo_code":
+			o.PromoCode = v
+		case "currency":
+			o.Currency = v
+		case "from_lat", "from_lng", "to_lat", "to_lng":
+			var x float64
+			if x, err = parseImportFloat(v); err == nil {
+				switch f {
+				case "from_lat":
+					o.FromLat = &x
+				case "from_lng":
+					o.FromLng = &x
+				case "to_lat":
+					o.ToLat = &x
+				case "to_lng":
+					o.ToLng = &x
+				}
+			}
+		case "payment_method":
+			o.PaymentMethod = v
+		case "cod_amount":
+			o.CODAmount, err = parseImportFloat(v)
+		}
+		if err != nil {
+			return o, fmt.Errorf("%s: некорректное значение %q", f, v)
+		}
+	}
+	for _, f := range importRequired {
+		if i := cols[f]; i >= len(row) || strings.TrimSpace(row[i]) == "" {
+			return o, fmt.Errorf("%s: обязательное поле", f)
+		}
+	}
+	if o.Weight < 0 || o.Length < 0 || o.Width < 0 || o.Height < 0 {
+		return o, errors.New("вес и габариты не могут быть отрицательными")
+	}
+	return o, nil
+}
+
+// blankRow — пустая строка таблицы (в выгрузках из Excel они бывают в конце).
+func blankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// importRow проверяет строку и (кроме dry-run) создаёт заказ. ID заказа — IMP<задание>-<строка>.
+func importRow(job *ImportJob, line int, row []string, cols map[string]int, seen map[string]int) ImportRowResult {
+	res := ImportRowResult{Line: line, Status: rowError}
+	o, err := importOrder(row, cols)
+	res.ClientRef = o.ClientRef
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if o.ClientRef != "" {
+		if prev, dup := seen[o.ClientRef]; dup {
+			res.Error = fmt.Sprintf("client_ref повторяет строку %d", prev)
+			return res
+		}
+		seen[o.ClientRef] = line
+	}
+	// Уже импортированная строка пропускается до проверок: при повторной загрузке файла окно доставки
+	// могло пройти, а лимит промокода — исчерпаться этим же заказом.
+	existing, err := orderByClientRef(job.TenantID, o.ClientRef)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if existing != "" {
+		res.Status, res.OrderID = rowSkipped, existing
+		return res
+	}
+	o.TenantID, o.CreatedBy = job.TenantID, job.CreatedBy
+	if _, err := validateNewOrder(&o, job.role); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if job.DryRun {
+		res.Status = rowValid
+		return res
+	}
+	o.ID = fmt.Sprintf("IMP%d-%d", job.ID, line)
+	if _, err := createOrder(o, job.CreatedBy); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Status, res.OrderID = rowCreated, o.ID
+	return res
+}
+
+// importProgressEvery — как часто фоновое задание сохраняет прогресс.
+const importProgressEvery = 100
+
+// runImport обрабатывает строки файла (без заголовка) и сохраняет итог задания.
+func runImport(job *ImportJob, rows [][]string, cols map[string]int) {
+	seen := map[string]int{}
+	for i, row := range rows {
+		if blankRow(row) {
+			continue
+		}
+		job.add(importRow(job, i+2, row, cols, seen))
+		if job.Processed%importProgressEvery == 0 {
+			if err := saveImportJob(job); err != nil {
+				log.Printf("Ошибка сохранения прогресса импорта %d: %v", job.ID, err)
+			}
+		}
+	}
+	now := time.Now()
+	job.Status, job.FinishedAt = importDone, &now
+	if err := saveImportJob(job); err != nil {
+		log.Printf("Ошибка сохранения импорта %d: %v", job.ID, err)
+	}
+}
+
+func saveImportJob(job *ImportJob) error {
+	rows, err := json.Marshal(job.Rows)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE import_jobs SET status = $2, processed = $3, created = $4, skipped = $5, failed = $6,
+		results = $7, finished_at = $8 WHERE id = $1`,
+		job.ID, job.Status, job.Processed, job.Created, job.Skipped, job.Failed, string(rows), job.FinishedAt)
+	return err
+}
+
+// POST /orders/import — импорт заказов из CSV/XLSX (multipart: file, mapping — JSON «поле → заголовок»,
+// sheet, dry_run). Небольшой файл обрабатывается сразу (200), большой — фоновым заданием (202),
+// его статус — GET /orders/import/:id. Строки с уже известным client_ref пропускаются.
+func importOrdersHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужен файл file (CSV или XLSX)"})
+		return
+	}
+	if fh.Size > maxImportFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Файл больше 10 МБ"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mapping := map[string]string{}
+	if m := c.PostForm("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping: ожидается JSON-объект «поле → заголовок столбца»"})
+			return
+		}
+	}
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+
+	table, err := readImportTable(fh.Filename, data, c.PostForm("sheet"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(table) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "В файле нет строк с заказами"})
+		return
+	}
+	if len(table)-1 > importMaxRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Не больше %d строк в файле", importMaxRows)})
+		return
+	}
+	cols, err := importColumns(table[0], mapping)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := auth.CurrentClaims(c)
+	if _, ok := cols["client_ref"]; ok {
+		if err := checkClientRefRole(claims.Role, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	job := &ImportJob{
+		TenantID:  auth.RecordTenant(claims, c.PostForm("tenant_id")),
+		CreatedBy: claims.Subject,
+		role:      claims.Role,
+		FileName:  fh.Filename,
+		DryRun:    dryRun,
+		Status:    importRunning,
+		TotalRows: len(table) - 1,
+		CreatedAt: time.Now(),
+		Rows:      []ImportRowResult{},
+	}
+	err = db.QueryRow(`INSERT INTO import_jobs (tenant_id, created_by, file_name, dry_run, status, total_rows, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		job.TenantID, job.CreatedBy, job.FileName, job.DryRun, job.Status, job.TotalRows, job.CreatedAt).Scan(&job.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if job.TotalRows > importSyncRows {
+		go runImport(job, table[1:], cols)
+		c.JSON(http.StatusAccepted, gin.H{"id": job.ID, "status": job.Status, "total_rows": job.TotalRows})
+		return
+	}
+	runImport(job, table[1:], cols)
+	c.JSON(http.StatusOK, job)
+}
+
+// canReadImportJob — задание видят сотрудники его организации; клиент-физлицо — только своё
+// (клиенты делят организацию default).
+func canReadImportJob(claims *auth.Claims, j ImportJob) bool {
+	if !auth.SameTenant(claims, j.TenantID) {
+		return false
+	}
+	return claims.Role != auth.RoleCustomer || j.CreatedBy == claims.Subject
+}
+
+// GET /orders/import/:id — статус и результаты задания импорта.
+func getImportJobHandler(c *gin.Context) {
+	var j ImportJob
+	var rows string
+	err := db.QueryRow(`SELECT id, tenant_id, created_by, file_name, dry_run, status, total_rows, processed, created, skipped, failed,
+		COALESCE(results, '[]'), created_at, finished_at FROM import_jobs WHERE id = $1`, c.Param("id")).
+		Scan(&j.ID, &j.TenantID, &j.CreatedBy, &j.FileName, &j.DryRun, &j.Status, &j.TotalRows, &j.Processed, &j.Created, &j.Skipped,
+			&j.Failed, &rows, &j.CreatedAt, &j.FinishedAt)
+	if err == sql.ErrNoRows || (err == nil && !canReadImportJob(auth.CurrentClaims(c), j)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Задание импорта не найдено"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := json.Unmarshal([]byte(rows), &j.Rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
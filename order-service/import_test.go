@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestReadImportTable(t *testing.T) {
+	csvData := []byte("\xef\xbb\xbfОтправитель;Получатель;Вес\nООО Ромашка;Иван;\"1,5\"\n")
+	rows, err := readImportTable("orders.csv", csvData, "")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"Отправитель", "Получатель", "Вес"}, {"ООО Ромашка", "Иван", "1,5"}}, rows)
+
+	f := excelize.NewFile()
+	assert.NoError(t, f.SetSheetRow("Sheet1", "A1", &[]any{"sender_name", "weight"}))
+	assert.NoError(t, f.SetSheetRow("Sheet1", "A2", &[]any{"Склад", 2.5}))
+	var buf bytes.Buffer
+	assert.NoError(t, f.Write(&buf))
+	rows, err = readImportTable("orders.bin", buf.Bytes(), "")
+	assert.NoError(t, err, "XLSX распознаётся по содержимому")
+	assert.Equal(t, [][]string{{"sender_name", "weight"}, {"Склад", "2.5"}}, rows)
+
+	_, err = readImportTable("orders.xlsx", buf.Bytes(), "Лист2")
+	assert.Error(t, err)
+}
+
+func TestImportColumnsAndOrder(t *testing.T) {
+	header := []string{"Номер", "Отправитель", "Получатель", "Откуда", "Куда", "Вес", "urgency", "window_start", "window_end"}
+	mapping := map[string]string{"client_ref": "номер", "sender_name": "Отправитель", "recipient_name": "Получатель",
+		"address_from": "Откуда", "address_to": "Куда", "weight": "Вес"}
+	cols, err := importColumns(header, mapping)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cols["client_ref"], "заголовок сравнивается без учёта регистра")
+	assert.Equal(t, 6, cols["urgency"], "без mapping — по имени поля")
+
+	_, err = importColumns(header, map[string]string{"price": "Цена"})
+	assert.Error(t, err, "неизвестное поле")
+	_, err = importColumns([]string{"sender_name"}, nil)
+	assert.EqualError(t, err, "нет обязательного столбца recipient_name")
+
+	o, err := importOrder([]string{"A-1", "Склад", "Иван", "Москва", "Тверь", "2,5", "2", "2026-09-01 10:00", "2026-09-01 12:00"}, cols)
+	assert.NoError(t, err)
+	assert.Equal(t, "A-1", o.ClientRef)
+	assert.Equal(t, 2.5, o.Weight)
+	assert.Equal(t, 2, o.Urgency)
+	assert.Equal(t, 10, o.WindowStart.Hour())
+
+	_, err = importOrder([]string{"A-2", "Склад", "Иван", "Москва", "Тверь", "тяжёлый"}, cols)
+	assert.EqualError(t, err, `weight: некорректное значение "тяжёлый"`)
+	_, err = importOrder([]string{"A-3", "Склад", "", "Москва", "Тверь"}, cols)
+	assert.EqualError(t, err, "recipient_name: обязательное поле")
+}
+
+func TestImportOrdersHandlerDryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	claims := &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}
+	claims.Subject = "ops"
+	r := gin.New()
+	r.POST("/orders/import", func(c *gin.Context) { c.Set("claims", claims) }, importOrdersHandler)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "orders.csv")
+	fw.Write([]byte("client_ref,sender_name,recipient_name,address_from,address_to,payment_method\n" +
+		"A-1,Склад,Иван,Москва,Тверь,\n" +
+		"A-2,Склад,,Москва,Тверь,\n" +
+		",,,,,\n" +
+		"A-1,Склад,Пётр,Москва,Тула,\n" +
+		"A-4,Склад,Анна,Москва,Тула,card\n" +
+		"A-5,Склад,Олег,Москва,Тула,invoice\n"))
+	mw.WriteField("dry_run", "true")
+	mw.Close()
+
+	mock.ExpectQuery("INSERT INTO import_jobs").WithArgs("shop-1", "ops", "orders.csv", true, importRunning, 6, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery("SELECT id FROM orders WHERE tenant_id = \\$1 AND client_ref = \\$2").WithArgs("shop-1", "A-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT id FROM orders WHERE tenant_id = \\$1 AND client_ref = \\$2").WithArgs("shop-1", "A-4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// уже импортированная строка пропускается без проверок (договора на оплату по счёту больше нет)
+	mock.ExpectQuery("SELECT id FROM orders WHERE tenant_id = \\$1 AND client_ref = \\$2").WithArgs("shop-1", "A-5").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("IMP3-2"))
+	mock.ExpectExec("UPDATE import_jobs SET status").WithArgs(int64(9), importDone, 5, 1, 1, 3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/orders/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var job ImportJob
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, []ImportRowResult{
+		{Line: 2, ClientRef: "A-1", Status: rowValid},
+		{Line: 3, ClientRef: "A-2", Status: rowError, Error: "recipient_name: обязательное поле"},
+		{Line: 5, ClientRef: "A-1", Status: rowError, Error: "client_ref повторяет строку 2"},
+		{Line: 6, ClientRef: "A-4", Status: rowError, Error: "payment_method: prepaid, cash_on_delivery или invoice"},
+		{Line: 7, ClientRef: "A-5", OrderID: "IMP3-2", Status: rowSkipped},
+	}, job.Rows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClientRefNotForCustomers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	claims := &auth.Claims{Role: auth.RoleCustomer, TenantID: auth.DefaultTenant}
+	claims.Subject = "bob"
+	r := gin.New()
+	r.POST("/orders/import", func(c *gin.Context) { c.Set("claims", claims) }, importOrdersHandler)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "orders.csv")
+	fw.Write([]byte("client_ref,sender_name,recipient_name,address_from,address_to\nA-1,Склад,Иван,Москва,Тверь\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/orders/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "номер чужого заказа не ищется")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	status, err := validateNewOrder(&Order{ClientRef: "A-1"}, auth.RoleCustomer)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Error(t, err)
+	assert.NoError(t, checkClientRefRole(auth.RoleDispatcher, true))
+	assert.NoError(t, checkClientRefRole(auth.RoleCustomer, false))
+}
+
+func TestCanReadImportJob(t *testing.T) {
+	job := ImportJob{TenantID: auth.DefaultTenant, CreatedBy: "alice"}
+	alice := &auth.Claims{Role: auth.RoleCustomer, TenantID: auth.DefaultTenant}
+	alice.Subject = "alice"
+	bob := &auth.Claims{Role: auth.RoleCustomer, TenantID: auth.DefaultTenant}
+	bob.Subject = "bob"
+
+	assert.True(t, canReadImportJob(alice, job))
+	assert.False(t, canReadImportJob(bob, job), "чужое задание клиента той же организации")
+	assert.True(t, canReadImportJob(&auth.Claims{Role: auth.RoleDispatcher, TenantID: auth.DefaultTenant}, job))
+	assert.False(t, canReadImportJob(&auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}, job))
+}
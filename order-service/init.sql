@@ -32,3 +32,239 @@ ALTER TABLE orders
   ADD COLUMN IF NOT EXISTS courier_id VARCHAR(50)
     REFERENCES couriers(id);
   ALTER COLUMN courier_id DROP NOT NULL;
+
+-- 3. Окно доставки, выбранное клиентом
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS window_start TIMESTAMP,
+  ADD COLUMN IF NOT EXISTS window_end TIMESTAMP;
+
+-- 4. Промокод, указанный при оформлении заказа
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS promo_code VARCHAR(64);
+
+-- 5. Валюта, в которой клиент получает расчёт стоимости
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+
+-- 6. Владелец заказа (логин из JWT) — клиент видит только свои заказы
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS created_by VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_orders_created_by ON orders (created_by);
+
+-- 7. Заказы B2B-клиентов, созданные по API-ключу, принадлежат организации
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS client_org VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_orders_client_org ON orders (client_org);
+
+-- Организация (арендатор) — владелец заказов и курьеров; существующие данные относятся к default.
+-- Заказы по API-ключу переносятся из client_org в разделе 21
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE couriers
+  ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_orders_tenant ON orders (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_couriers_tenant ON couriers (tenant_id);
+
+-- 8. Токен публичной ссылки отслеживания для получателя; существующим заказам выдаётся случайный
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS tracking_token VARCHAR(64);
+UPDATE orders SET tracking_token = REPLACE(gen_random_uuid()::text, '-', '') WHERE tracking_token IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_tracking_token ON orders (tracking_token);
+
+-- 9. Координаты адресов (для ETA и калибровки скоростей) и последний расчёт ETA
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS from_lat DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS from_lng DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS to_lat DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS to_lng DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS eta TIMESTAMP;
+
+-- 10. SLA: обещанное время доставки и статус проверки сроков (at_risk / breached)
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS due_at TIMESTAMP,
+  ADD COLUMN IF NOT EXISTS sla_status VARCHAR(16);
+CREATE INDEX IF NOT EXISTS idx_orders_sla_open ON orders (due_at) WHERE completed_at IS NULL;
+
+-- 11. Подтверждение доставки: почта получателя, хеш PIN из письма и собранные курьером доказательства
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS recipient_email TEXT,
+  ADD COLUMN IF NOT EXISTS delivery_pin_hash VARCHAR(64);
+CREATE TABLE IF NOT EXISTS order_pod (
+  order_id VARCHAR(50) PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+  photo_key TEXT,
+  signature_key TEXT,
+  pin_confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+  collected_by VARCHAR(255) NOT NULL,
+  collected_at TIMESTAMP NOT NULL
+);
+
+-- 12. Проверка PIN получателя: счётчик неверных попыток и журнал действий по заказу
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS pin_attempts INT NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS order_history (
+  id BIGSERIAL PRIMARY KEY,
+  order_id VARCHAR(50) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  event VARCHAR(32) NOT NULL,
+  actor VARCHAR(255) NOT NULL,
+  details TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_order_history_order ON order_history (order_id, created_at);
+
+-- 13. Неудачные попытки доставки и возврат отправителю: возвратный заказ ссылается на исходный
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS failed_attempts INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS return_of VARCHAR(50) REFERENCES orders(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS return_order_id VARCHAR(50);
+
+-- 14. Оплата наличными при получении и касса курьера: полученные наложенные платежи и сдача наличных
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS payment_method VARCHAR(32) NOT NULL DEFAULT 'prepaid',
+  ADD COLUMN IF NOT EXISTS cod_amount NUMERIC(12, 2);
+CREATE TABLE IF NOT EXISTS courier_cash_ledger (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+  courier_id TEXT NOT NULL REFERENCES couriers(id),
+  order_id VARCHAR(50) REFERENCES orders(id) ON DELETE SET NULL,
+  entry_type VARCHAR(16) NOT NULL,
+  amount NUMERIC(12, 2) NOT NULL,
+  expected_amount NUMERIC(12, 2),
+  currency VARCHAR(3) NOT NULL,
+  recorded_by VARCHAR(255) NOT NULL,
+  comment TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_courier_cash_ledger_courier ON courier_cash_ledger (courier_id, created_at);
+
+-- 15. Предоплата: стоимость фиксируется при первой оплате, платежи шлюза и их статусы
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS price NUMERIC(12, 2),
+  ADD COLUMN IF NOT EXISTS price_currency VARCHAR(3),
+  ADD COLUMN IF NOT EXISTS payment_status VARCHAR(16);
+CREATE TABLE IF NOT EXISTS payments (
+  id BIGSERIAL PRIMARY KEY,
+  order_id VARCHAR(50) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+  provider VARCHAR(32) NOT NULL,
+  provider_ref VARCHAR(128) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  amount NUMERIC(12, 2) NOT NULL,
+  currency VARCHAR(3) NOT NULL,
+  message TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (provider, provider_ref)
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order ON payments (order_id, id);
+
+-- 16. Ежемесячные счета организациям: сквозная нумерация по годам, заказ попадает только в один счёт
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+  year INT PRIMARY KEY,
+  last_number INT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS invoices (
+  id BIGSERIAL PRIMARY KEY,
+  number VARCHAR(32) NOT NULL UNIQUE,
+  tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+  period VARCHAR(7) NOT NULL,
+  currency VARCHAR(3) NOT NULL,
+  subtotal NUMERIC(12, 2) NOT NULL,
+  tax_rate NUMERIC(5, 2) NOT NULL,
+  tax NUMERIC(12, 2) NOT NULL,
+  total NUMERIC(12, 2) NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'unpaid',
+  issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  due_at TIMESTAMP NOT NULL,
+  paid_at TIMESTAMP,
+  issued_by VARCHAR(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_invoices_tenant ON invoices (tenant_id, issued_at);
+CREATE TABLE IF NOT EXISTS invoice_lines (
+  invoice_id BIGINT NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+  order_id VARCHAR(50) NOT NULL UNIQUE,
+  description TEXT NOT NULL,
+  delivered_at TIMESTAMP NOT NULL,
+  amount NUMERIC(12, 2) NOT NULL,
+  tax NUMERIC(12, 2) NOT NULL,
+  total NUMERIC(12, 2) NOT NULL
+);
+
+-- 17. Заработок курьеров: правила оплаты организации и смены (открытая смена у курьера одна)
+CREATE TABLE IF NOT EXISTS courier_pay_rules (
+  tenant_id VARCHAR(64) PRIMARY KEY,
+  currency VARCHAR(3) NOT NULL,
+  per_delivery NUMERIC(12, 2) NOT NULL,
+  per_km NUMERIC(12, 2) NOT NULL,
+  per_shift_hour NUMERIC(12, 2) NOT NULL,
+  urgency_bonus NUMERIC(12, 2) NOT NULL,
+  sla_penalty NUMERIC(12, 2) NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS courier_shifts (
+  id BIGSERIAL PRIMARY KEY,
+  courier_id TEXT NOT NULL REFERENCES couriers(id) ON DELETE CASCADE,
+  tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+  started_at TIMESTAMP NOT NULL,
+  ended_at TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_courier_shifts_open ON courier_shifts (courier_id) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_courier_shifts_courier ON courier_shifts (courier_id, started_at);
+
+-- 18. Импорт заказов из файлов: внешний номер заказа клиента (идемпотентность) и задания импорта
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS client_ref VARCHAR(100);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_client_ref ON orders (tenant_id, client_ref) WHERE client_ref IS NOT NULL;
+CREATE TABLE IF NOT EXISTS import_jobs (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+  created_by VARCHAR(255) NOT NULL,
+  file_name TEXT NOT NULL,
+  dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+  status VARCHAR(16) NOT NULL,
+  total_rows INT NOT NULL,
+  processed INT NOT NULL DEFAULT 0,
+  created INT NOT NULL DEFAULT 0,
+  skipped INT NOT NULL DEFAULT 0,
+  failed INT NOT NULL DEFAULT 0,
+  results JSONB,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMP
+);
+
+-- 19. Договоры организаций на оплату по счёту: без договора payment_method=invoice не принимается
+CREATE TABLE IF NOT EXISTS tenant_billing (
+  tenant_id VARCHAR(64) PRIMARY KEY,
+  invoice_billing BOOLEAN NOT NULL DEFAULT FALSE,
+  contract VARCHAR(255),
+  updated_by VARCHAR(255) NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- 20. Окно доставки хранится с часовым поясом. Окна строятся в поясе SLOT_TIMEZONE delivery-service,
+-- а TIMESTAMP отбрасывал смещение при записи: в старых строках лежит время по этому поясу.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'orders' AND column_name = 'window_start' AND data_type = 'timestamp without time zone') THEN
+    ALTER TABLE orders
+      ALTER COLUMN window_start TYPE TIMESTAMPTZ USING window_start AT TIME ZONE 'Europe/Moscow',
+      ALTER COLUMN window_end TYPE TIMESTAMPTZ USING window_end AT TIME ZONE 'Europe/Moscow';
+  END IF;
+END $$;
+
+-- 21. Организация B2B-клиента из раздела 7 стала арендатором: заказы по API-ключу переносятся
+-- из client_org в tenant_id, колонка (и её индекс) удаляется
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'orders' AND column_name = 'client_org') THEN
+    UPDATE orders SET tenant_id = client_org WHERE client_org IS NOT NULL AND client_org <> '';
+    ALTER TABLE orders DROP COLUMN client_org;
+  END IF;
+END $$;
+
+-- 22. Расчёт стоимости целиком: скидка, базовая валюта тарифа и курс нужны аналитике для выручки курьера,
+-- которую order-service публикует после вручения (delivery_calculated)
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS price_discount NUMERIC(12, 2),
+  ADD COLUMN IF NOT EXISTS price_base_currency VARCHAR(3),
+  ADD COLUMN IF NOT EXISTS price_exchange_rate NUMERIC(18, 8);
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Статусы счёта.
+const (
+	invoiceUnpaid = "unpaid"
+	invoicePaid   = "paid"
+)
+
+var (
+	// invoiceTaxRate — ставка НДС в процентах, начисляется сверх стоимости доставки (env INVOICE_TAX_RATE).
+	invoiceTaxRate = 20.0
+	// invoiceDueDays — срок оплаты счёта в днях от выставления (env INVOICE_DUE_DAYS).
+	invoiceDueDays = 14
+	// invoiceSeller — поставщик в PDF счёта (env INVOICE_SELLER).
+	invoiceSeller = "Kirill Logistics"
+)
+
+// initInvoiceConfig читает настройки счетов из переменных окружения.
+func initInvoiceConfig() {
+	if v, err := strconv.ParseFloat(os.Getenv("INVOICE_TAX_RATE"), 64); err == nil && v >= 0 {
+		invoiceTaxRate = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("INVOICE_DUE_DAYS")); err == nil && n > 0 {
+		invoiceDueDays = n
+	}
+	if s := os.Getenv("INVOICE_SELLER"); s != "" {
+		invoiceSeller = s
+	}
+}
+
+// BillingAgreement — договор организации на оплату доставок по ежемесячному счёту.
+// Без него payment_method=invoice не принимается: заказ ушёл бы курьеру без оплаты.
+type BillingAgreement struct {
+	TenantID       string     `json:"tenant_id"`
+	InvoiceBilling bool       `json:"invoice_billing"`
+	Contract       string     `json:"contract,omitempty"` // номер и дата договора
+	UpdatedBy      string     `json:"updated_by,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// loadBillingAgreement возвращает договор организации; без записи — оплата по счёту выключена.
+func loadBillingAgreement(tenantID string) (BillingAgreement, error) {
+	a := BillingAgreement{TenantID: tenantID}
+	var updatedAt time.Time
+	err := db.QueryRow(`SELECT invoice_billing, COALESCE(contract, ''), updated_by, updated_at FROM tenant_billing WHERE tenant_id = $1`, tenantID).
+		Scan(&a.InvoiceBilling, &a.Contract, &a.UpdatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return a, nil
+	}
+	if err != nil {
+		return a, err
+	}
+	a.UpdatedAt = &updatedAt
+	return a, nil
+}
+
+// checkInvoicePayment — можно ли оформить заказ организации tenantID с оплатой по счёту от имени роли role.
+// Клиенту — никогда: счёт выставляется организации, а не её покупателям.
+func checkInvoicePayment(role, tenantID string) (int, error) {
+	if role == auth.RoleCustomer {
+		return http.StatusForbidden, errors.New("Оплата по счёту недоступна клиенту")
+	}
+	a, err := loadBillingAgreement(tenantID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !a.InvoiceBilling {
+		return http.StatusForbidden, errors.New("У организации нет договора на оплату по счёту")
+	}
+	return 0, nil
+}
+
+// GET /tenants/:id/billing — договор организации (своей или любой для администратора платформы).
+func getBillingAgreementHandler(c *gin.Context) {
+	if !auth.SameTenant(auth.CurrentClaims(c), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Организация не найдена"})
+		return
+	}
+	a, err := loadBillingAgreement(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// PUT /tenants/:id/billing — включить или выключить оплату по счёту; только администратор платформы.
+func setBillingAgreementHandler(c *gin.Context) {
+	var req struct {
+		InvoiceBilling bool   `json:"invoice_billing"`
+		Contract       string `json:"contract"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	req.Contract = strings.TrimSpace(req.Contract)
+	if req.InvoiceBilling && req.Contract == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "contract: укажите договор"})
+		return
+	}
+	a := BillingAgreement{TenantID: c.Param("id"), InvoiceBilling: req.InvoiceBilling, Contract: req.Contract,
+		UpdatedBy: auth.CurrentClaims(c).Subject}
+	var updatedAt time.Time
+	err := db.QueryRow(`
+		INSERT INTO tenant_billing (tenant_id, invoice_billing, contract, updated_by, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NOW())
+		ON CONFLICT (tenant_id) DO UPDATE SET invoice_billing = $2, contract = NULLIF($3, ''), updated_by = $4, updated_at = NOW()
+		RETURNING updated_at`, a.TenantID, a.InvoiceBilling, a.Contract, a.UpdatedBy).Scan(&updatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.UpdatedAt = &updatedAt
+	c.JSON(http.StatusOK, a)
+}
+
+// InvoiceLine — доставленный заказ в счёте.
+type InvoiceLine struct {
+	OrderID     string    `json:"order_id"`
+	Description string    `json:"description"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Amount      float64   `json:"amount"` // без НДС
+	Tax         float64   `json:"tax"`
+	Total       float64   `json:"total"`
+}
+
+// Invoice — счёт организации за месяц в одной валюте.
+type Invoice struct {
+	ID       int64      `json:"id"`
+	Number   string     `json:"number"` // YYYY-NNNNNN, сквозная нумерация в пределах года выставления
+	TenantID string     `json:"tenant_id"`
+	Period   string     `json:"period"` // YYYY-MM
+	Currency string     `json:"currency"`
+	Subtotal float64    `json:"subtotal"`
+	TaxRate  float64    `json:"tax_rate"`
+	Tax      float64    `json:"tax"`
+	Total    float64    `json:"total"`
+	Status   string     `json:"status"`
+	IssuedAt time.Time  `json:"issued_at"`
+	DueAt    time.Time  `json:"due_at"`
+	PaidAt   *time.Time `json:"paid_at,omitempty"`
+	Overdue  bool       `json:"overdue"`
+
+	Lines []InvoiceLine `json:"lines,omitempty"`
+}
+
+// buildInvoices группирует доставленные заказы по валюте стоимости и считает НДС построчно.
+func buildInvoices(tenantID, period string, orders []Order, taxRate float64) []Invoice {
+	byCurrency := map[string]*Invoice{}
+	var currencies []string
+	for _, o := range orders {
+		cur := cashCurrency(o.PriceCurrency)
+		inv, ok := byCurrency[cur]
+		if !ok {
+			inv = &Invoice{TenantID: tenantID, Period: period, Currency: cur, TaxRate: taxRate, Status: invoiceUnpaid}
+			byCurrency[cur] = inv
+			currencies = append(currencies, cur)
+		}
+		l := InvoiceLine{
+			OrderID:     o.ID,
+			Description: "Доставка: " + o.AddressFrom + " → " + o.AddressTo,
+			Amount:      roundMoney(o.Price),
+			Tax:         roundMoney(o.Price * taxRate / 100),
+		}
+		if o.CompletedAt.Valid {
+			l.DeliveredAt = o.CompletedAt.Time
+		}
+		l.Total = roundMoney(l.Amount + l.Tax)
+		inv.Lines = append(inv.Lines, l)
+		inv.Subtotal = roundMoney(inv.Subtotal + l.Amount)
+		inv.Tax = roundMoney(inv.Tax + l.Tax)
+		inv.Total = roundMoney(inv.Total + l.Total)
+	}
+	sort.Strings(currencies)
+	invoices := make([]Invoice, 0, len(currencies))
+	for _, cur := range currencies {
+		invoices = append(invoices, *byCurrency[cur])
+	}
+	return invoices
+}
+
+// invoiceNumber — номер счёта: год выставления и порядковый номер в нём.
+func invoiceNumber(year, seq int) string {
+	return fmt.Sprintf("%d-%06d", year, seq)
+}
+
+// nextInvoiceNumber выдаёт следующий номер в транзакции выставления счёта:
+// строка счётчика блокируется до commit, при откате номер не расходуется — нумерация без пропусков.
+func nextInvoiceNumber(tx *sql.Tx, year int) (string, error) {
+	var seq int
+	err := tx.QueryRow(`
+		INSERT INTO invoice_sequences (year, last_number) VALUES ($1, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`, year).Scan(&seq)
+	return invoiceNumber(year, seq), err
+}
+
+// parseInvoicePeriod разбирает месяц YYYY-MM и возвращает его границы [from, to).
+func parseInvoicePeriod(p string) (from, to time.Time, err error) {
+	from, err = time.ParseInLocation("2006-01", p, time.Local)
+	return from, from.AddDate(0, 1, 0), err
+}
+
+// uninvoicedOrders — заказы организации с оплатой по счёту, доставленные за период и ещё не вошедшие в счёт.
+func uninvoicedOrders(tenantID string, from, to time.Time) ([]Order, error) {
+	rows, err := db.Query(`SELECT `+orderColumns+` FROM orders
+		WHERE tenant_id = $1 AND payment_method = $2 AND completed_at >= $3 AND completed_at < $4
+		  AND NOT EXISTS (SELECT 1 FROM invoice_lines l WHERE l.order_id = orders.id)
+		ORDER BY completed_at, id`, tenantID, paymentInvoice, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// saveInvoice присваивает счёту номер и сохраняет его вместе со строками.
+func saveInvoice(tx *sql.Tx, inv *Invoice, issuedBy string) error {
+	number, err := nextInvoiceNumber(tx, inv.IssuedAt.Year())
+	if err != nil {
+		return err
+	}
+	inv.Number = number
+	err = tx.QueryRow(`
+		INSERT INTO invoices (number, tenant_id, period, currency, subtotal, tax_rate, tax, total, status, issued_at, due_at, issued_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+		inv.Number, inv.TenantID, inv.Period, inv.Currency, inv.Subtotal, inv.TaxRate, inv.Tax, inv.Total, inv.Status,
+		inv.IssuedAt, inv.DueAt, issuedBy).Scan(&inv.ID)
+	if err != nil {
+		return err
+	}
+	for _, l := range inv.Lines {
+		if _, err := tx.Exec(`
+			INSERT INTO invoice_lines (invoice_id, order_id, description, delivered_at, amount, tax, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			inv.ID, l.OrderID, l.Description, l.DeliveredAt, l.Amount, l.Tax, l.Total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const invoiceColumns = `id, number, tenant_id, period, currency, subtotal, tax_rate, tax, total, status, issued_at, due_at, paid_at`
+
+func scanInvoice(row rowScanner) (Invoice, error) {
+	var inv Invoice
+	err := row.Scan(&inv.ID, &inv.Number, &inv.TenantID, &inv.Period, &inv.Currency, &inv.Subtotal, &inv.TaxRate, &inv.Tax, &inv.Total,
+		&inv.Status, &inv.IssuedAt, &inv.DueAt, &inv.PaidAt)
+	inv.Overdue = inv.Status == invoiceUnpaid && time.Now().After(inv.DueAt)
+	return inv, err
+}
+
+// loadInvoice читает счёт со строками; чужой организации — как несуществующий.
+func loadInvoice(c *gin.Context) (Invoice, bool) {
+	inv, err := scanInvoice(db.QueryRow("SELECT "+invoiceColumns+" FROM invoices WHERE id = $1", c.Param("id")))
+	if err == sql.ErrNoRows || (err == nil && !auth.SameTenant(auth.CurrentClaims(c), inv.TenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Счёт не найден"})
+		return inv, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return inv, false
+	}
+	rows, err := db.Query(`SELECT order_id, description, delivered_at, amount, tax, total FROM invoice_lines
+		WHERE invoice_id = $1 ORDER BY delivered_at, order_id`, inv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return inv, false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l InvoiceLine
+		if err := rows.Scan(&l.OrderID, &l.Description, &l.DeliveredAt, &l.Amount, &l.Tax, &l.Total); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return inv, false
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+	return inv, true
+}
+
+// POST /invoices — счета организации за месяц по заказам с оплатой по счёту (по одному на валюту).
+// Заказы, доставленные позже или пропущенные, попадут в следующий счёт за тот же период.
+func createInvoicesHandler(c *gin.Context) {
+	var body struct {
+		TenantID string `json:"tenant_id"`
+		Period   string `json:"period"` // YYYY-MM
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+	from, to, err := parseInvoicePeriod(body.Period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period: ожидается YYYY-MM"})
+		return
+	}
+	now := time.Now()
+	if to.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Счёт выставляется после окончания месяца"})
+		return
+	}
+	claims := auth.CurrentClaims(c)
+	tenantID := auth.RecordTenant(claims, body.TenantID)
+
+	orders, err := uninvoicedOrders(tenantID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(orders) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Нет доставленных заказов для выставления счёта"})
+		return
+	}
+	// Стоимость заказов без предоплаты ещё не зафиксирована — считаем её по тарифу.
+	for i := range orders {
+		if err := ensureOrderPrice(&orders[i]); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Заказ %s: %v", orders[i].ID, err)})
+			return
+		}
+	}
+
+	invoices := buildInvoices(tenantID, body.Period, orders, invoiceTaxRate)
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	for i := range invoices {
+		invoices[i].IssuedAt = now
+		invoices[i].DueAt = now.AddDate(0, 0, invoiceDueDays)
+		if err := saveInvoice(tx, &invoices[i], claims.Subject); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, invoices)
+}
+
+// GET /invoices?status=&period=&format=csv — счета организации, новые первыми; CSV — сводная выписка.
+func listInvoicesHandler(c *gin.Context) {
+	tenant, args := auth.TenantScope(c, 1)
+	query := "SELECT " + invoiceColumns + " FROM invoices WHERE " + tenant
+	if s := c.Query("status"); s != "" {
+		if s != invoicePaid && s != invoiceUnpaid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status: paid или unpaid"})
+			return
+		}
+		args = append(args, s)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if p := c.Query("period"); p != "" {
+		args = append(args, p)
+		query += fmt.Sprintf(" AND period = $%d", len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY issued_at DESC, id DESC", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := []Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, inv)
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, list)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=invoices.csv")
+	if err := writeInvoicesCSV(c.Writer, list); err != nil {
+		log.Printf("Ошибка вывода CSV: %v", err)
+	}
+}
+
+// GET /invoices/:id?format=pdf|csv — счёт со строками.
+func getInvoiceHandler(c *gin.Context) {
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+	switch c.Query("format") {
+	case "pdf":
+		pdf := invoicePDF(inv)
+		if pdf.Err() {
+			log.Printf("Внутренняя ошибка генерации PDF: %v", pdf.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка PDF"})
+			return
+		}
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=invoice_%s.pdf", inv.Number))
+		if err := pdf.Output(c.Writer); err != nil {
+			log.Printf("Ошибка вывода PDF: %v", err)
+		}
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=invoice_%s.csv", inv.Number))
+		if err := writeInvoiceLinesCSV(c.Writer, inv); err != nil {
+			log.Printf("Ошибка вывода CSV: %v", err)
+		}
+	default:
+		c.JSON(http.StatusOK, inv)
+	}
+}
+
+// PUT /invoices/:id/status — отметка об оплате счёта (или её отмена).
+func setInvoiceStatusHandler(c *gin.Context) {
+	var body struct {
+		Status string     `json:"status"`
+		PaidAt *time.Time `json:"paid_at"` // дата поступления денег; по умолчанию — сейчас
+	}
+	if err := c.BindJSON(&body); err != nil || (body.Status != invoicePaid && body.Status != invoiceUnpaid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status: paid или unpaid"})
+		return
+	}
+	var paidAt *time.Time
+	if body.Status == invoicePaid {
+		now := time.Now()
+		if paidAt = body.PaidAt; paidAt == nil {
+			paidAt = &now
+		}
+	}
+	inv, err := scanInvoice(db.QueryRow(`UPDATE invoices SET status = $2, paid_at = $3 WHERE id = $1 RETURNING `+invoiceColumns,
+		c.Param("id"), body.Status, paidAt))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Счёт не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, inv)
+}
+
+func money(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// writeInvoicesCSV — выписка по счетам: номер, период, суммы и статус оплаты.
+func writeInvoicesCSV(w io.Writer, list []Invoice) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"number", "tenant_id", "period", "currency", "subtotal", "tax", "total", "status", "issued_at", "due_at", "paid_at", "overdue"})
+	for _, inv := range list {
+		paidAt := ""
+		if inv.PaidAt != nil {
+			paidAt = inv.PaidAt.Format("2006-01-02")
+		}
+		_ = cw.Write([]string{inv.Number, inv.TenantID, inv.Period, inv.Currency, money(inv.Subtotal), money(inv.Tax), money(inv.Total),
+			inv.Status, inv.IssuedAt.Format("2006-01-02"), inv.DueAt.Format("2006-01-02"), paidAt, strconv.FormatBool(inv.Overdue)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeInvoiceLinesCSV — строки счёта по заказам.
+func writeInvoiceLinesCSV(w io.Writer, inv Invoice) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"invoice", "order_id", "delivered_at", "description", "currency", "amount", "tax", "total"})
+	for _, l := range inv.Lines {
+		_ = cw.Write([]string{inv.Number, csvText(l.OrderID), l.DeliveredAt.Format("2006-01-02 15:04"), csvText(l.Description), inv.Currency,
+			money(l.Amount), money(l.Tax), money(l.Total)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// truncateRunes обрезает строку до n символов, чтобы она поместилась в ячейку PDF.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+func invoicePDF(inv Invoice) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 20, 15)
+	pdf.AddPage()
+	pdf.AddUTF8Font("DejaVu", "", "assets/fonts/DejaVuSerif.ttf")
+	pdf.SetFont("DejaVu", "", 16)
+	pdf.Cell(0, 10, fmt.Sprintf("Счёт № %s от %s", inv.Number, inv.IssuedAt.Format("02.01.2006")))
+	pdf.Ln(12)
+	pdf.SetFont("DejaVu", "", 12)
+	for _, line := range []string{
+		"Поставщик: " + invoiceSeller,
+		"Покупатель: " + inv.TenantID,
+		"Период: " + inv.Period,
+		"Оплатить до: " + inv.DueAt.Format("02.01.2006"),
+	} {
+		pdf.Cell(0, 8, line)
+		pdf.Ln(8)
+	}
+	pdf.Ln(4)
+
+	pdf.SetFont("DejaVu", "", 9)
+	row := func(cols []string, widths []float64) {
+		for i, col := range cols {
+			pdf.CellFormat(widths[i], 7, col, "1", 0, "", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	widths := []float64{8, 32, 24, 56, 20, 18, 22}
+	row([]string{"№", "Заказ", "Доставлен", "Описание", "Сумма", "НДС", "Итого"}, widths)
+	for i, l := range inv.Lines {
+		row([]string{strconv.Itoa(i + 1), l.OrderID, l.DeliveredAt.Format("02.01.2006"), truncateRunes(l.Description, 32),
+			money(l.Amount), money(l.Tax), money(l.Total)}, widths)
+	}
+	pdf.Ln(6)
+
+	pdf.SetFont("DejaVu", "", 12)
+	for _, t := range [][2]string{
+		{"Итого без НДС:", money(inv.Subtotal)},
+		{fmt.Sprintf("НДС %g%%:", inv.TaxRate), money(inv.Tax)},
+		{"Всего к оплате:", money(inv.Total) + " " + inv.Currency},
+	} {
+		pdf.CellFormat(140, 8, t[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 8, t[1], "", 1, "R", false, 0, "")
+	}
+	if inv.Status == invoicePaid && inv.PaidAt != nil {
+		pdf.Ln(6)
+		pdf.Cell(0, 8, "Оплачен "+inv.PaidAt.Format("02.01.2006"))
+	}
+	return pdf
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestBuildInvoices(t *testing.T) {
+	done := sql.NullTime{Time: time.Date(2026, 9, 3, 14, 0, 0, 0, time.Local), Valid: true}
+	orders := []Order{
+		{ID: "o-1", AddressFrom: "Склад", AddressTo: "Ленина, 1", Price: 500, PriceCurrency: "RUB", CompletedAt: done},
+		{ID: "o-2", AddressFrom: "Склад", AddressTo: "Мира, 5", Price: 333.33, CompletedAt: done},
+		{ID: "o-3", AddressFrom: "Склад", AddressTo: "Main St", Price: 10, PriceCurrency: "USD", CompletedAt: done},
+	}
+	invoices := buildInvoices("shop-1", "2026-09", orders, 20)
+	assert.Len(t, invoices, 2, "по счёту на валюту")
+
+	rub := invoices[0]
+	assert.Equal(t, "RUB", rub.Currency, "заказ без валюты считается в рублях")
+	assert.Equal(t, invoiceUnpaid, rub.Status)
+	assert.Len(t, rub.Lines, 2)
+	assert.Equal(t, 833.33, rub.Subtotal)
+	assert.Equal(t, 166.67, rub.Tax, "НДС считается построчно и округляется до копеек")
+	assert.Equal(t, 1000.0, rub.Total)
+	assert.Equal(t, "Доставка: Склад → Ленина, 1", rub.Lines[0].Description)
+	assert.Equal(t, done.Time, rub.Lines[0].DeliveredAt)
+
+	assert.Equal(t, "USD", invoices[1].Currency)
+	assert.Equal(t, 12.0, invoices[1].Total)
+}
+
+func TestInvoiceNumberAndPeriod(t *testing.T) {
+	assert.Equal(t, "2026-000042", invoiceNumber(2026, 42))
+
+	from, to, err := parseInvoicePeriod("2026-12")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 12, 1, 0, 0, 0, 0, time.Local), from)
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local), to)
+	_, _, err = parseInvoicePeriod("2026-13")
+	assert.Error(t, err)
+}
+
+func TestInvoiceExports(t *testing.T) {
+	paid := time.Date(2026, 10, 5, 0, 0, 0, 0, time.Local)
+	inv := Invoice{Number: "2026-000001", TenantID: "shop-1", Period: "2026-09", Currency: "RUB",
+		Subtotal: 500, TaxRate: 20, Tax: 100, Total: 600, Status: invoicePaid, PaidAt: &paid,
+		IssuedAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), DueAt: time.Date(2026, 10, 15, 0, 0, 0, 0, time.Local),
+		Lines: []InvoiceLine{{OrderID: "o-1", Description: "Доставка: Склад → Ленина, 1, подъезд 2, этаж 9, квартира 81",
+			DeliveredAt: time.Date(2026, 9, 3, 14, 0, 0, 0, time.Local), Amount: 500, Tax: 100, Total: 600}}}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeInvoiceLinesCSV(&out, inv))
+	assert.Equal(t, "invoice,order_id,delivered_at,description,currency,amount,tax,total\n"+
+		"2026-000001,o-1,2026-09-03 14:00,\"Доставка: Склад → Ленина, 1, подъезд 2, этаж 9, квартира 81\",RUB,500.00,100.00,600.00\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, writeInvoicesCSV(&out, []Invoice{inv}))
+	assert.Contains(t, out.String(), "2026-000001,shop-1,2026-09,RUB,500.00,100.00,600.00,paid,2026-10-01,2026-10-15,2026-10-05,false")
+
+	out.Reset()
+	assert.NoError(t, invoicePDF(inv).Output(&out))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF")))
+}
+
+func TestCreateInvoicesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	priceOrder = func(o Order) (OrderQuote, error) { return OrderQuote{Price: 500, Currency: "RUB"}, nil }
+	defer func() { priceOrder = fetchOrderPrice }()
+
+	claims := &auth.Claims{Role: auth.RoleAdmin}
+	claims.Subject = "billing"
+	r := gin.New()
+	r.POST("/invoices", func(c *gin.Context) { c.Set("claims", claims) }, createInvoicesHandler)
+
+	mock.ExpectQuery("FROM orders\\s+WHERE tenant_id = \\$1 AND payment_method = \\$2").
+		WithArgs("shop-1", paymentInvoice, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(testOrderColumns).AddRow(
+			"o-1", "a", "b", "Склад", "Ленина, 1", "завершён", time.Now(), time.Date(2026, 9, 3, 14, 0, 0, 0, time.Local),
+			1.0, 1.0, 1.0, 1.0, 1, "c-1", nil, nil, "", "", "", "", "shop-1", "", nil, nil, nil, nil, nil, nil, "", "",
+			0, "", "", paymentInvoice, 0.0, 0.0, "", "", ""))
+	mock.ExpectExec("UPDATE orders SET price").WithArgs("o-1", 500.0, "RUB", 0.0, "", 0.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO invoice_sequences").WithArgs(time.Now().Year()).
+		WillReturnRows(sqlmock.NewRows([]string{"last_number"}).AddRow(7))
+	mock.ExpectQuery("INSERT INTO invoices").
+		WithArgs(invoiceNumber(time.Now().Year(), 7), "shop-1", "2026-09", "RUB", 500.0, invoiceTaxRate, 100.0, 600.0, invoiceUnpaid,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), "billing").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO invoice_lines").WithArgs(int64(1), "o-1", sqlmock.AnyArg(), sqlmock.AnyArg(), 500.0, 100.0, 600.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/invoices", strings.NewReader(`{"tenant_id":"shop-1","period":"2026-09"}`)))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var invoices []Invoice
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &invoices))
+	assert.Len(t, invoices, 1)
+	assert.Equal(t, invoiceNumber(time.Now().Year(), 7), invoices[0].Number)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// текущий месяц ещё не закончился
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/invoices", strings.NewReader(`{"tenant_id":"shop-1","period":"`+time.Now().Format("2006-01")+`"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetInvoiceHandlerOtherTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.GET("/invoices/:id", func(c *gin.Context) { c.Set("claims", &auth.Claims{Role: auth.RoleAPIClient, TenantID: "shop-2"}) }, getInvoiceHandler)
+
+	mock.ExpectQuery("FROM invoices WHERE id").WithArgs("1").WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "number", "tenant_id", "period", "currency", "subtotal", "tax_rate", "tax", "total", "status", "issued_at", "due_at", "paid_at"}).
+		AddRow(1, "2026-000001", "shop-1", "2026-09", "RUB", 500.0, 20.0, 100.0, 600.0, invoiceUnpaid, time.Now(), time.Now(), nil))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/invoices/1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckInvoicePayment(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	status, err := checkInvoicePayment(auth.RoleCustomer, "shop-1")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.EqualError(t, err, "Оплата по счёту недоступна клиенту")
+
+	agreement := []string{"invoice_billing", "contract", "updated_by", "updated_at"}
+	mock.ExpectQuery("FROM tenant_billing").WithArgs("shop-1").WillReturnRows(sqlmock.NewRows(agreement))
+	status, err = checkInvoicePayment(auth.RoleAPIClient, "shop-1")
+	assert.Equal(t, http.StatusForbidden, status, "без договора")
+	assert.Error(t, err)
+
+	mock.ExpectQuery("FROM tenant_billing").WithArgs("shop-2").
+		WillReturnRows(sqlmock.NewRows(agreement).AddRow(true, "Д-7 от 01.09.2026", "root", time.Now()))
+	_, err = checkInvoicePayment(auth.RoleAPIClient, "shop-2")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetBillingAgreementHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	claims := &auth.Claims{Role: auth.RoleAdmin}
+	claims.Subject = "root"
+	r := gin.New()
+	r.PUT("/tenants/:id/billing", func(c *gin.Context) { c.Set("claims", claims) }, setBillingAgreementHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/tenants/shop-1/billing", strings.NewReader(`{"invoice_billing":true}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "договор обязателен")
+
+	mock.ExpectQuery("INSERT INTO tenant_billing").WithArgs("shop-1", true, "Д-7", "root").
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/tenants/shop-1/billing", strings.NewReader(`{"invoice_billing":true,"contract":"Д-7"}`)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"net/http"
 	"testing"
 	"time"
 
@@ -89,6 +90,57 @@ func TestPublishOrderCompletedEvent(t *testing.T) {
 	assert.Equal(t, "завершён", payload["status"])
 }
 
+func TestValidateDeliveryWindow(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour)
+	end := start.Add(2 * time.Hour)
+
+	assert.NoError(t, validateDeliveryWindow(Order{}))
+	assert.NoError(t, validateDeliveryWindow(Order{WindowStart: &start, WindowEnd: &end}))
+	assert.Error(t, validateDeliveryWindow(Order{WindowStart: &start}))
+	assert.Error(t, validateDeliveryWindow(Order{WindowStart: &end, WindowEnd: &start}))
+
+	past := time.Now().Add(-3 * time.Hour)
+	pastEnd := past.Add(time.Hour)
+	assert.Error(t, validateDeliveryWindow(Order{WindowStart: &past, WindowEnd: &pastEnd}))
+}
+
+func TestCheckDeliverySlot(t *testing.T) {
+	defer func(f func(string) ([]DeliverySlot, error)) { deliverySlots = f }(deliverySlots)
+	msk := time.FixedZone("MSK", 3*60*60)
+	slotStart := time.Date(2026, 9, 1, 9, 0, 0, 0, msk)
+	slotEnd := slotStart.Add(2 * time.Hour)
+	var askedDate string
+	deliverySlots = func(date string) ([]DeliverySlot, error) {
+		askedDate = date
+		return []DeliverySlot{{WindowStart: slotStart, WindowEnd: slotEnd}}, nil
+	}
+
+	_, err := checkDeliverySlot(Order{})
+	assert.NoError(t, err, "без окна")
+
+	start, end := slotStart.UTC(), slotEnd.UTC()
+	_, err = checkDeliverySlot(Order{WindowStart: &start, WindowEnd: &end})
+	assert.NoError(t, err, "то же окно в другом поясе")
+
+	start, end = slotStart, slotStart.Add(90*time.Minute)
+	status, err := checkDeliverySlot(Order{WindowStart: &start, WindowEnd: &end})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Error(t, err, "произвольное окно не принимается")
+	assert.Equal(t, "2026-09-01", askedDate)
+
+	// ночное окно по Москве в UTC приходится на предыдущие сутки — дата берётся в поясе окон
+	t.Setenv("SLOT_TIMEZONE", "Europe/Moscow")
+	night := time.Date(2026, 9, 1, 1, 0, 0, 0, msk).UTC()
+	nightEnd := night.Add(2 * time.Hour)
+	checkDeliverySlot(Order{WindowStart: &night, WindowEnd: &nightEnd})
+	assert.Equal(t, "2026-08-31", night.Format("2006-01-02"))
+	assert.Equal(t, "2026-09-01", askedDate)
+
+	deliverySlots = func(string) ([]DeliverySlot, error) { return nil, errors.New("недоступен") }
+	status, _ = checkDeliverySlot(Order{WindowStart: &start, WindowEnd: &end})
+	assert.Equal(t, http.StatusBadGateway, status)
+}
+
 // --- Обёртки для мок-тестов ---
 
 func publishNotificationWithPublisher(publisher EventPublisher, typ, recipient, message string) error {
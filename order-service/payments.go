@@ -0,0 +1,647 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// Статусы платежа; orders.payment_status повторяет статус последнего платежа
+// (paymentUnpaid — предоплатный заказ, по которому ещё не платили).
+const (
+	paymentUnpaid     = "unpaid"
+	paymentPending    = "pending" // ждём подтверждения шлюза (3-D Secure и т.п.) через webhook
+	paymentAuthorized = "authorized"
+	paymentDeclined   = "declined"
+	paymentCaptured   = "captured"
+	paymentRefunded   = "refunded"
+)
+
+// statusCancelled — заказ отменён до вручения (см. cancelOrderHandler).
+const statusCancelled = "отменён"
+
+// orderOpen — заказ ещё может быть доставлен: не возвращён отправителю и не отменён.
+func orderOpen(status string) bool {
+	return status != statusReturned && status != statusCancelled
+}
+
+// History-события оплаты и отмены.
+const (
+	historyCancelled = "cancelled"
+	historyRefunded  = "refunded"
+)
+
+// PaymentRequest — авторизация оплаты заказа.
+type PaymentRequest struct {
+	OrderID  string
+	Amount   float64
+	Currency string
+	Token    string // токен карты/кошелька, полученный клиентом от шлюза
+	// Attempt — номер попытки оплаты заказа (1, 2, ...). Вместе с OrderID — ключ идемпотентности:
+	// повтор той же попытки шлюз должен опознать как тот же платёж.
+	Attempt int
+}
+
+// ProviderResult — ответ шлюза на операцию.
+type ProviderResult struct {
+	Ref     string // идентификатор платежа у шлюза
+	Status  string
+	Message string
+}
+
+// WebhookEvent — уведомление шлюза об изменении статуса платежа.
+type WebhookEvent struct {
+	Ref    string
+	Status string
+}
+
+// PaymentProvider — платёжный шлюз. Refund для неподтверждённой (не списанной) авторизации снимает блокировку средств.
+type PaymentProvider interface {
+	Name() string
+	Authorize(req PaymentRequest) (ProviderResult, error)
+	Capture(ref string, amount float64, currency string) (ProviderResult, error)
+	Refund(ref string, amount float64, currency string) (ProviderResult, error)
+	// ParseWebhook проверяет подпись уведомления и разбирает его.
+	ParseWebhook(header http.Header, body []byte) (WebhookEvent, error)
+}
+
+var (
+	// paymentProviders — доступные шлюзы по имени (часть URL webhook).
+	paymentProviders = map[string]PaymentProvider{}
+	// payments — шлюз для новых платежей (env PAYMENT_PROVIDER); nil — онлайн-оплата не настроена.
+	payments PaymentProvider
+)
+
+// initPayments регистрирует шлюз из PAYMENT_PROVIDER. Без него онлайн-оплата выключена и webhook не принимается:
+// mock-шлюз подтверждает любой платёж, поэтому включается только явно и только с заданным секретом webhook.
+func initPayments() {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "":
+		log.Println("PAYMENT_PROVIDER не задан: онлайн-оплата отключена")
+		return
+	case "mock":
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if secret == "" {
+			log.Fatal("PAYMENT_WEBHOOK_SECRET обязателен для PAYMENT_PROVIDER=mock")
+		}
+		payments = mockPaymentProvider{secret: secret}
+	default:
+		log.Fatalf("PAYMENT_PROVIDER: неизвестный шлюз %q", name)
+	}
+	registerPaymentProvider(payments)
+}
+
+func registerPaymentProvider(p PaymentProvider) {
+	paymentProviders[p.Name()] = p
+}
+
+// Payment — платёж по заказу.
+type Payment struct {
+	ID          int64     `json:"id"`
+	OrderID     string    `json:"order_id"`
+	Provider    string    `json:"provider"`
+	ProviderRef string    `json:"provider_ref"`
+	Status      string    `json:"status"`
+	Amount      float64   `json:"amount"`
+	Currency    string    `json:"currency"`
+	Message     string    `json:"message,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+const paymentColumns = `id, order_id, provider, provider_ref, status, amount, currency, COALESCE(message, ''), created_at, updated_at`
+
+func scanPayment(row rowScanner) (Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.OrderID, &p.Provider, &p.ProviderRef, &p.Status, &p.Amount, &p.Currency, &p.Message, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// paymentAllowsDispatch — можно ли назначать курьера: предоплатный заказ — только после авторизации платежа.
+// Пустой статус — заказ без предоплаты (наложенный платёж, возвратный или созданный до появления платежей).
+func paymentAllowsDispatch(status string) bool {
+	return status == "" || status == paymentAuthorized || status == paymentCaptured
+}
+
+// setPaymentStatus обновляет статус платежа и заказа.
+func setPaymentStatus(p Payment, status, message string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE payments SET status = $2, message = NULLIF($3, ''), updated_at = NOW() WHERE id = $1`, p.ID, status, message); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE orders SET payment_status = $2 WHERE id = $1`, p.OrderID, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OrderQuote — расчёт стоимости заказа delivery-service. Скидка, базовая валюта и курс сохраняются вместе
+// с ценой: по ним аналитика учитывает выручку курьера (см. publishOrderRevenue).
+type OrderQuote struct {
+	Price        float64 `json:"estimated_cost"`
+	Currency     string  `json:"currency"`
+	Discount     float64 `json:"discount"`
+	BaseCurrency string  `json:"base_currency"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+// priceOrder запрашивает стоимость заказа у delivery-service; переменная — для подмены в тестах.
+var priceOrder = fetchOrderPrice
+
+func fetchOrderPrice(o Order) (OrderQuote, error) {
+	deliveryURL := os.Getenv("DELIVERY_URL")
+	if deliveryURL == "" {
+		return OrderQuote{}, errors.New("DELIVERY_URL не задан, расчёт стоимости недоступен")
+	}
+	req := map[string]any{
+		"order_id": o.ID, "tenant_id": o.TenantID, "urgency": o.Urgency,
+		"weight": o.Weight, "length": o.Length, "width": o.Width, "height": o.Height,
+		"window_start": o.WindowStart, "window_end": o.WindowEnd,
+		"promo_code": o.PromoCode, "customer": o.CreatedBy, "currency": o.Currency,
+	}
+	if o.FromLat != nil && o.FromLng != nil && o.ToLat != nil && o.ToLng != nil {
+		req["from_lat"], req["from_lng"], req["to_lat"], req["to_lng"] = *o.FromLat, *o.FromLng, *o.ToLat, *o.ToLng
+	}
+	payload, _ := json.Marshal(req)
+	resp, err := postJSON(deliveryURL+"/calculate", payload)
+	if err != nil {
+		return OrderQuote{}, fmt.Errorf("расчёт стоимости: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		OrderQuote
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return OrderQuote{}, errPromoRejected{reason: body.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return OrderQuote{}, fmt.Errorf("delivery-service ответил %s: %s", resp.Status, body.Error)
+	}
+	q := body.OrderQuote
+	q.Price, q.Discount = roundMoney(q.Price), roundMoney(q.Discount)
+	return q, nil
+}
+
+// ensureOrderPrice фиксирует стоимость заказа, если она ещё не рассчитана (заказ с промокодом считается при создании);
+// дальше используется сохранённая.
+func ensureOrderPrice(o *Order) error {
+	if o.Price > 0 {
+		return nil
+	}
+	q, err := priceOrder(*o)
+	if err != nil {
+		return err
+	}
+	if q.Price <= 0 {
+		return errors.New("delivery-service вернул нулевую стоимость")
+	}
+	if err := saveOrderQuote(o.ID, q); err != nil {
+		return err
+	}
+	o.Price, o.PriceCurrency = q.Price, q.Currency
+	return nil
+}
+
+// saveOrderQuote сохраняет расчёт стоимости заказа.
+func saveOrderQuote(orderID string, q OrderQuote) error {
+	_, err := db.Exec(`UPDATE orders SET price = $2, price_currency = $3, price_discount = $4, price_base_currency = NULLIF($5, ''),
+		price_exchange_rate = NULLIF($6, 0) WHERE id = $1`, orderID, q.Price, q.Currency, q.Discount, q.BaseCurrency, q.ExchangeRate)
+	return err
+}
+
+// publishDeliveryCalculated публикует выручку заказа для аналитики; переменная — для подмены в тестах.
+var publishDeliveryCalculated = publishEventToQueue
+
+// publishOrderRevenue сообщает аналитике выручку доставленного заказа в формате delivery_calculated
+// delivery-service. Стоимость считается до назначения курьера (при оплате или выставлении счёта),
+// поэтому событие с курьером публикует order-service после вручения: ровно один раз на заказ
+// и на курьера, который его доставил. Заказ без рассчитанной стоимости выручки не даёт.
+func publishOrderRevenue(orderID, courierID, tenantID string) {
+	var q OrderQuote
+	var promoCode string
+	err := db.QueryRow(`SELECT COALESCE(price, 0), COALESCE(price_currency, ''), COALESCE(price_discount, 0),
+		COALESCE(price_base_currency, ''), COALESCE(price_exchange_rate, 0), COALESCE(promo_code, '') FROM orders WHERE id = $1`, orderID).
+		Scan(&q.Price, &q.Currency, &q.Discount, &q.BaseCurrency, &q.ExchangeRate, &promoCode)
+	if err != nil {
+		log.Printf("Ошибка чтения стоимости заказа %s: %v", orderID, err)
+		return
+	}
+	if q.Price <= 0 || courierID == "" {
+		return
+	}
+	event := map[string]any{
+		"event":         "delivery_calculated",
+		"order_id":      orderID,
+		"courier_id":    courierID,
+		"tenant_id":     tenantID,
+		"cost":          q.Price,
+		"discount":      q.Discount,
+		"promo_code":    promoCode,
+		"currency":      q.Currency,
+		"base_currency": q.BaseCurrency,
+		"exchange_rate": q.ExchangeRate,
+	}
+	if err := publishDeliveryCalculated("delivery_calculated", event); err != nil {
+		log.Printf("Ошибка публикации delivery_calculated по заказу %s: %v", orderID, err)
+	}
+}
+
+// latestPayment возвращает последний платёж заказа в одном из статусов или sql.ErrNoRows.
+func latestPayment(orderID string, statuses ...string) (Payment, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE order_id = $1"
+	args := []any{orderID}
+	if len(statuses) > 0 {
+		query += " AND status IN ("
+		for i, s := range statuses {
+			if i > 0 {
+				query += ", "
+			}
+			args = append(args, s)
+			query += fmt.Sprintf("$%d", len(args))
+		}
+		query += ")"
+	}
+	return scanPayment(db.QueryRow(query+" ORDER BY id DESC LIMIT 1", args...))
+}
+
+// POST /orders/:id/payments — оплата предоплатного заказа: стоимость берётся из delivery-service,
+// шлюз авторизует сумму; списание — при вручении, возврат — при отмене заказа.
+func createPaymentHandler(c *gin.Context) {
+	var body struct {
+		PaymentToken string `json:"payment_token"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.PaymentToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужен payment_token"})
+		return
+	}
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	switch {
+	case o.PaymentMethod != paymentPrepaid || o.PaymentStatus == "":
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ не требует предоплаты"})
+		return
+	case o.CompletedAt.Valid || !orderOpen(o.Status):
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ уже закрыт"})
+		return
+	case o.PaymentStatus != paymentUnpaid && o.PaymentStatus != paymentDeclined:
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ уже оплачен или оплата в обработке"})
+		return
+	}
+	if payments == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Онлайн-оплата не настроена"})
+		return
+	}
+	if err := ensureOrderPrice(&o); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Заказ переводится в pending условным UPDATE до обращения к шлюзу: из параллельных запросов
+	// авторизацию создаёт только один, остальные получают 409.
+	claimed, err := db.Exec(`UPDATE orders SET payment_status = $2 WHERE id = $1 AND payment_status = $3 AND completed_at IS NULL`,
+		o.ID, paymentPending, o.PaymentStatus)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := claimed.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Заказ уже оплачен или оплата в обработке"})
+		return
+	}
+
+	// платежа нет, оплату можно повторить
+	release := func() {
+		if _, rerr := db.Exec(`UPDATE orders SET payment_status = $2 WHERE id = $1 AND payment_status = $3`, o.ID, o.PaymentStatus, paymentPending); rerr != nil {
+			log.Printf("Ошибка возврата статуса оплаты заказа %s: %v", o.ID, rerr)
+		}
+	}
+	// Номер попытки считается под pending: новых платежей по заказу параллельно не появится.
+	var attempt int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM payments WHERE order_id = $1`, o.ID).Scan(&attempt); err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	attempt++
+	res, err := payments.Authorize(PaymentRequest{OrderID: o.ID, Amount: o.Price, Currency: o.PriceCurrency, Token: body.PaymentToken, Attempt: attempt})
+	if err != nil {
+		release()
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Платёжный шлюз: " + err.Error()})
+		return
+	}
+	p, err := recordAuthorization(o, res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res.Status == paymentDeclined {
+		c.JSON(http.StatusPaymentRequired, p)
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+// recordAuthorization сохраняет ответ шлюза на авторизацию и статус оплаты заказа одной транзакцией.
+func recordAuthorization(o Order, res ProviderResult) (Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Payment{}, err
+	}
+	defer tx.Rollback()
+	p, err := scanPayment(tx.QueryRow(`
+		INSERT INTO payments (order_id, tenant_id, provider, provider_ref, status, amount, currency, message, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NOW(), NOW())
+		RETURNING `+paymentColumns,
+		o.ID, o.TenantID, payments.Name(), res.Ref, res.Status, o.Price, o.PriceCurrency, res.Message))
+	if err != nil {
+		return p, err
+	}
+	if _, err := tx.Exec(`UPDATE orders SET payment_status = $2 WHERE id = $1`, o.ID, res.Status); err != nil {
+		return p, err
+	}
+	return p, tx.Commit()
+}
+
+// GET /orders/:id/payments — платежи заказа, новые первыми.
+func getPaymentsHandler(c *gin.Context) {
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	rows, err := db.Query("SELECT "+paymentColumns+" FROM payments WHERE order_id = $1 ORDER BY id DESC", o.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := []Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, p)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// webhookTransitions — допустимые переходы по уведомлениям шлюза; повтор уведомления ничего не меняет.
+var webhookTransitions = map[string][]string{
+	paymentPending:    {paymentAuthorized, paymentDeclined},
+	paymentAuthorized: {paymentCaptured, paymentRefunded},
+	paymentCaptured:   {paymentRefunded},
+}
+
+// POST /payments/webhook/:provider — уведомление шлюза; аутентификация — подпись, проверяемая шлюзом.
+func paymentWebhookHandler(c *gin.Context) {
+	p, ok := paymentProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Неизвестный платёжный шлюз"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный запрос"})
+		return
+	}
+	evt, err := p.ParseWebhook(c.Request.Header, body)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	payment, err := scanPayment(db.QueryRow("SELECT "+paymentColumns+" FROM payments WHERE provider = $1 AND provider_ref = $2", p.Name(), evt.Ref))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Платёж не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if evt.Status == payment.Status {
+		c.JSON(http.StatusOK, gin.H{"status": payment.Status})
+		return
+	}
+	allowed := false
+	for _, s := range webhookTransitions[payment.Status] {
+		allowed = allowed || s == evt.Status
+	}
+	if !allowed {
+		log.Printf("Webhook %s: переход %s → %s для платежа %s игнорируется", p.Name(), payment.Status, evt.Status, evt.Ref)
+		c.JSON(http.StatusOK, gin.H{"status": payment.Status})
+		return
+	}
+	if err := setPaymentStatus(payment, evt.Status, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": evt.Status})
+}
+
+// capturePayment списывает авторизованный платёж заказа при вручении. Заказ без платежа пропускается.
+func capturePayment(orderID string) error {
+	p, err := latestPayment(orderID, paymentAuthorized)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	provider, ok := paymentProviders[p.Provider]
+	if !ok {
+		return fmt.Errorf("шлюз %s не настроен", p.Provider)
+	}
+	res, err := provider.Capture(p.ProviderRef, p.Amount, p.Currency)
+	if err != nil {
+		return err
+	}
+	return setPaymentStatus(p, res.Status, res.Message)
+}
+
+// refundPayment возвращает платёж заказа (или снимает авторизацию) и возвращает его; nil — платить было нечего.
+func refundPayment(orderID string) (*Payment, error) {
+	p, err := latestPayment(orderID, paymentAuthorized, paymentCaptured, paymentPending)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := paymentProviders[p.Provider]
+	if !ok {
+		return nil, fmt.Errorf("шлюз %s не настроен", p.Provider)
+	}
+	res, err := provider.Refund(p.ProviderRef, p.Amount, p.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if err := setPaymentStatus(p, res.Status, res.Message); err != nil {
+		return nil, err
+	}
+	p.Status = res.Status
+	return &p, nil
+}
+
+// POST /orders/:id/cancel — отмена заказа до вручения. Платёж возвращается до смены статуса:
+// если шлюз недоступен, заказ остаётся активным и отмену можно повторить.
+func cancelOrderHandler(c *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&body) // причина необязательна
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	if status, err := checkCancel(o); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	refund, err := refundPayment(o.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Не удалось вернуть платёж: " + err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	if status, err := applyCancel(tx, o); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	announceCancel(o, refund, auth.CurrentClaims(c).Subject, body.Reason)
+	c.JSON(http.StatusOK, gin.H{"status": statusCancelled, "refund": refund})
+}
+
+// checkCancel — можно ли отменить заказ: только до вручения и пока он не закрыт.
+func checkCancel(o Order) (int, error) {
+	if o.CompletedAt.Valid || !orderOpen(o.Status) {
+		return http.StatusConflict, errors.New("Заказ уже закрыт")
+	}
+	return 0, nil
+}
+
+// applyCancel переводит заказ в «отменён» и освобождает курьера в транзакции tx.
+func applyCancel(tx *sql.Tx, o Order) (int, error) {
+	res, err := tx.Exec(`UPDATE orders SET status = $2, courier_id = NULL, eta = NULL WHERE id = $1 AND completed_at IS NULL`, o.ID, statusCancelled)
+	if err == nil && o.CourierID != "" {
+		_, err = tx.Exec(`UPDATE couriers SET status = 'доступен', active_order_id = NULL WHERE id = $1 AND active_order_id = $2`, o.CourierID, o.ID)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return http.StatusConflict, errors.New("Заказ уже закрыт")
+	}
+	return 0, nil
+}
+
+// announceCancel после commit записывает отмену и возврат в историю, уведомляет клиента и аналитику.
+func announceCancel(o Order, refund *Payment, actor, reason string) {
+	if err := recordOrderHistory(o.ID, historyCancelled, actor, reason); err != nil {
+		log.Printf("Ошибка записи истории заказа %s: %v", o.ID, err)
+	}
+	msg := fmt.Sprintf("Заказ %s отменён.", o.ID)
+	if refund != nil {
+		details := fmt.Sprintf("%.2f %s", refund.Amount, refund.Currency)
+		if err := recordOrderHistory(o.ID, historyRefunded, actor, details); err != nil {
+			log.Printf("Ошибка записи истории заказа %s: %v", o.ID, err)
+		}
+		msg += " Оплата " + details + " возвращена."
+	}
+	_ = publishNotification("order_cancelled", o.Email, msg, o.TenantID)
+	event := map[string]string{"event": "order_cancelled", "order_id": o.ID, "tenant_id": o.TenantID, "status": statusCancelled}
+	if err := publishOrderCancelled("order_cancelled", event); err != nil {
+		log.Printf("Ошибка публикации order_cancelled по заказу %s: %v", o.ID, err)
+	}
+}
+
+// publishOrderCancelled сообщает аналитике об отмене заказа; переменная — для подмены в тестах.
+var publishOrderCancelled = publishEventToQueue
+
+// mockPaymentProvider — шлюз для разработки и тестов, без сети:
+// токен "tok_decline" отклоняется, "tok_pending" ждёт подтверждения через webhook, остальные авторизуются.
+// Webhook подписывается HMAC-SHA256 тела с ключом PAYMENT_WEBHOOK_SECRET в заголовке X-Mock-Signature.
+type mockPaymentProvider struct {
+	secret string
+}
+
+// Тестовые токены mock-шлюза.
+const (
+	mockTokenDecline = "tok_decline"
+	mockTokenPending = "tok_pending"
+)
+
+func (mockPaymentProvider) Name() string { return "mock" }
+
+func (mockPaymentProvider) Authorize(req PaymentRequest) (ProviderResult, error) {
+	// Идентификатор выводится из заказа и номера попытки: новая попытка после отказа — новый платёж,
+	// а повтор той же попытки совпадёт с уже записанным (UNIQUE (provider, provider_ref)).
+	res := ProviderResult{Ref: fmt.Sprintf("mock_%s_%d", req.OrderID, req.Attempt), Status: paymentAuthorized}
+	switch req.Token {
+	case mockTokenDecline:
+		res.Status, res.Message = paymentDeclined, "Карта отклонена"
+	case mockTokenPending:
+		res.Status = paymentPending
+	}
+	return res, nil
+}
+
+func (mockPaymentProvider) Capture(ref string, _ float64, _ string) (ProviderResult, error) {
+	return ProviderResult{Ref: ref, Status: paymentCaptured}, nil
+}
+
+func (mockPaymentProvider) Refund(ref string, _ float64, _ string) (ProviderResult, error) {
+	return ProviderResult{Ref: ref, Status: paymentRefunded}, nil
+}
+
+func (m mockPaymentProvider) ParseWebhook(header http.Header, body []byte) (WebhookEvent, error) {
+	sig, err := hex.DecodeString(header.Get("X-Mock-Signature"))
+	if err != nil || !hmac.Equal(sig, m.sign(body)) {
+		return WebhookEvent{}, errors.New("неверная подпись webhook")
+	}
+	var evt struct {
+		ProviderRef string `json:"provider_ref"`
+		Status      string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &evt); err != nil || evt.ProviderRef == "" || evt.Status == "" {
+		return WebhookEvent{}, errors.New("некорректное тело webhook")
+	}
+	return WebhookEvent{Ref: evt.ProviderRef, Status: evt.Status}, nil
+}
+
+func (m mockPaymentProvider) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
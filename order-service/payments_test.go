@@ -0,0 +1,396 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// expectOpenOrder ожидает выборку недоставленного предоплатного заказа клиента alice.
+func expectOpenOrder(mock sqlmock.Sqlmock, id, courierID, paymentStatus string, price float64) {
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs(id).WillReturnRows(sqlmock.NewRows(testOrderColumns).AddRow(
+		id, "a", "b", "x", "y", "новый", time.Now(), nil, 1.0, 1.0, 1.0, 1.0, 1, courierID, nil, nil,
+		"shop@example.com", "", "", "alice", "shop-1", "", nil, nil, nil, nil, nil, nil, "", "", 0, "", "", paymentPrepaid, 0.0,
+		price, "RUB", paymentStatus, ""))
+}
+
+func paymentRows(id int64, orderID, ref, status string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "order_id", "provider", "provider_ref", "status", "amount", "currency", "message", "created_at", "updated_at"}).
+		AddRow(id, orderID, "mock", ref, status, 750.0, "RUB", "", time.Now(), time.Now())
+}
+
+func TestMockPaymentProvider(t *testing.T) {
+	p := mockPaymentProvider{secret: "s3cret"}
+	req := PaymentRequest{OrderID: "o-1", Amount: 750, Currency: "RUB", Token: "tok_visa", Attempt: 1}
+	a, err := p.Authorize(req)
+	assert.NoError(t, err)
+	assert.Equal(t, paymentAuthorized, a.Status)
+	b, _ := p.Authorize(req)
+	assert.Equal(t, a.Ref, b.Ref, "повтор той же попытки — тот же платёж")
+	req.Attempt = 2
+	b, _ = p.Authorize(req)
+	assert.NotEqual(t, a.Ref, b.Ref, "новая попытка — отдельный платёж")
+
+	req.Token = mockTokenDecline
+	d, _ := p.Authorize(req)
+	assert.Equal(t, paymentDeclined, d.Status)
+	assert.NotEqual(t, a.Ref, d.Ref)
+	req.Token = mockTokenPending
+	pending, _ := p.Authorize(req)
+	assert.Equal(t, paymentPending, pending.Status)
+
+	body := []byte(`{"provider_ref":"mock_1","status":"authorized"}`)
+	h := http.Header{}
+	h.Set("X-Mock-Signature", hex.EncodeToString(p.sign(body)))
+	evt, err := p.ParseWebhook(h, body)
+	assert.NoError(t, err)
+	assert.Equal(t, WebhookEvent{Ref: "mock_1", Status: paymentAuthorized}, evt)
+
+	_, err = mockPaymentProvider{secret: "other"}.ParseWebhook(h, body)
+	assert.Error(t, err, "подпись другим ключом")
+}
+
+func TestInitPayments(t *testing.T) {
+	defer func(m map[string]PaymentProvider, p PaymentProvider) { paymentProviders, payments = m, p }(paymentProviders, payments)
+
+	paymentProviders, payments = map[string]PaymentProvider{}, nil
+	t.Setenv("PAYMENT_PROVIDER", "")
+	initPayments()
+	assert.Nil(t, payments)
+	assert.Empty(t, paymentProviders, "без PAYMENT_PROVIDER webhook mock-шлюза не принимается")
+
+	t.Setenv("PAYMENT_PROVIDER", "mock")
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "s3cret")
+	initPayments()
+	assert.Equal(t, mockPaymentProvider{secret: "s3cret"}, payments)
+	assert.Equal(t, payments, paymentProviders["mock"])
+}
+
+func TestCreatePaymentHandlerWithoutProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(p PaymentProvider) { payments = p }(payments)
+	payments = nil
+
+	r := gin.New()
+	r.POST("/orders/:id/payments", func(c *gin.Context) { c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}) }, createPaymentHandler)
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 0)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/payments", strings.NewReader(`{"payment_token":"tok_visa"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateOrderPaymentStatus(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	t.Setenv("RABBITMQ_URL", "")
+
+	// payment_status — 31-й аргумент INSERT: предоплаты ждёт только заказ с явным payment_method=prepaid
+	for method, status := range map[string]string{"": "", paymentPrepaid: paymentUnpaid, paymentCOD: ""} {
+		args := make([]driver.Value, 32)
+		for i := range args {
+			args[i] = sqlmock.AnyArg()
+		}
+		args[30] = status
+		mock.ExpectExec("INSERT INTO orders").WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+		o, err := createOrder(Order{ID: "o-1", TenantID: "shop-1", PaymentMethod: method}, "alice")
+		assert.NoError(t, err)
+		assert.Equal(t, paymentAllowsDispatch(o.PaymentStatus), method != paymentPrepaid, method)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestOrderRevenuePath — выручка курьера: стоимость из /calculate сохраняется целиком и после вручения
+// уходит в delivery_calculated с курьером. Тело события совпадает с тем, что разбирает
+// analytics-service (TestHandleDeliveryCalculatedFromOrderService).
+func TestOrderRevenuePath(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "o-1", req["order_id"])
+		assert.Equal(t, "alice", req["customer"])
+		w.Write([]byte(`{"estimated_cost":10,"currency":"USD","discount":1,"base_currency":"RUB","exchange_rate":90}`))
+	}))
+	defer srv.Close()
+	t.Setenv("DELIVERY_URL", srv.URL)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET price = $2, price_currency = $3, price_discount = $4")).
+		WithArgs("o-1", 10.0, "USD", 1.0, "RUB", 90.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	o := Order{ID: "o-1", TenantID: "shop-1", CreatedBy: "alice", PromoCode: "SALE"}
+	assert.NoError(t, ensureOrderPrice(&o))
+
+	var published []byte
+	publishDeliveryCalculated = func(queue string, payload any) error {
+		assert.Equal(t, "delivery_calculated", queue)
+		published, _ = json.Marshal(payload)
+		return nil
+	}
+	defer func() { publishDeliveryCalculated = publishEventToQueue }()
+	mock.ExpectQuery("SELECT COALESCE\\(price, 0\\)").WithArgs("o-1").WillReturnRows(
+		sqlmock.NewRows([]string{"price", "price_currency", "price_discount", "price_base_currency", "price_exchange_rate", "promo_code"}).
+			AddRow(10.0, "USD", 1.0, "RUB", 90.0, "SALE"))
+	publishOrderRevenue("o-1", "c-1", "shop-1")
+	assert.JSONEq(t, `{"event":"delivery_calculated","order_id":"o-1","courier_id":"c-1","tenant_id":"shop-1",
+		"cost":10,"discount":1,"promo_code":"SALE","currency":"USD","base_currency":"RUB","exchange_rate":90}`, string(published))
+
+	// без курьера или стоимости выручки нет
+	published = nil
+	mock.ExpectQuery("SELECT COALESCE\\(price, 0\\)").WithArgs("o-2").WillReturnRows(
+		sqlmock.NewRows([]string{"price", "price_currency", "price_discount", "price_base_currency", "price_exchange_rate", "promo_code"}).
+			AddRow(0.0, "", 0.0, "", 0.0, ""))
+	publishOrderRevenue("o-2", "c-1", "shop-1")
+	assert.Nil(t, published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateOrderRedeemsPromo(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	t.Setenv("RABBITMQ_URL", "")
+	var priced []Order
+	priceOrder = func(o Order) (OrderQuote, error) {
+		priced = append(priced, o)
+		return OrderQuote{Price: 450, Currency: "RUB", Discount: 50}, nil
+	}
+	defer func() { priceOrder = fetchOrderPrice }()
+
+	// наложенный платёж с промокодом: стоимость по заказу (и погашение промокода) — при создании
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET price = $2")).WithArgs("o-1", 450.0, "RUB", 50.0, "", 0.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	o, err := createOrder(Order{ID: "o-1", TenantID: "shop-1", PromoCode: "SALE", PaymentMethod: paymentCOD, CODAmount: 1000}, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 450.0, o.Price)
+	if assert.Len(t, priced, 1) {
+		assert.Equal(t, "alice", priced[0].CreatedBy)
+	}
+
+	// без промокода стоимость считается при оплате или выставлении счёта
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = createOrder(Order{ID: "o-2", TenantID: "shop-1", PaymentMethod: paymentCOD, CODAmount: 1000}, "alice")
+	assert.NoError(t, err)
+	assert.Len(t, priced, 1)
+
+	// промокод отклонён при погашении — заказ не создаётся
+	priceOrder = func(o Order) (OrderQuote, error) {
+		return OrderQuote{}, errPromoRejected{reason: "лимит исчерпан"}
+	}
+	_, err = createOrder(Order{ID: "o-3", TenantID: "shop-1", PromoCode: "SALE"}, "alice")
+	assert.IsType(t, errPromoRejected{}, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPaymentAllowsDispatch(t *testing.T) {
+	assert.True(t, paymentAllowsDispatch(""), "заказ без предоплаты")
+	assert.True(t, paymentAllowsDispatch(paymentAuthorized))
+	assert.True(t, paymentAllowsDispatch(paymentCaptured))
+	for _, s := range []string{paymentUnpaid, paymentPending, paymentDeclined, paymentRefunded} {
+		assert.False(t, paymentAllowsDispatch(s), s)
+	}
+}
+
+func TestCreatePaymentHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(p PaymentProvider) { payments = p }(payments)
+	payments = mockPaymentProvider{secret: "s3cret"}
+	priceOrder = func(o Order) (OrderQuote, error) { return OrderQuote{Price: 750, Currency: "RUB"}, nil }
+	defer func() { priceOrder = fetchOrderPrice }()
+
+	claims := &auth.Claims{Role: auth.RoleCustomer, TenantID: "shop-1"}
+	claims.Subject = "alice"
+	r := gin.New()
+	r.POST("/orders/:id/payments", func(c *gin.Context) { c.Set("claims", claims) }, createPaymentHandler)
+
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 0)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET price = $2, price_currency = $3")).WithArgs("o-1", 750.0, "RUB", 0.0, "", 0.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET payment_status = $2 WHERE id = $1 AND payment_status = $3")).
+		WithArgs("o-1", paymentPending, paymentUnpaid).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM payments WHERE order_id = $1")).WithArgs("o-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO payments").
+		WithArgs("o-1", "shop-1", "mock", "mock_o-1_1", paymentAuthorized, 750.0, "RUB", "").
+		WillReturnRows(paymentRows(1, "o-1", "mock_o-1_1", paymentAuthorized))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET payment_status = $2")).WithArgs("o-1", paymentAuthorized).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/payments", strings.NewReader(`{"payment_token":"tok_visa"}`)))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var p Payment
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, paymentAuthorized, p.Status)
+
+	// повторная оплата уже авторизованного заказа
+	expectOpenOrder(mock, "o-1", "", paymentAuthorized, 750)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/payments", strings.NewReader(`{"payment_token":"tok_visa"}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// параллельный запрос прочитал unpaid, но заказ уже перевёл в pending другой: шлюз не вызывается
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 750)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET payment_status = $2 WHERE id = $1 AND payment_status = $3")).
+		WithArgs("o-1", paymentPending, paymentUnpaid).WillReturnResult(sqlmock.NewResult(0, 0))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/payments", strings.NewReader(`{"payment_token":"tok_visa"}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPaymentWebhookHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(m map[string]PaymentProvider) { paymentProviders = m }(paymentProviders)
+	provider := mockPaymentProvider{secret: "s3cret"}
+	paymentProviders = map[string]PaymentProvider{}
+	registerPaymentProvider(provider)
+
+	r := gin.New()
+	r.POST("/payments/webhook/:provider", paymentWebhookHandler)
+	send := func(body, sig string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/webhook/mock", strings.NewReader(body))
+		req.Header.Set("X-Mock-Signature", sig)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"provider_ref":"mock_1","status":"authorized"}`
+
+	w := send(body, "00")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mock.ExpectQuery("FROM payments WHERE provider = \\$1 AND provider_ref = \\$2").WithArgs("mock", "mock_1").
+		WillReturnRows(paymentRows(1, "o-1", "mock_1", paymentPending))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE payments SET status").WithArgs(int64(1), paymentAuthorized, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET payment_status").WithArgs("o-1", paymentAuthorized).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = send(body, hex.EncodeToString(provider.sign([]byte(body))))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// уже возвращённый платёж не «оживает» от запоздавшего уведомления
+	mock.ExpectQuery("FROM payments WHERE provider").WillReturnRows(paymentRows(1, "o-1", "mock_1", paymentRefunded))
+	w = send(body, hex.EncodeToString(provider.sign([]byte(body))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), paymentRefunded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelOrderHandlerRefunds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(m map[string]PaymentProvider) { paymentProviders = m }(paymentProviders)
+	paymentProviders = map[string]PaymentProvider{}
+	registerPaymentProvider(mockPaymentProvider{secret: "s3cret"})
+	defer func(f func(string, any) error) { publishOrderCancelled = f }(publishOrderCancelled)
+	var published any
+	publishOrderCancelled = func(queue string, payload any) error {
+		published = payload
+		return nil
+	}
+
+	claims := &auth.Claims{Role: auth.RoleCustomer, TenantID: "shop-1"}
+	claims.Subject = "alice"
+	r := gin.New()
+	r.POST("/orders/:id/cancel", func(c *gin.Context) { c.Set("claims", claims) }, cancelOrderHandler)
+
+	expectOpenOrder(mock, "o-1", "c-1", paymentAuthorized, 750)
+	mock.ExpectQuery("FROM payments WHERE order_id = \\$1 AND status IN").
+		WithArgs("o-1", paymentAuthorized, paymentCaptured, paymentPending).
+		WillReturnRows(paymentRows(1, "o-1", "mock_1", paymentAuthorized))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE payments SET status").WithArgs(int64(1), paymentRefunded, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET payment_status").WithArgs("o-1", paymentRefunded).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET status = $2, courier_id = NULL")).WithArgs("o-1", statusCancelled).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE couriers SET status = 'доступен'")).WithArgs("c-1", "o-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyCancelled, "alice", "передумал").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyRefunded, "alice", "750.00 RUB").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/o-1/cancel", strings.NewReader(`{"reason":"передумал"}`)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res struct {
+		Status string   `json:"status"`
+		Refund *Payment `json:"refund"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, statusCancelled, res.Status)
+	assert.Equal(t, paymentRefunded, res.Refund.Status)
+	assert.Equal(t, map[string]string{"event": "order_cancelled", "order_id": "o-1", "tenant_id": "shop-1", "status": statusCancelled},
+		published, "аналитика снимает заказ из активных")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDispatchLoadHandlerCountsOnlyOpenOrders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE completed_at IS NULL AND COALESCE(status, '') NOT IN ('возврат', 'отменён')")).
+		WithArgs("shop-1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("FROM couriers").WithArgs("shop-1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+	getDispatchLoadHandler(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"open_orders":3,"available_couriers":2}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAssignCourierRequiresPayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.PUT("/orders/:id/assign-courier", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+	}, assignCourierHandler)
+
+	expectOpenOrder(mock, "o-1", "", paymentUnpaid, 0)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/orders/o-1/assign-courier", strings.NewReader(`{"courier_id":"c-1"}`)))
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// maxPINAttempts — сколько раз курьер может ошибиться с PIN получателя, после чего
+// завершить заказ может только диспетчер (env DELIVERY_PIN_MAX_ATTEMPTS).
+var maxPINAttempts = 5
+
+// initPINConfig читает настройки проверки PIN из переменных окружения.
+func initPINConfig() {
+	if n, err := strconv.Atoi(os.Getenv("DELIVERY_PIN_MAX_ATTEMPTS")); err == nil && n > 0 {
+		maxPINAttempts = n
+	}
+}
+
+// reservePINAttempt занимает попытку ввода PIN до проверки и возвращает номер попытки.
+// Счётчик увеличивается условным UPDATE, поэтому параллельные запросы не проверят больше
+// maxPINAttempts PIN; ok == false — попытки исчерпаны.
+func reservePINAttempt(orderID string) (attempts int, ok bool, err error) {
+	err = db.QueryRow("UPDATE orders SET pin_attempts = pin_attempts + 1 WHERE id = $1 AND pin_attempts < $2 RETURNING pin_attempts",
+		orderID, maxPINAttempts).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return attempts, err == nil, err
+}
+
+// releasePINAttempt возвращает попытку, занятую верным PIN.
+func releasePINAttempt(orderID string) {
+	if _, err := db.Exec("UPDATE orders SET pin_attempts = pin_attempts - 1 WHERE id = $1 AND pin_attempts > 0", orderID); err != nil {
+		log.Printf("Ошибка возврата попытки PIN заказа %s: %v", orderID, err)
+	}
+}
+
+// authorizeDelivery решает, можно ли завершить заказ: курьер обязан назвать PIN получателя,
+// диспетчер может подтвердить вручение без PIN, указав причину. Причина пишется в историю заказа
+// в транзакции завершения (см. finishOrderHandler): отклонённое завершение не оставляет записи об обходе PIN.
+// Заказы, созданные до появления PIN (pinHash пустой), завершаются как раньше.
+// Возвращает, подтвердил ли получатель доставку PIN; при ok == false ответ уже отправлен, заказ остаётся в пути.
+func authorizeDelivery(c *gin.Context, claims *auth.Claims, orderID, pinHash string, attempts int, u podUpload) (confirmed, ok bool) {
+	if u.OverrideReason != "" {
+		if !auth.HasRole(claims, auth.RoleAdmin, auth.RoleDispatcher) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Подтвердить вручение без PIN может только диспетчер"})
+			return false, false
+		}
+		return false, true
+	}
+	if pinHash == "" {
+		if u.PIN != "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Для заказа не выдавался PIN"})
+			return false, false
+		}
+		return false, true
+	}
+	if u.PIN == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Укажите PIN получателя или причину подтверждения без PIN"})
+		return false, false
+	}
+	if attempts >= maxPINAttempts {
+		c.JSON(http.StatusLocked, gin.H{"error": "Попытки ввода PIN исчерпаны, требуется подтверждение диспетчера"})
+		return false, false
+	}
+	attempts, reserved, err := reservePINAttempt(orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false, false
+	}
+	if !reserved {
+		c.JSON(http.StatusLocked, gin.H{"error": "Попытки ввода PIN исчерпаны, требуется подтверждение диспетчера"})
+		return false, false
+	}
+	if verifyDeliveryPIN(orderID, u.PIN, pinHash) {
+		releasePINAttempt(orderID)
+		return true, true
+	}
+
+	if err := recordOrderHistory(orderID, historyPINFailed, claims.Subject, fmt.Sprintf("попытка %d из %d", attempts, maxPINAttempts)); err != nil {
+		log.Printf("Ошибка записи истории заказа %s: %v", orderID, err)
+	}
+	if attempts >= maxPINAttempts {
+		if err := recordOrderHistory(orderID, historyPINLocked, claims.Subject, ""); err != nil {
+			log.Printf("Ошибка записи истории заказа %s: %v", orderID, err)
+		}
+		c.JSON(http.StatusLocked, gin.H{"error": "Неверный PIN, попытки исчерпаны: требуется подтверждение диспетчера"})
+		return false, false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":              "Неверный PIN получателя",
+		"attempts_remaining": maxPINAttempts - attempts,
+	})
+	return false, false
+}
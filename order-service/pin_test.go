@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+func TestAuthorizeDelivery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(n int) { maxPINAttempts = n }(maxPINAttempts)
+	maxPINAttempts = 3
+
+	hash := hashDeliveryPIN("o-1", "123456")
+	courier := &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1"}
+	courier.Subject = "courier1"
+	dispatcher := &auth.Claims{Role: auth.RoleDispatcher}
+	dispatcher.Subject = "disp1"
+
+	run := func(claims *auth.Claims, pinHash string, attempts int, u podUpload) (bool, bool, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		confirmed, ok := authorizeDelivery(c, claims, "o-1", pinHash, attempts, u)
+		return confirmed, ok, w
+	}
+
+	reserve := regexp.QuoteMeta("UPDATE orders SET pin_attempts = pin_attempts + 1 WHERE id = $1 AND pin_attempts < $2")
+
+	// верный PIN: попытка занимается до проверки и возвращается
+	mock.ExpectQuery(reserve).WithArgs("o-1", 3).WillReturnRows(sqlmock.NewRows([]string{"pin_attempts"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET pin_attempts = pin_attempts - 1")).WithArgs("o-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	confirmed, ok, _ := run(courier, hash, 0, podUpload{PIN: "123456"})
+	assert.True(t, ok)
+	assert.True(t, confirmed)
+
+	_, ok, w := run(courier, hash, 0, podUpload{})
+	assert.False(t, ok, "без PIN заказ остаётся в пути")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	mock.ExpectQuery(reserve).WithArgs("o-1", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"pin_attempts"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyPINFailed, "courier1", "попытка 1 из 3").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	_, ok, w = run(courier, hash, 0, podUpload{PIN: "000000"})
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"attempts_remaining":2`)
+
+	mock.ExpectQuery(reserve).WithArgs("o-1", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"pin_attempts"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyPINFailed, "courier1", "попытка 3 из 3").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyPINLocked, "courier1", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	_, _, w = run(courier, hash, 2, podUpload{PIN: "000000"})
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	_, ok, w = run(courier, hash, 3, podUpload{PIN: "123456"})
+	assert.False(t, ok, "после исчерпания попыток не помогает и верный PIN")
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	// параллельные запросы прочитали 2 попытки, но последнюю уже занял другой: PIN не проверяется
+	mock.ExpectQuery(reserve).WithArgs("o-1", 3).WillReturnRows(sqlmock.NewRows([]string{"pin_attempts"}))
+	_, ok, w = run(courier, hash, 2, podUpload{PIN: "123456"})
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	_, ok, w = run(courier, hash, 3, podUpload{OverrideReason: "потерял письмо"})
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusForbidden, w.Code, "курьер не может обойти PIN")
+
+	// запись об обходе PIN — только в транзакции завершения
+	confirmed, ok, _ = run(dispatcher, hash, 3, podUpload{OverrideReason: "получатель подтвердил по телефону"})
+	assert.True(t, ok)
+	assert.False(t, confirmed)
+
+	confirmed, ok, _ = run(courier, "", 0, podUpload{})
+	assert.True(t, ok, "заказ, созданный до появления PIN, завершается как раньше")
+	assert.False(t, confirmed)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
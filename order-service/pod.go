@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// maxPODImageSize — предельный размер одного файла подтверждения доставки.
+const maxPODImageSize = 5 << 20
+
+// podImageTypes — допустимые типы изображений и расширения ключей в хранилище.
+// Тип определяется по содержимому файла, заголовку клиента не доверяем.
+var podImageTypes = map[string]string{"image/jpeg": "jpg", "image/png": "png"}
+
+// Файлы подтверждения доставки: поле multipart-формы и часть URL для скачивания.
+const (
+	podPhoto     = "photo"
+	podSignature = "signature"
+)
+
+// ProofOfDelivery — доказательства вручения посылки, собранные курьером при завершении заказа.
+type ProofOfDelivery struct {
+	OrderID            string    `json:"order_id"`
+	PhotoURL           string    `json:"photo_url,omitempty"`
+	SignatureURL       string    `json:"signature_url,omitempty"`
+	RecipientConfirmed bool      `json:"recipient_confirmed"` // получатель назвал PIN из письма
+	CollectedBy        string    `json:"collected_by"`        // логин завершившего заказ
+	CollectedAt        time.Time `json:"collected_at"`
+
+	photoKey     string
+	signatureKey string
+}
+
+// podImage — загруженное изображение с определённым по содержимому типом.
+type podImage struct {
+	data        []byte
+	contentType string
+}
+
+// podUpload — то, что курьер прислал в PUT /orders/:id/finish.
+type podUpload struct {
+	Photo     *podImage `json:"-" form:"-"`
+	Signature *podImage `json:"-" form:"-"`
+	PIN       string    `json:"pin" form:"pin"`
+	// OverrideReason — причина, по которой диспетчер подтверждает вручение без PIN (см. pin.go)
+	OverrideReason string `json:"override_reason" form:"override_reason"`
+	// CollectedAmount — наличные, полученные курьером по заказу с наложенным платежом (см. cash.go)
+	CollectedAmount *float64 `json:"collected_amount" form:"collected_amount"`
+}
+
+// hasEvidence — есть ли что сохранить в order_pod (причина подтверждения без PIN пишется в историю заказа).
+func (u podUpload) hasEvidence() bool {
+	return u.Photo != nil || u.Signature != nil || u.PIN != ""
+}
+
+// newDeliveryPIN — одноразовый шестизначный код, который получатель называет курьеру.
+func newDeliveryPIN() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashDeliveryPIN — в БД хранится только хеш PIN, привязанный к заказу.
+func hashDeliveryPIN(orderID, pin string) string {
+	sum := sha256.Sum256([]byte(orderID + ":" + pin))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyDeliveryPIN сравнивает PIN с сохранённым хешем за постоянное время.
+func verifyDeliveryPIN(orderID, pin, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	got := hashDeliveryPIN(orderID, strings.TrimSpace(pin))
+	return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1
+}
+
+// pinRecipient — адрес для письма с PIN: почта получателя, если указана, иначе отправителя.
+func pinRecipient(o Order) string {
+	if o.RecipientEmail != "" {
+		return o.RecipientEmail
+	}
+	return o.Email
+}
+
+// publishDeliveryPIN отправляет PIN получателю; письмо помечается как секретное,
+// поэтому notification-service не сохраняет его текст в журнале.
+func publishDeliveryPIN(o Order, pin string) error {
+	return publishEventToQueue("notifications", map[string]any{
+		"type":      "delivery_pin",
+		"recipient": pinRecipient(o),
+		"subject":   fmt.Sprintf("Код получения заказа %s", o.ID),
+		"message":   fmt.Sprintf("Назовите курьеру код %s при получении заказа %s.", pin, o.ID),
+		"tenant_id": o.TenantID,
+		"sensitive": true,
+	})
+}
+
+// readPODImage читает файл формы field; отсутствие файла — не ошибка.
+func readPODImage(form *multipart.Form, field string) (*podImage, error) {
+	files := form.File[field]
+	if len(files) == 0 {
+		return nil, nil
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxPODImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("файл %s пустой", field)
+	}
+	if len(data) > maxPODImageSize {
+		return nil, fmt.Errorf("файл %s больше %d МБ", field, maxPODImageSize>>20)
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := podImageTypes[contentType]; !ok {
+		return nil, fmt.Errorf("файл %s должен быть изображением JPEG или PNG", field)
+	}
+	return &podImage{data: data, contentType: contentType}, nil
+}
+
+// readPODUpload разбирает multipart-форму завершения заказа (photo, signature, pin, override_reason).
+// Без файлов pin и override_reason можно передать обычной формой или JSON; пустой запрос — завершение без доказательств.
+func readPODUpload(c *gin.Context) (podUpload, error) {
+	var u podUpload
+	switch c.ContentType() {
+	case "multipart/form-data":
+	case "application/x-www-form-urlencoded", "application/json":
+		if err := c.ShouldBind(&u); err != nil {
+			return u, errors.New("некорректные данные подтверждения доставки")
+		}
+		u.PIN, u.OverrideReason = strings.TrimSpace(u.PIN), strings.TrimSpace(u.OverrideReason)
+		return u, nil
+	default:
+		return u, nil
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 2*maxPODImageSize+1<<20)
+	form, err := c.MultipartForm()
+	if err != nil {
+		return u, errors.New("некорректная форма подтверждения доставки")
+	}
+	if u.Photo, err = readPODImage(form, podPhoto); err != nil {
+		return u, err
+	}
+	if u.Signature, err = readPODImage(form, podSignature); err != nil {
+		return u, err
+	}
+	if pins := form.Value["pin"]; len(pins) > 0 {
+		u.PIN = strings.TrimSpace(pins[0])
+	}
+	if reasons := form.Value["override_reason"]; len(reasons) > 0 {
+		u.OverrideReason = strings.TrimSpace(reasons[0])
+	}
+	if amounts := form.Value["collected_amount"]; len(amounts) > 0 {
+		amount, err := strconv.ParseFloat(strings.TrimSpace(amounts[0]), 64)
+		if err != nil {
+			return u, errors.New("collected_amount должна быть числом")
+		}
+		u.CollectedAmount = &amount
+	}
+	return u, nil
+}
+
+// podKey — ключ файла в хранилище.
+func podKey(orderID, kind string, img *podImage) string {
+	return fmt.Sprintf("pod/%s/%s.%s", orderID, kind, podImageTypes[img.contentType])
+}
+
+// savePOD записывает подтверждение доставки заказа через exec (транзакцию завершения заказа,
+// см. PUT /orders/:id/finish). Файлы сохраняются после commit (savePODFiles): откаченное завершение
+// не оставляет их в хранилище.
+func savePOD(exec execer, orderID, collectedBy string, pinConfirmed bool) error {
+	_, err := exec.Exec(`
+		INSERT INTO order_pod (order_id, pin_confirmed, collected_by, collected_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (order_id) DO UPDATE SET
+			photo_key = NULL, signature_key = NULL,
+			pin_confirmed = EXCLUDED.pin_confirmed, collected_by = EXCLUDED.collected_by, collected_at = EXCLUDED.collected_at`,
+		orderID, pinConfirmed, collectedBy)
+	return err
+}
+
+// savePODFiles кладёт фото и подпись завершённого заказа в хранилище и только затем записывает их ключи
+// в order_pod: ключ в БД всегда указывает на сохранённый файл.
+func savePODFiles(orderID string, u podUpload) error {
+	var photoKey, signatureKey string
+	if u.Photo != nil {
+		photoKey = podKey(orderID, podPhoto, u.Photo)
+		if err := blobs.Put(photoKey, u.Photo.data, u.Photo.contentType); err != nil {
+			return fmt.Errorf("сохранение фото: %w", err)
+		}
+	}
+	if u.Signature != nil {
+		signatureKey = podKey(orderID, podSignature, u.Signature)
+		if err := blobs.Put(signatureKey, u.Signature.data, u.Signature.contentType); err != nil {
+			return fmt.Errorf("сохранение подписи: %w", err)
+		}
+	}
+	if photoKey == "" && signatureKey == "" {
+		return nil
+	}
+	_, err := db.Exec(`UPDATE order_pod SET photo_key = NULLIF($2, ''), signature_key = NULLIF($3, '') WHERE order_id = $1`,
+		orderID, photoKey, signatureKey)
+	return err
+}
+
+// loadPOD возвращает подтверждение доставки заказа или nil, если его нет.
+func loadPOD(orderID string) (*ProofOfDelivery, error) {
+	p := ProofOfDelivery{OrderID: orderID}
+	err := db.QueryRow(`
+		SELECT COALESCE(photo_key, ''), COALESCE(signature_key, ''), pin_confirmed, collected_by, collected_at
+		FROM order_pod WHERE order_id = $1`, orderID).
+		Scan(&p.photoKey, &p.signatureKey, &p.RecipientConfirmed, &p.CollectedBy, &p.CollectedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.photoKey != "" {
+		p.PhotoURL = fmt.Sprintf("/orders/%s/pod/%s", orderID, podPhoto)
+	}
+	if p.signatureKey != "" {
+		p.SignatureURL = fmt.Sprintf("/orders/%s/pod/%s", orderID, podSignature)
+	}
+	return &p, nil
+}
+
+// loadAccessibleOrder загружает заказ из :id и проверяет доступ к нему; при отказе ответ уже отправлен.
+func loadAccessibleOrder(c *gin.Context) (Order, bool) {
+	o, status, err := findAccessibleOrder(auth.CurrentClaims(c), c.Param("id"))
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return o, false
+	}
+	return o, true
+}
+
+// findAccessibleOrder загружает заказ id с проверкой canAccessOrder; при ошибке возвращает и HTTP-статус.
+// Недоступный заказ (чужая организация, чужой клиент или курьер) неотличим от несуществующего — 404.
+func findAccessibleOrder(claims *auth.Claims, id string) (Order, int, error) {
+	o, err := scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE id = $1", id))
+	if err == sql.ErrNoRows || (err == nil && !canAccessOrder(claims, o)) {
+		return Order{}, http.StatusNotFound, errors.New("Заказ не найден")
+	}
+	if err != nil {
+		return o, http.StatusInternalServerError, err
+	}
+	return o, 0, nil
+}
+
+// GET /orders/:id/pod — подтверждение доставки со ссылками на фото и подпись.
+func getPODHandler(c *gin.Context) {
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	p, err := loadPOD(o.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Подтверждение доставки не найдено"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// GET /orders/:id/pod/:file — файл подтверждения доставки (photo или signature).
+func getPODFileHandler(c *gin.Context) {
+	o, ok := loadAccessibleOrder(c)
+	if !ok {
+		return
+	}
+	p, err := loadPOD(o.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var key string
+	if p != nil {
+		key = map[string]string{podPhoto: p.photoKey, podSignature: p.signatureKey}[c.Param("file")]
+	}
+	if key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+		return
+	}
+	data, err := blobs.Get(key)
+	if err != nil {
+		if errors.Is(err, errBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
+
+// addPODToPDF добавляет в отчёт по заказу раздел с подтверждением доставки и встраивает изображения.
+func addPODToPDF(pdf *gofpdf.Fpdf, p *ProofOfDelivery) {
+	pdf.Ln(4)
+	pdf.SetFont("DejaVu", "", 14)
+	pdf.Cell(0, 10, "Подтверждение доставки")
+	pdf.Ln(10)
+	pdf.SetFont("DejaVu", "", 12)
+	confirmed := "нет"
+	if p.RecipientConfirmed {
+		confirmed = "да (PIN получателя)"
+	}
+	pdf.Cell(0, 8, fmt.Sprintf("Получатель подтвердил: %s", confirmed))
+	pdf.Ln(8)
+	pdf.Cell(0, 8, fmt.Sprintf("Зафиксировано: %s, %s", p.CollectedAt.Format("2006-01-02 15:04"), p.CollectedBy))
+	pdf.Ln(10)
+
+	embed := func(title, key string, width float64) {
+		if key == "" {
+			return
+		}
+		data, err := blobs.Get(key)
+		if err != nil {
+			log.Printf("addPODToPDF: файл %s недоступен: %v", key, err)
+			return
+		}
+		imageType := "PNG"
+		if http.DetectContentType(data) == "image/jpeg" {
+			imageType = "JPG"
+		}
+		opts := gofpdf.ImageOptions{ImageType: imageType}
+		pdf.RegisterImageOptionsReader(key, opts, bytes.NewReader(data))
+		pdf.Cell(0, 8, title)
+		pdf.Ln(8)
+		pdf.ImageOptions(key, pdf.GetX(), pdf.GetY(), width, 0, true, opts, 0, "")
+		pdf.Ln(4)
+	}
+	embed("Фото вручения:", p.photoKey, 80)
+	embed("Подпись получателя:", p.signatureKey, 60)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// testPNG — минимальное корректное PNG-изображение.
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))))
+	return buf.Bytes()
+}
+
+// podRequest собирает multipart-запрос завершения заказа.
+func podRequest(t *testing.T, files map[string][]byte, pin string) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for field, data := range files {
+		part, err := w.CreateFormFile(field, field+".bin")
+		assert.NoError(t, err)
+		part.Write(data)
+	}
+	if pin != "" {
+		w.WriteField("pin", pin)
+	}
+	w.Close()
+	req := httptest.NewRequest(http.MethodPut, "/orders/1/finish", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestDeliveryPIN(t *testing.T) {
+	pin, err := newDeliveryPIN()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\d{6}$`, pin)
+
+	hash := hashDeliveryPIN("o-1", pin)
+	assert.NotContains(t, hash, pin)
+	assert.True(t, verifyDeliveryPIN("o-1", " "+pin+" ", hash))
+	assert.False(t, verifyDeliveryPIN("o-2", pin, hash), "PIN привязан к заказу")
+	assert.False(t, verifyDeliveryPIN("o-1", "000000x", hash))
+	assert.False(t, verifyDeliveryPIN("o-1", pin, ""), "заказу без PIN подтверждение не засчитывается")
+
+	assert.Equal(t, "r@example.com", pinRecipient(Order{Email: "s@example.com", RecipientEmail: "r@example.com"}))
+	assert.Equal(t, "s@example.com", pinRecipient(Order{Email: "s@example.com"}))
+}
+
+func TestReadPODUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	read := func(req *http.Request) (podUpload, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		return readPODUpload(c)
+	}
+
+	img := testPNG(t)
+	u, err := read(podRequest(t, map[string][]byte{podPhoto: img, podSignature: img}, "123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", u.Photo.contentType)
+	assert.Equal(t, img, u.Signature.data)
+	assert.Equal(t, "123456", u.PIN)
+
+	u, err = read(httptest.NewRequest(http.MethodPut, "/orders/1/finish", nil))
+	assert.NoError(t, err)
+	assert.False(t, u.hasEvidence(), "завершение без формы, как раньше")
+
+	req := httptest.NewRequest(http.MethodPut, "/orders/1/finish", strings.NewReader(`{"override_reason": " получатель без почты "}`))
+	req.Header.Set("Content-Type", "application/json")
+	u, err = read(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "получатель без почты", u.OverrideReason)
+
+	req = httptest.NewRequest(http.MethodPut, "/orders/1/finish", strings.NewReader("pin=654321"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	u, err = read(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "654321", u.PIN)
+
+	_, err = read(podRequest(t, map[string][]byte{podPhoto: []byte("<html>not an image</html>")}, ""))
+	assert.ErrorContains(t, err, "JPEG или PNG")
+
+	_, err = read(podRequest(t, map[string][]byte{podPhoto: append(img, make([]byte, maxPODImageSize)...)}, ""))
+	assert.Error(t, err, "слишком большой файл")
+}
+
+// memBlobStore — хранилище в памяти для тестов.
+type memBlobStore map[string][]byte
+
+func (m memBlobStore) Put(key string, data []byte, _ string) error {
+	m[key] = data
+	return nil
+}
+
+func (m memBlobStore) Get(key string) ([]byte, error) {
+	if data, ok := m[key]; ok {
+		return data, nil
+	}
+	return nil, errBlobNotFound
+}
+
+func TestSaveAndLoadPOD(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(b BlobStore) { blobs = b }(blobs)
+	store := memBlobStore{}
+	blobs = store
+
+	img := testPNG(t)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO order_pod")).
+		WithArgs("o-1", true, "courier1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, savePOD(db, "o-1", "courier1", true))
+	assert.Empty(t, store, "файлы пишутся только после commit")
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE order_pod SET photo_key")).
+		WithArgs("o-1", "pod/o-1/photo.png", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, savePODFiles("o-1", podUpload{Photo: &podImage{data: img, contentType: "image/png"}, PIN: "123456"}))
+	assert.Equal(t, img, store["pod/o-1/photo.png"])
+	assert.NoError(t, savePODFiles("o-1", podUpload{PIN: "123456"}), "без файлов ключи не трогаем")
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM order_pod WHERE order_id = $1")).WithArgs("o-1").
+		WillReturnRows(sqlmock.NewRows([]string{"photo_key", "signature_key", "pin_confirmed", "collected_by", "collected_at"}).
+			AddRow("pod/o-1/photo.png", "", true, "courier1", at))
+	p, err := loadPOD("o-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "/orders/o-1/pod/photo", p.PhotoURL)
+	assert.Empty(t, p.SignatureURL)
+	assert.True(t, p.RecipientConfirmed)
+
+	// изображения встраиваются в PDF-отчёт
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.AddUTF8Font("DejaVu", "", "assets/fonts/DejaVuSerif.ttf")
+	addPODToPDF(pdf, p)
+	assert.NoError(t, pdf.Error())
+	var out bytes.Buffer
+	assert.NoError(t, pdf.Output(&out))
+	assert.Contains(t, out.String(), "/Subtype /Image")
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM order_pod")).WithArgs("o-2").
+		WillReturnRows(sqlmock.NewRows([]string{"photo_key"}))
+	p, err = loadPOD("o-2")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// testOrderColumns — колонки orderColumns в порядке scanOrder.
+var testOrderColumns = []string{"id", "sender_name", "recipient_name", "address_from", "address_to", "status", "created_at", "completed_at",
+	"weight", "length", "width", "height", "urgency", "courier_id", "window_start", "window_end",
+	"email", "promo_code", "currency", "created_by", "tenant_id", "tracking_token",
+	"from_lat", "from_lng", "to_lat", "to_lng", "eta", "due_at", "sla_status", "recipient_email",
+	"failed_attempts", "return_of", "return_order_id", "payment_method", "cod_amount",
+	"price", "price_currency", "payment_status", "client_ref"}
+
+// expectTestOrder ожидает выборку заказа через orderColumns.
+func expectTestOrder(mock sqlmock.Sqlmock, id, tenantID string) {
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs(id).WillReturnRows(sqlmock.NewRows(testOrderColumns).AddRow(
+		id, "a", "b", "x", "y", "завершён", time.Now(), time.Now(), 1.0, 1.0, 1.0, 1.0, 1, "c-1", nil, nil,
+		"", "", "", "", tenantID, "", nil, nil, nil, nil, nil, nil, "", "", 0, "", "", paymentPrepaid, 0.0,
+		0.0, "", "", ""))
+}
+
+func TestGetPODFileHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(b BlobStore) { blobs = b }(blobs)
+	img := testPNG(t)
+	blobs = memBlobStore{"pod/o-1/signature.png": img}
+
+	r := gin.New()
+	r.GET("/orders/:id/pod/:file", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+	}, getPODFileHandler)
+
+	expectOrder := func() {
+		expectTestOrder(mock, "o-1", "shop-1")
+		mock.ExpectQuery("FROM order_pod").WithArgs("o-1").WillReturnRows(
+			sqlmock.NewRows([]string{"photo_key", "signature_key", "pin_confirmed", "collected_by", "collected_at"}).
+				AddRow("", "pod/o-1/signature.png", false, "courier1", time.Now()))
+	}
+
+	expectOrder()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/o-1/pod/signature", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, img, w.Body.Bytes())
+
+	expectOrder()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/o-1/pod/photo", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "фото не загружали")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFinishOrderHandlerRejectsRepeat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+
+	r := gin.New()
+	r.PUT("/orders/:id/finish", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"})
+	}, finishOrderHandler)
+	finishRow := func(completed bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"courier_id", "tenant_id", "pin_hash", "pin_attempts", "status", "payment_method", "cod_amount", "currency", "completed"}).
+			AddRow("c-1", "shop-1", "", 0, "в пути", paymentCOD, 500.0, "RUB", completed)
+	}
+
+	finishReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/orders/o-1/finish", strings.NewReader(`{"collected_amount": 500}`))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	// уже завершён — ни кассы, ни доказательств
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs("o-1").WillReturnRows(finishRow(true))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, finishReq())
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// параллельное завершение успело раньше: условная запись не проходит, транзакция откатывается
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs("o-1").WillReturnRows(finishRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET status = \\$1, completed_at = NOW\\(\\) WHERE id = \\$2 AND completed_at IS NULL").
+		WithArgs("завершён", "o-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, finishReq())
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFinishOrderHandlerKeepsFilesOnRollback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	defer func(b BlobStore) { blobs = b }(blobs)
+	store := memBlobStore{}
+	blobs = store
+
+	r := gin.New()
+	r.PUT("/orders/:id/finish", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Role: auth.RoleCourier, CourierID: "c-1", TenantID: "shop-1"})
+	}, finishOrderHandler)
+
+	// подтверждение записано, но освобождение курьера упало — транзакция откатывается, файлов в хранилище нет
+	mock.ExpectQuery("FROM orders WHERE id").WithArgs("1").WillReturnRows(
+		sqlmock.NewRows([]string{"courier_id", "tenant_id", "pin_hash", "pin_attempts", "status", "payment_method", "cod_amount", "currency", "completed"}).
+			AddRow("c-1", "shop-1", "", 0, "в пути", paymentPrepaid, 0.0, "RUB", false))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET status = \\$1, completed_at = NOW\\(\\)").WithArgs("завершён", "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_pod").WithArgs("1", false, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE couriers SET status").WithArgs("c-1", "1").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, podRequest(t, map[string][]byte{podPhoto: testPNG(t)}, ""))
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	assert.Empty(t, store)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFinishOrderHandlerRecordsOverrideOnCommit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	t.Setenv("RABBITMQ_URL", "")
+
+	claims := &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"}
+	claims.Subject = "disp1"
+	r := gin.New()
+	r.PUT("/orders/:id/finish", func(c *gin.Context) { c.Set("claims", claims) }, finishOrderHandler)
+	expectFinishRow := func(method string) {
+		mock.ExpectQuery("FROM orders WHERE id").WithArgs("o-1").WillReturnRows(
+			sqlmock.NewRows([]string{"courier_id", "tenant_id", "pin_hash", "pin_attempts", "status", "payment_method", "cod_amount", "currency", "completed"}).
+				AddRow("c-1", "shop-1", "hash", 0, "в пути", method, 500.0, "RUB", false))
+	}
+	finish := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/orders/o-1/finish", strings.NewReader(`{"override_reason":"подтвердил по телефону"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// завершение отклонено до транзакции (нет суммы наложенного платежа) — записи об обходе PIN нет
+	expectFinishRow(paymentCOD)
+	assert.Equal(t, http.StatusUnprocessableEntity, finish().Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// транзакция откатилась — запись откатывается вместе с ней
+	expectFinishRow(paymentPrepaid)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET status = \\$1, completed_at = NOW\\(\\)").WithArgs("завершён", "o-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.Equal(t, http.StatusConflict, finish().Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// запись об обходе PIN — в транзакции завершения
+	expectFinishRow(paymentPrepaid)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders SET status = \\$1, completed_at = NOW\\(\\)").WithArgs("завершён", "o-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs("o-1", historyPINOverride, "disp1", "подтвердил по телефону").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE couriers SET status").WithArgs("c-1", "o-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	finish()
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
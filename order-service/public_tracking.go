@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// publicTrackingURL — адрес страницы отслеживания для ссылок в письмах; токен дописывается в конец
+// (env PUBLIC_TRACKING_URL, пусто — ссылка в письма не добавляется).
+var publicTrackingURL = "http://localhost:3000/track/"
+
+// Статусы для получателя: внутренние статусы заказа наружу не отдаются.
+const (
+	publicStatusAccepted  = "принят"
+	publicStatusInTransit = "в пути"
+	publicStatusDelivered = "доставлен"
+	publicStatusReturned  = "возвращён отправителю"
+	publicStatusCancelled = "отменён"
+)
+
+// Position — последние координаты курьера из tracking-service.
+type Position struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PublicTracking — то, что видит получатель по ссылке: без адресов, контактов и данных отправителя.
+type PublicTracking struct {
+	OrderID     string     `json:"order_id"`
+	Status      string     `json:"status"`
+	ETA         *time.Time `json:"eta,omitempty"`
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	CourierName string     `json:"courier_name,omitempty"` // только имя, без фамилии
+	Position    *Position  `json:"position,omitempty"`     // только пока заказ в пути
+}
+
+// newTrackingToken — 128 случайных бит: ссылку нельзя подобрать перебором.
+func newTrackingToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// trackingLinkText — строка со ссылкой отслеживания для текста письма.
+func trackingLinkText(token string) string {
+	if publicTrackingURL == "" || token == "" {
+		return ""
+	}
+	return " Отслеживать доставку: " + publicTrackingURL + token
+}
+
+// publicStatus сводит внутренний статус заказа к статусу для получателя.
+func publicStatus(o Order) string {
+	switch {
+	case o.Status == statusReturned:
+		return publicStatusReturned
+	case o.Status == statusCancelled:
+		return publicStatusCancelled
+	case o.CompletedAt.Valid || o.Status == "завершён":
+		return publicStatusDelivered
+	case o.CourierID != "" || o.Status == publicStatusInTransit:
+		return publicStatusInTransit
+	}
+	return publicStatusAccepted
+}
+
+// firstName оставляет от ФИО курьера только имя (первое слово).
+func firstName(name string) string {
+	if fields := strings.Fields(name); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// buildPublicTracking формирует ответ публичной страницы из заказа и имени курьера.
+func buildPublicTracking(o Order, courierName string) PublicTracking {
+	t := PublicTracking{
+		OrderID:     o.ID,
+		Status:      publicStatus(o),
+		WindowStart: o.WindowStart,
+		WindowEnd:   o.WindowEnd,
+	}
+	if t.Status == publicStatusReturned || t.Status == publicStatusCancelled {
+		// заказ закрыт без вручения: ни срока, ни курьера
+		return t
+	}
+	if o.CompletedAt.Valid {
+		t.DeliveredAt = &o.CompletedAt.Time
+	} else if t.ETA = o.ETA; t.ETA == nil {
+		// пока ETA не рассчитан, ориентир для получателя — конец выбранного окна доставки
+		t.ETA = o.WindowEnd
+	}
+	if t.Status != publicStatusAccepted {
+		t.CourierName = firstName(courierName)
+	}
+	return t
+}
+
+// courierPosition запрашивает координаты курьера; переменная — для подмены в тестах.
+var courierPosition = fetchCourierPosition
+
+// fetchCourierPosition берёт последние координаты курьера из tracking-service (TRACKING_URL).
+func fetchCourierPosition(courierID, tenantID string) (*Position, error) {
+	trackingURL := os.Getenv("TRACKING_URL")
+	if trackingURL == "" {
+		return nil, nil
+	}
+	var p Position
+	endpoint := trackingURL + "/couriers/tracking/" + url.PathEscape(courierID) + "?tenant_id=" + url.QueryEscape(tenantID)
+	if err := getJSON(endpoint, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GET /public/track/:token — статус заказа для получателя. Неизвестный токен — 404,
+// чтобы по ответу нельзя было отличить несуществующий заказ от неверной ссылки.
+func publicTrackHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	token := c.Param("token")
+	if token == "" || len(token) > 64 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Заказ не найден"})
+		return
+	}
+	o, err := scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE tracking_token = $1", token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Заказ не найден"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заказа"})
+		return
+	}
+
+	var courierName string
+	if o.CourierID != "" {
+		if err := db.QueryRow("SELECT name FROM couriers WHERE id = $1", o.CourierID).Scan(&courierName); err != nil && err != sql.ErrNoRows {
+			log.Printf("publicTrackHandler: не удалось получить курьера %s: %v", o.CourierID, err)
+		}
+	}
+	t := buildPublicTracking(o, courierName)
+	if t.Status == publicStatusInTransit && o.CourierID != "" {
+		// без координат страница всё равно показывает статус
+		if t.Position, err = courierPosition(o.CourierID, o.TenantID); err != nil {
+			log.Printf("publicTrackHandler: координаты курьера %s недоступны: %v", o.CourierID, err)
+		}
+	}
+	c.JSON(http.StatusOK, t)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTrackingToken(t *testing.T) {
+	a, err := newTrackingToken()
+	assert.NoError(t, err)
+	b, _ := newTrackingToken()
+	assert.Len(t, a, 22)
+	assert.NotEqual(t, a, b)
+}
+
+func TestTrackingLinkText(t *testing.T) {
+	defer func(u string) { publicTrackingURL = u }(publicTrackingURL)
+
+	publicTrackingURL = "https://track.example.com/t/"
+	assert.Equal(t, " Отслеживать доставку: https://track.example.com/t/abc", trackingLinkText("abc"))
+	assert.Empty(t, trackingLinkText(""))
+	publicTrackingURL = ""
+	assert.Empty(t, trackingLinkText("abc"), "без PUBLIC_TRACKING_URL ссылка не добавляется")
+}
+
+func TestBuildPublicTracking(t *testing.T) {
+	end := time.Now().Add(2 * time.Hour)
+	o := Order{ID: "1", Status: "новый", SenderName: "ООО Ромашка", AddressTo: "ул. Ленина, 1", Email: "a@example.com", WindowEnd: &end}
+
+	pt := buildPublicTracking(o, "")
+	assert.Equal(t, publicStatusAccepted, pt.Status)
+	assert.Equal(t, &end, pt.ETA)
+	assert.Empty(t, pt.CourierName)
+
+	o.CourierID = "c-1"
+	pt = buildPublicTracking(o, "Иван Петров")
+	assert.Equal(t, publicStatusInTransit, pt.Status)
+	assert.Equal(t, "Иван", pt.CourierName)
+
+	o.Status = "завершён"
+	o.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	pt = buildPublicTracking(o, "Иван Петров")
+	assert.Equal(t, publicStatusDelivered, pt.Status)
+	assert.Nil(t, pt.ETA)
+	assert.NotNil(t, pt.DeliveredAt)
+
+	for status, public := range map[string]string{statusReturned: publicStatusReturned, statusCancelled: publicStatusCancelled} {
+		closed := Order{ID: "2", Status: status, CourierID: "c-1", WindowEnd: &end}
+		pt := buildPublicTracking(closed, "Иван Петров")
+		assert.Equal(t, public, pt.Status, "закрытый заказ не показывается получателю принятым")
+		assert.Nil(t, pt.ETA)
+		assert.Empty(t, pt.CourierName)
+	}
+
+	// в ответе нет адресов, контактов и отправителя
+	body, _ := json.Marshal(pt)
+	for _, secret := range []string{"Ромашка", "Ленина", "a@example.com", "Петров"} {
+		assert.NotContains(t, string(body), secret)
+	}
+}
+
+func TestFetchCourierPosition(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/couriers/tracking/c-1", r.URL.Path)
+		assert.Equal(t, "shop-1", r.URL.Query().Get("tenant_id"))
+		_, _ = w.Write([]byte(`{"courier_id":"c-1","latitude":55.75,"longitude":37.61,"updated_at":"2024-05-01T10:00:00Z"}`))
+	}))
+	defer srv.Close()
+	t.Setenv("TRACKING_URL", srv.URL)
+	t.Setenv("AUTH_URL", "")
+
+	p, err := fetchCourierPosition("c-1", "shop-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 55.75, p.Latitude)
+	assert.Equal(t, 37.61, p.Longitude)
+
+	t.Setenv("TRACKING_URL", "")
+	p, err = fetchCourierPosition("c-1", "shop-1")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
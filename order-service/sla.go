@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slaByUrgency — обещанный срок доставки от создания заказа по уровню срочности
+// (env SLA_BY_URGENCY, формат "1=24h,2=3h"). Для срочности без SLA due_at не ставится.
+var slaByUrgency = map[int]time.Duration{1: 24 * time.Hour, 2: 3 * time.Hour}
+
+var (
+	// slaAtRiskBefore — за сколько до due_at незавершённый заказ считается под угрозой (env SLA_AT_RISK_BEFORE).
+	slaAtRiskBefore = 30 * time.Minute
+	// slaCheckInterval — период проверки сроков (env SLA_CHECK_INTERVAL).
+	slaCheckInterval = time.Minute
+)
+
+// Значения orders.sla_status: события публикуются один раз при переходе в статус.
+const (
+	slaStatusAtRisk   = "at_risk"
+	slaStatusBreached = "breached"
+)
+
+// parseSLAConfig разбирает строку вида "1=24h,2=3h".
+func parseSLAConfig(s string) (map[int]time.Duration, error) {
+	sla := map[int]time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("ожидается <срочность>=<срок>: %q", part)
+		}
+		urgency, err := strconv.Atoi(strings.TrimSpace(k))
+		if err != nil {
+			return nil, fmt.Errorf("некорректная срочность %q", k)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("некорректный срок %q", v)
+		}
+		sla[urgency] = d
+	}
+	return sla, nil
+}
+
+// initSLAConfig читает настройки SLA из переменных окружения.
+func initSLAConfig() {
+	if s := os.Getenv("SLA_BY_URGENCY"); s != "" {
+		sla, err := parseSLAConfig(s)
+		if err != nil {
+			log.Fatalf("SLA_BY_URGENCY: %v", err)
+		}
+		slaByUrgency = sla
+	}
+	if d, err := time.ParseDuration(os.Getenv("SLA_AT_RISK_BEFORE")); err == nil && d > 0 {
+		slaAtRiskBefore = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SLA_CHECK_INTERVAL")); err == nil && d > 0 {
+		slaCheckInterval = d
+	}
+}
+
+// slaDueAt — обещанное время доставки: конец выбранного окна, иначе created_at + SLA по срочности.
+func slaDueAt(o Order) *time.Time {
+	if o.WindowEnd != nil {
+		due := *o.WindowEnd
+		return &due
+	}
+	d, ok := slaByUrgency[o.Urgency]
+	if !ok {
+		return nil
+	}
+	due := o.CreatedAt.Add(d)
+	return &due
+}
+
+// publishSLAEvent публикует событие SLA в одноимённую очередь; переменная — для подмены в тестах.
+var publishSLAEvent = publishEventToQueue
+
+// slaEvent — заказ, перешедший в новый статус SLA.
+type slaEvent struct {
+	OrderID   string
+	CourierID string
+	TenantID  string
+	DueAt     time.Time
+	ETA       *time.Time
+}
+
+func (e slaEvent) payload(event string) map[string]string {
+	p := map[string]string{
+		"event":      event,
+		"order_id":   e.OrderID,
+		"courier_id": e.CourierID,
+		"tenant_id":  e.TenantID,
+		"due_at":     e.DueAt.Format(time.RFC3339),
+	}
+	if e.ETA != nil {
+		p["eta"] = e.ETA.Format(time.RFC3339)
+	}
+	return p
+}
+
+// markSLA переводит заказы, подходящие под условие cond, в статус status и возвращает их.
+// UPDATE ... RETURNING атомарен, поэтому событие по заказу публикуется один раз даже при нескольких экземплярах сервиса.
+func markSLA(status, cond string, args ...any) ([]slaEvent, error) {
+	rows, err := db.Query(`
+		UPDATE orders SET sla_status = '`+status+`'
+		WHERE completed_at IS NULL AND due_at IS NOT NULL AND COALESCE(status, '') NOT IN ('`+statusReturned+`', '`+statusCancelled+`') AND `+cond+`
+		RETURNING id, COALESCE(courier_id, ''), tenant_id, due_at, eta`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []slaEvent
+	for rows.Next() {
+		var e slaEvent
+		if err := rows.Scan(&e.OrderID, &e.CourierID, &e.TenantID, &e.DueAt, &e.ETA); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// checkSLA публикует order.sla_breached для просроченных заказов и order.sla_at_risk для тех,
+// у кого до due_at осталось меньше slaAtRiskBefore или ETA позже due_at.
+func checkSLA(now time.Time) error {
+	breached, err := markSLA(slaStatusBreached, "due_at <= $1 AND sla_status IS DISTINCT FROM 'breached'", now)
+	if err != nil {
+		return fmt.Errorf("поиск просроченных заказов: %w", err)
+	}
+	for _, e := range breached {
+		if err := publishSLAEvent("order.sla_breached", e.payload("order.sla_breached")); err != nil {
+			log.Printf("Ошибка публикации order.sla_breached по заказу %s: %v", e.OrderID, err)
+		}
+	}
+
+	atRisk, err := markSLA(slaStatusAtRisk, "sla_status IS NULL AND due_at > $1 AND (due_at <= $2 OR eta > due_at)",
+		now, now.Add(slaAtRiskBefore))
+	if err != nil {
+		return fmt.Errorf("поиск заказов под угрозой: %w", err)
+	}
+	for _, e := range atRisk {
+		if err := publishSLAEvent("order.sla_at_risk", e.payload("order.sla_at_risk")); err != nil {
+			log.Printf("Ошибка публикации order.sla_at_risk по заказу %s: %v", e.OrderID, err)
+		}
+	}
+	if len(breached)+len(atRisk) > 0 {
+		log.Printf("Проверка SLA: просрочено %d, под угрозой %d", len(breached), len(atRisk))
+	}
+	return nil
+}
+
+// startSLAChecker раз в slaCheckInterval проверяет сроки незавершённых заказов.
+func startSLAChecker() {
+	go func() {
+		for {
+			if err := checkSLA(time.Now()); err != nil {
+				log.Printf("Проверка SLA: %v", err)
+			}
+			time.Sleep(slaCheckInterval)
+		}
+	}()
+}
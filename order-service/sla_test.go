@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSLAConfig(t *testing.T) {
+	sla, err := parseSLAConfig("1=24h, 2=90m")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]time.Duration{1: 24 * time.Hour, 2: 90 * time.Minute}, sla)
+
+	for _, bad := range []string{"1", "x=1h", "1=soon", "2=-1h"} {
+		_, err := parseSLAConfig(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSLADueAt(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, created.Add(slaByUrgency[2]), *slaDueAt(Order{Urgency: 2, CreatedAt: created}))
+	assert.Nil(t, slaDueAt(Order{Urgency: 7, CreatedAt: created}), "срочность без SLA")
+
+	end := created.Add(5 * time.Hour)
+	assert.Equal(t, end, *slaDueAt(Order{Urgency: 2, CreatedAt: created, WindowEnd: &end}), "выбранное окно важнее SLA")
+}
+
+func TestCheckSLA(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer mockDB.Close()
+	db = mockDB
+	published := map[string][]any{}
+	publishSLAEvent = func(queue string, payload any) error {
+		published[queue] = append(published[queue], payload)
+		return nil
+	}
+	defer func() { publishSLAEvent = publishEventToQueue }()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(-time.Minute)
+	eta := now.Add(time.Hour)
+	cols := []string{"id", "courier_id", "tenant_id", "due_at", "eta"}
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE orders SET sla_status = 'breached'")).
+		WithArgs(now).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("o-1", "c-1", "shop-1", due, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE orders SET sla_status = 'at_risk'")).
+		WithArgs(now, now.Add(slaAtRiskBefore)).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("o-2", "c-2", "shop-1", now.Add(20*time.Minute), eta))
+
+	assert.NoError(t, checkSLA(now))
+	assert.Equal(t, []any{map[string]string{
+		"event": "order.sla_breached", "order_id": "o-1", "courier_id": "c-1", "tenant_id": "shop-1",
+		"due_at": due.Format(time.RFC3339),
+	}}, published["order.sla_breached"])
+	assert.Len(t, published["order.sla_at_risk"], 1)
+	assert.Equal(t, eta.Format(time.RFC3339), published["order.sla_at_risk"][0].(map[string]string)["eta"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,651 @@
+// Package auth — проверка JWT и API-ключей, роли и организации пользователя.
+// Общий для всех сервисов: каждый подключает его через replace в своём go.mod.
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Роли пользователей. Роль выдаёт auth-service и передаёт в JWT.
+const (
+	RoleAdmin      = "admin"
+	RoleDispatcher = "dispatcher"
+	RoleCourier    = "courier"
+	RoleCustomer   = "customer"
+	RoleAnalyst    = "analyst"
+	RoleService    = "service"    // внутренние вызовы между сервисами (client credentials)
+	RoleAPIClient  = "api_client" // B2B-клиент с API-ключом (заголовок X-API-Key)
+)
+
+// Права (scopes) API-ключей. Пользователи и сервисы ограничиваются ролью, ключи — ещё и scope.
+const (
+	ScopeOrdersRead  = "orders:read"
+	ScopeOrdersWrite = "orders:write"
+)
+
+// Claims — содержимое JWT, выпускаемого auth-service.
+type Claims struct {
+	Role      string `json:"role"`
+	CourierID string `json:"courier_id,omitempty"` // для роли courier — запись курьера в order-service
+	// Организация (арендатор), данные которой видит пользователь; пусто у администратора платформы
+	TenantID string   `json:"tenant_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"` // для роли api_client — права ключа
+	jwt.StandardClaims
+}
+
+// DefaultTenant — организация, к которой отнесены данные, созданные до появления организаций.
+const DefaultTenant = "default"
+
+var ErrInvalidToken = errors.New("некорректный токен")
+
+// BearerToken извлекает токен из заголовка Authorization ("Bearer <token>" или просто токен).
+// ?token= принимается только на маршрутах с AllowQueryToken.
+func BearerToken(c *gin.Context) string {
+	header := strings.TrimSpace(c.GetHeader("Authorization"))
+	if header == "" {
+		if c.GetBool(queryTokenKey) {
+			return c.Query("token")
+		}
+		return ""
+	}
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return header
+}
+
+// queryTokenKey — ключ контекста, которым AllowQueryToken разрешает токен в URL.
+const queryTokenKey = "allow_query_token"
+
+// AllowQueryToken разрешает передать токен в ?token=; ставится перед Required.
+// Только для WebSocket: браузер не может передать заголовок при открытии соединения,
+// а токен из URL попадает в журналы доступа, прокси и историю браузера.
+func AllowQueryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(queryTokenKey, true)
+		c.Next()
+	}
+}
+
+// ParseToken проверяет подпись (по открытому ключу из JWKS с нужным kid) и срок действия токена.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := LookupKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// алгоритм должен соответствовать типу ключа, иначе возможна подмена alg
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if t.Method != jwt.SigningMethodRS256 {
+				return nil, fmt.Errorf("неожиданный алгоритм подписи: %v", t.Header["alg"])
+			}
+		case ed25519.PublicKey:
+			if t.Method != jwt.SigningMethodEdDSA {
+				return nil, fmt.Errorf("неожиданный алгоритм подписи: %v", t.Header["alg"])
+			}
+		default:
+			return nil, fmt.Errorf("неподдерживаемый тип ключа %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	if claims.Id != "" && TokenRevoked(claims.Id) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// JWK — открытый ключ в формате JSON Web Key (RFC 7517): RSA или Ed25519 (OKP).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// PublicKey восстанавливает открытый ключ из JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("неподдерживаемая кривая %q", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %q", k.Kty)
+}
+
+// keySet — кэш открытых ключей auth-service по kid, загружаемый из AUTH_URL/.well-known/jwks.json.
+// Ключ с неизвестным kid (после ротации) вызывает повторную загрузку, но не чаще jwksMinRefresh.
+type keySet struct {
+	mu          sync.RWMutex
+	fetchMu     sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+var (
+	jwks           = &keySet{keys: map[string]crypto.PublicKey{}}
+	jwksTTL        = 10 * time.Minute
+	jwksMinRefresh = 30 * time.Second
+	jwksClient     = &http.Client{Timeout: 5 * time.Second}
+)
+
+// LookupKey возвращает открытый ключ по kid. Сам auth-service подменяет его своими ключами.
+var LookupKey = jwks.lookup
+
+func (s *keySet) get(kid string) (crypto.PublicKey, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok, time.Since(s.fetchedAt) < jwksTTL
+}
+
+func (s *keySet) lookup(kid string) (crypto.PublicKey, error) {
+	key, ok, fresh := s.get(kid)
+	if ok && fresh {
+		return key, nil
+	}
+	s.fetchMu.Lock()
+	if time.Since(s.lastAttempt) >= jwksMinRefresh {
+		s.lastAttempt = time.Now()
+		if err := s.fetch(); err != nil {
+			log.Printf("Не удалось загрузить JWKS: %v", err)
+		}
+	}
+	s.fetchMu.Unlock()
+	// при недоступности auth-service продолжаем проверять токены ранее загруженными ключами
+	if key, ok, _ = s.get(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+}
+
+func (s *keySet) fetch() error {
+	authURL := os.Getenv("AUTH_URL")
+	if authURL == "" {
+		return errors.New("AUTH_URL не задан")
+	}
+	resp, err := jwksClient.Get(authURL + "/.well-known/jwks.json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth-service ответил %s", resp.Status)
+	}
+	var body struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+	for _, k := range body.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			log.Printf("Пропущен ключ %s из JWKS: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	s.set(keys)
+	return nil
+}
+
+// set заменяет набор ключей целиком.
+func (s *keySet) set(keys map[string]crypto.PublicKey) {
+	s.mu.Lock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+}
+
+// serviceTokenCache хранит токен этого сервиса для вызовов других сервисов.
+type serviceTokenCache struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+var serviceTokens = &serviceTokenCache{}
+
+// ServiceAuthHeader возвращает заголовок Authorization для внутреннего вызова от имени сервиса.
+// Токен выдаёт auth-service по SERVICE_CLIENT_ID/SERVICE_CLIENT_SECRET (grant_type=client_credentials)
+// и обновляется заранее, до истечения. При ошибке возвращается пустая строка — вызов уйдёт без токена и получит 401.
+func ServiceAuthHeader() string {
+	token, err := serviceTokens.get()
+	if err != nil {
+		log.Printf("Не удалось получить сервисный токен: %v", err)
+		return ""
+	}
+	return "Bearer " + token
+}
+
+func (s *serviceTokenCache) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expiresAt) > time.Minute {
+		return s.token, nil
+	}
+	clientID, secret, authURL := os.Getenv("SERVICE_CLIENT_ID"), os.Getenv("SERVICE_CLIENT_SECRET"), os.Getenv("AUTH_URL")
+	if clientID == "" || secret == "" || authURL == "" {
+		return "", errors.New("не заданы SERVICE_CLIENT_ID, SERVICE_CLIENT_SECRET или AUTH_URL")
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, authURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("auth-service ответил %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	s.token = body.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// revocationList — кэш jti отозванных токенов, загружаемый из auth-service.
+type revocationList struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+var revokedTokens = &revocationList{ids: map[string]struct{}{}}
+
+// TokenRevoked сообщает, отозван ли токен. По умолчанию проверяется кэш;
+// сам auth-service подменяет проверку запросом к своей БД.
+var TokenRevoked = revokedTokens.contains
+
+func (l *revocationList) contains(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.ids[jti]
+	return ok
+}
+
+func (l *revocationList) replace(ids []string) {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	l.mu.Lock()
+	l.ids = set
+	l.mu.Unlock()
+}
+
+// StartRevocationSync периодически загружает список отозванных токенов из AUTH_URL/token/revoked.
+// Интервал задаётся REVOCATION_SYNC_INTERVAL (по умолчанию 30s). Без AUTH_URL ничего не делает.
+func StartRevocationSync() {
+	authURL := os.Getenv("AUTH_URL")
+	if authURL == "" {
+		return
+	}
+	interval := 30 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("REVOCATION_SYNC_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	go func() {
+		for {
+			if err := syncRevocations(client, authURL+"/token/revoked"); err != nil {
+				log.Printf("Не удалось обновить список отозванных токенов: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func syncRevocations(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth-service ответил %s", resp.Status)
+	}
+	var body struct {
+		Revoked []string `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	revokedTokens.replace(body.Revoked)
+	return nil
+}
+
+// APIKeyInfo — результат проверки API-ключа в auth-service (POST /api-keys/introspect).
+type APIKeyInfo struct {
+	Active    bool     `json:"active"`
+	KeyID     string   `json:"key_id,omitempty"`
+	TenantID  string   `json:"tenant_id,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	RateLimit int      `json:"rate_limit,omitempty"` // запросов в минуту, 0 — без ограничения
+}
+
+// claims представляет ключ как пользователя с ролью api_client; subject — "apikey:<id>".
+func (k APIKeyInfo) claims() *Claims {
+	return &Claims{
+		Role:           RoleAPIClient,
+		TenantID:       k.TenantID,
+		Scopes:         k.Scopes,
+		StandardClaims: jwt.StandardClaims{Subject: "apikey:" + k.KeyID},
+	}
+}
+
+// apiKeyCache хранит результаты проверки ключей (по SHA-256 ключа) в течение apiKeyCacheTTL,
+// поэтому отзыв ключа доходит до сервисов не позже чем через apiKeyCacheTTL.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]apiKeyEntry
+}
+
+type apiKeyEntry struct {
+	info    APIKeyInfo
+	expires time.Time
+}
+
+var (
+	apiKeys        = &apiKeyCache{entries: map[string]apiKeyEntry{}}
+	apiKeyCacheTTL = time.Minute
+	apiKeyLimiter  = &rateLimiter{windows: map[string]*rateWindow{}}
+)
+
+// LookupAPIKey проверяет ключ в auth-service. Сам auth-service подменяет его запросом к своей БД.
+var LookupAPIKey = introspectAPIKey
+
+func (k *apiKeyCache) get(key string) (APIKeyInfo, error) {
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:])
+	now := time.Now()
+	k.mu.Lock()
+	e, ok := k.entries[id]
+	k.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.info, nil
+	}
+	info, err := LookupAPIKey(key)
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// неизвестные ключи тоже кэшируются; чтобы перебор не раздувал кэш, просроченные записи вычищаются
+	if len(k.entries) >= 10000 {
+		for id, e := range k.entries {
+			if now.After(e.expires) {
+				delete(k.entries, id)
+			}
+		}
+	}
+	k.entries[id] = apiKeyEntry{info: info, expires: now.Add(apiKeyCacheTTL)}
+	return info, nil
+}
+
+func introspectAPIKey(key string) (APIKeyInfo, error) {
+	authURL := os.Getenv("AUTH_URL")
+	if authURL == "" {
+		return APIKeyInfo{}, errors.New("AUTH_URL не задан")
+	}
+	payload, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, authURL+"/api-keys/introspect", bytes.NewReader(payload))
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", ServiceAuthHeader())
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return APIKeyInfo{}, fmt.Errorf("auth-service ответил %s", resp.Status)
+	}
+	var info APIKeyInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+// rateLimiter — счётчик запросов в минутных окнах. Лимит действует в пределах одного экземпляра сервиса.
+type rateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// allow учитывает запрос и сообщает, укладывается ли он в limit запросов в минуту,
+// а если нет — через сколько откроется следующее окно.
+func (l *rateLimiter) allow(id string, limit int) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[id]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &rateWindow{start: now}
+		l.windows[id] = w
+	}
+	if w.count >= limit {
+		return false, w.start.Add(time.Minute).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// Required проверяет JWT и, если заданы роли, что роль пользователя входит в их число.
+// Вместо JWT можно передать API-ключ в заголовке X-API-Key, но только там, где среди ролей
+// явно указана RoleAPIClient. Claims сохраняются в контексте и доступны через CurrentClaims.
+func Required(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			if !HasRole(&Claims{Role: RoleAPIClient}, roles...) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Ресурс недоступен по API-ключу"})
+				return
+			}
+			info, err := apiKeys.get(key)
+			if err != nil {
+				log.Printf("Не удалось проверить API-ключ: %v", err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Не удалось проверить API-ключ"})
+				return
+			}
+			if !info.Active {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Некорректный API-ключ"})
+				return
+			}
+			if ok, retry := apiKeyLimiter.allow(info.KeyID, info.RateLimit); !ok {
+				c.Header("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Превышен лимит запросов для API-ключа"})
+				return
+			}
+			claims := info.claims()
+			c.Set("claims", claims)
+			c.Set("username", claims.Subject)
+			c.Next()
+			return
+		}
+		tokenString := BearerToken(c)
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Отсутствует токен"})
+			return
+		}
+		claims, err := ParseToken(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Некорректный токен"})
+			return
+		}
+		if len(roles) > 0 && !HasRole(claims, roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			return
+		}
+		c.Set("claims", claims)
+		c.Set("username", claims.Subject)
+		c.Next()
+	}
+}
+
+// HasRole сообщает, входит ли роль пользователя в перечисленные.
+func HasRole(claims *Claims, roles ...string) bool {
+	if claims == nil {
+		return false
+	}
+	for _, r := range roles {
+		if claims.Role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope пропускает запрос по API-ключу, только если у ключа есть scope.
+// Ставится после Required; на пользователей и сервисы не влияет.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := CurrentClaims(c); claims != nil && claims.Role == RoleAPIClient && !HasScope(claims, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "У API-ключа нет права " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasScope сообщает, выдан ли ключу scope.
+func HasScope(claims *Claims, scope string) bool {
+	for _, s := range claims.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CrossTenant сообщает, видит ли пользователь данные всех организаций:
+// это администратор платформы (без tenant_id) и внутренние сервисы.
+func CrossTenant(claims *Claims) bool {
+	return claims != nil && claims.TenantID == "" && HasRole(claims, RoleAdmin, RoleService)
+}
+
+// SameTenant сообщает, доступны ли пользователю данные организации tenantID.
+func SameTenant(claims *Claims, tenantID string) bool {
+	return CrossTenant(claims) || (claims != nil && claims.TenantID != "" && claims.TenantID == tenantID)
+}
+
+// TenantScope возвращает условие WHERE по колонке tenant_id: пользователь организации видит только её данные,
+// кросс-арендный — все или одной организации, если передан ?tenant_id=. argN — номер плейсхолдера.
+func TenantScope(c *gin.Context, argN int) (string, []any) {
+	claims := CurrentClaims(c)
+	tenant := claims.TenantID
+	if CrossTenant(claims) {
+		if tenant = c.Query("tenant_id"); tenant == "" {
+			return "TRUE", nil
+		}
+	}
+	return fmt.Sprintf("tenant_id = $%d", argN), []any{tenant}
+}
+
+// RecordTenant — организация для создаваемой записи: у пользователя организации всегда своя,
+// кросс-арендный указывает её явно (по умолчанию DefaultTenant).
+func RecordTenant(claims *Claims, requested string) string {
+	if !CrossTenant(claims) {
+		return claims.TenantID
+	}
+	if requested == "" {
+		return DefaultTenant
+	}
+	return requested
+}
+
+// CrossTenantRequired ставится после Required на операции уровня платформы
+// (управление пользователями, организациями, ключами): администратору организации они недоступны.
+func CrossTenantRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CrossTenant(CurrentClaims(c)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Доступно только администратору платформы"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentClaims возвращает claims, сохранённые Required, или nil.
+func CurrentClaims(c *gin.Context) *Claims {
+	if v, ok := c.Get("claims"); ok {
+		if claims, ok := v.(*Claims); ok {
+			return claims
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPublicKey, testPrivateKey, _ = ed25519.GenerateKey(nil)
+
+// signTestToken подписывает токен тестовым ключом, который подкладывается в кэш JWKS вместо auth-service.
+func signTestToken(t *testing.T, claims Claims) string {
+	jwks.set(map[string]crypto.PublicKey{"test": testPublicKey})
+
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(testPrivateKey)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestRequiredRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/orders/:id", Required(RoleAdmin, RoleDispatcher), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"без токена", "", http.StatusUnauthorized},
+		{"мусор вместо токена", "Bearer garbage", http.StatusUnauthorized},
+		{"клиент", "Bearer " + signTestToken(t, Claims{Role: RoleCustomer}), http.StatusForbidden},
+		{"диспетчер", "Bearer " + signTestToken(t, Claims{Role: RoleDispatcher}), http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", "/orders/1", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.want, w.Code, tc.name)
+	}
+}
+
+func TestParseTokenRejectsRevoked(t *testing.T) {
+	token := signTestToken(t, Claims{Role: RoleDispatcher, StandardClaims: jwt.StandardClaims{Id: "jti-1"}})
+	claims, err := ParseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleDispatcher, claims.Role)
+
+	revokedTokens.replace([]string{"jti-1"})
+	defer revokedTokens.replace(nil)
+	_, err = ParseToken(token)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestTenantScope(t *testing.T) {
+	scope := func(claims *Claims, query string) (string, []any) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/orders"+query, nil)
+		c.Set("claims", claims)
+		return TenantScope(c, 2)
+	}
+
+	where, args := scope(&Claims{Role: RoleDispatcher, TenantID: "shop-1"}, "?tenant_id=shop-2")
+	assert.Equal(t, "tenant_id = $2", where)
+	assert.Equal(t, []any{"shop-1"}, args, "пользователь организации не может выбрать чужую")
+
+	where, args = scope(&Claims{Role: RoleAdmin}, "")
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+
+	where, args = scope(&Claims{Role: RoleAdmin}, "?tenant_id=shop-2")
+	assert.Equal(t, "tenant_id = $2", where)
+	assert.Equal(t, []any{"shop-2"}, args)
+
+	// без организации данные видит только администратор платформы
+	where, args = scope(&Claims{Role: RoleAnalyst}, "")
+	assert.Equal(t, "tenant_id = $2", where)
+	assert.Equal(t, []any{""}, args)
+}
+
+func TestRecordTenant(t *testing.T) {
+	assert.Equal(t, "shop-1", RecordTenant(&Claims{Role: RoleCourier, TenantID: "shop-1"}, "shop-2"),
+		"пользователь организации не может писать в чужую")
+	assert.Equal(t, "shop-2", RecordTenant(&Claims{Role: RoleService}, "shop-2"))
+	assert.Equal(t, DefaultTenant, RecordTenant(&Claims{Role: RoleService}, ""))
+}
+
+func TestRequiredAPIKey(t *testing.T) {
+	lookups := 0
+	LookupAPIKey = func(key string) (APIKeyInfo, error) {
+		lookups++
+		if key != "klk_k1_secret" {
+			return APIKeyInfo{}, nil
+		}
+		return APIKeyInfo{Active: true, KeyID: "k1", TenantID: "shop-1", Scopes: []string{ScopeOrdersRead}, RateLimit: 2}, nil
+	}
+	apiKeys = &apiKeyCache{entries: map[string]apiKeyEntry{}}
+	apiKeyLimiter = &rateLimiter{windows: map[string]*rateWindow{}}
+	defer func() { LookupAPIKey = introspectAPIKey }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := func(c *gin.Context) { c.String(http.StatusOK, CurrentClaims(c).Subject) }
+	r.GET("/orders", Required(RoleDispatcher, RoleAPIClient), RequireScope(ScopeOrdersRead), handler)
+	r.POST("/orders", Required(RoleCustomer, RoleAPIClient), RequireScope(ScopeOrdersWrite), handler)
+	r.DELETE("/orders/:id", Required(RoleAdmin), handler)
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/orders", "klk_k1_secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "apikey:k1", w.Body.String())
+	assert.Equal(t, http.StatusForbidden, do("POST", "/orders", "klk_k1_secret").Code, "нет scope orders:write")
+	assert.Equal(t, http.StatusTooManyRequests, do("GET", "/orders", "klk_k1_secret").Code, "лимит 2 запроса в минуту")
+	assert.Equal(t, 1, lookups, "результат проверки ключа кэшируется")
+
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/orders/1", "klk_k1_secret").Code, "маршрут без RoleAPIClient")
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/orders", "klk_k1_forged").Code)
+
+	LookupAPIKey = func(string) (APIKeyInfo, error) { return APIKeyInfo{}, errors.New("auth-service недоступен") }
+	assert.Equal(t, http.StatusServiceUnavailable, do("GET", "/orders", "klk_k2_secret").Code)
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{windows: map[string]*rateWindow{}}
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("abc", 3)
+		assert.True(t, ok)
+	}
+	ok, retry := l.allow("abc", 3)
+	assert.False(t, ok)
+	assert.True(t, retry > 0 && retry <= time.Minute)
+	ok, _ = l.allow("other", 3)
+	assert.True(t, ok, "лимиты ключей независимы")
+	ok, _ = l.allow("abc", 0)
+	assert.True(t, ok, "0 — без ограничения")
+}
+
+func TestServiceAuthHeaderCachesToken(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		id, secret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "order-service", id)
+		assert.Equal(t, "s3cret", secret)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"svc-token","token_type":"Bearer","expires_in":600}`))
+	}))
+	defer srv.Close()
+	t.Setenv("AUTH_URL", srv.URL)
+	t.Setenv("SERVICE_CLIENT_ID", "order-service")
+	t.Setenv("SERVICE_CLIENT_SECRET", "s3cret")
+	serviceTokens = &serviceTokenCache{}
+	defer func() { serviceTokens = &serviceTokenCache{} }()
+
+	assert.Equal(t, "Bearer svc-token", ServiceAuthHeader())
+	assert.Equal(t, "Bearer svc-token", ServiceAuthHeader())
+	assert.Equal(t, 1, calls)
+}
+
+func TestBearerTokenFromQueryOnlyWhenAllowed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var got string
+	r := gin.New()
+	r.GET("/report", func(c *gin.Context) { got = BearerToken(c) })
+	r.GET("/tracking/ws", AllowQueryToken(), func(c *gin.Context) { got = BearerToken(c) })
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report?token=abc", nil))
+	assert.Empty(t, got, "токен в URL на обычных маршрутах не принимается")
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tracking/ws?token=abc", nil))
+	assert.Equal(t, "abc", got)
+
+	req := httptest.NewRequest(http.MethodGet, "/report", nil)
+	req.Header.Set("Authorization", "Bearer xyz")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "xyz", got)
+}
@@ -4,3 +4,11 @@ CREATE TABLE IF NOT EXISTS courier_tracking (
   longitude     DOUBLE PRECISION NOT NULL,
   updated_at    TIMESTAMP WITHOUT TIME ZONE NOT NULL
 );
+
+-- Организация (tenant), которой принадлежит курьер; существующие записи относятся к организации по умолчанию.
+ALTER TABLE courier_tracking ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_courier_tracking_tenant ON courier_tracking(tenant_id);
+
+-- Координаты хранятся по организации: первая организация, приславшая courier_id, больше не закрепляет его за собой.
+ALTER TABLE courier_tracking DROP CONSTRAINT IF EXISTS courier_tracking_pkey;
+ALTER TABLE courier_tracking ADD PRIMARY KEY (tenant_id, courier_id);
@@ -13,10 +13,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	_ "github.com/lib/pq"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 type CourierTracking struct {
 	CourierID string    `json:"courier_id"`
+	TenantID  string    `json:"tenant_id"`
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -28,7 +30,8 @@ var db *sql.DB
 var wsUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
-var wsClients = make(map[*websocket.Conn]bool)
+// Подписчик видит обновления только своей организации (см. broadcast).
+var wsClients = make(map[*websocket.Conn]*auth.Claims)
 var wsClientsMutex sync.Mutex
 
 // initDB устанавливает соединение с базой данных Tracking Service.
@@ -51,14 +54,14 @@ func initDB() (*sql.DB, error) {
 }
 
 // wsHandler обновляет соединение до WebSocket и добавляет клиента в пул.
-func wsHandler(w http.ResponseWriter, r *http.Request) {
+func wsHandler(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Ошибка при апгрейде до WebSocket:", err)
 		return
 	}
 	wsClientsMutex.Lock()
-	wsClients[conn] = true
+	wsClients[conn] = claims
 	wsClientsMutex.Unlock()
 	log.Println("Новый клиент WebSocket подключен.")
 
@@ -76,6 +79,15 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// canTrackCourier разрешает курьеру работать только со своими координатами;
+// остальные роли уже отфильтрованы auth.Required.
+func canTrackCourier(claims *auth.Claims, courierID string) bool {
+	if claims.Role != auth.RoleCourier {
+		return true
+	}
+	return claims.CourierID != "" && claims.CourierID == courierID
+}
+
 func main() {
 	// Инициализация БД.
 	var err error
@@ -84,6 +96,9 @@ func main() {
 		log.Fatalf("Ошибка подключения к БД: %v", err)
 	}
 
+	// Список отозванных токенов подтягивается из auth-service (AUTH_URL).
+	auth.StartRevocationSync()
+
 	// Инициализируем роутер Gin.
 	r := gin.Default()
 	corsConfig := cors.Config{
@@ -101,39 +116,53 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok", "time": time.Now().Format(time.RFC3339)})
 	})
 
-	// WebSocket endpoint для получения обновлений местоположения.
-	r.GET("/tracking/ws", func(c *gin.Context) {
-		wsHandler(c.Writer, c.Request)
+	// WebSocket endpoint для получения обновлений местоположения; браузер передаёт токен в ?token= (см. auth.AllowQueryToken).
+	r.GET("/tracking/ws", auth.AllowQueryToken(), auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst), func(c *gin.Context) {
+		wsHandler(c.Writer, c.Request, auth.CurrentClaims(c))
 	})
 
-    r.POST("/couriers/tracking", func(c *gin.Context) {
+    r.POST("/couriers/tracking", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleCourier, auth.RoleService), func(c *gin.Context) {
         var ct CourierTracking
         if err := c.BindJSON(&ct); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
             return
         }
+        if !canTrackCourier(auth.CurrentClaims(c), ct.CourierID) {
+            c.JSON(http.StatusForbidden, gin.H{"error": "Курьер может передавать только свои координаты"})
+            return
+        }
         ct.UpdatedAt = time.Now()
-        // Сохраняем или обновляем запись
+        ct.TenantID = auth.RecordTenant(auth.CurrentClaims(c), ct.TenantID)
+        // Координаты хранятся по организации, приславшей их: tracking-service не знает, чей курьер,
+        // поэтому запись другой организации с тем же courier_id не мешает владельцу и не видна ему.
         _, err := db.Exec(`
             INSERT INTO courier_tracking
-              (courier_id, latitude, longitude, updated_at)
-            VALUES ($1,$2,$3, $4)
-            ON CONFLICT (courier_id) DO UPDATE SET
+              (courier_id, latitude, longitude, updated_at, tenant_id)
+            VALUES ($1,$2,$3, $4, $5)
+            ON CONFLICT (tenant_id, courier_id) DO UPDATE SET
 			  latitude   = EXCLUDED.latitude,
 			  longitude  = EXCLUDED.longitude,
 			  updated_at = EXCLUDED.updated_at
-        `, ct.CourierID, ct.Latitude, ct.Longitude, ct.UpdatedAt)
+        `, ct.CourierID, ct.Latitude, ct.Longitude, ct.UpdatedAt, ct.TenantID)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
         c.JSON(http.StatusCreated, ct)
+        // Подписчикам — новая позиция и пересчитанные ETA заказов курьера
+        go publishPositionUpdate(ct)
     })
-	r.GET("/couriers/tracking/:courierId", func(c *gin.Context) {
+	r.GET("/couriers/tracking/:courierId", auth.Required(auth.RoleAdmin, auth.RoleDispatcher, auth.RoleAnalyst, auth.RoleCourier, auth.RoleService), func(c *gin.Context) {
 		courierId := c.Param("courierId")
+		if !canTrackCourier(auth.CurrentClaims(c), courierId) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			return
+		}
 		var ct CourierTracking
-		query := "SELECT courier_id, latitude, longitude, updated_at FROM courier_tracking WHERE courier_id = $1"
-		err := db.QueryRow(query, courierId).Scan(&ct.CourierID, &ct.Latitude, &ct.Longitude, &ct.UpdatedAt)
+		where, args := auth.TenantScope(c, 2)
+		query := "SELECT courier_id, tenant_id, latitude, longitude, updated_at FROM courier_tracking WHERE courier_id = $1 AND " + where +
+			" ORDER BY updated_at DESC LIMIT 1"
+		err := db.QueryRow(query, append([]any{courierId}, args...)...).Scan(&ct.CourierID, &ct.TenantID, &ct.Latitude, &ct.Longitude, &ct.UpdatedAt)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Курьер не найден"})
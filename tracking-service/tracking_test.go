@@ -13,6 +13,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
 )
 
 type mockEnv struct {
@@ -40,19 +41,20 @@ func setupMockEnv(t *testing.T) *mockEnv {
 		}
 		ct.UpdatedAt = time.Now()
 		env.mock.ExpectExec("INSERT INTO courier_tracking").
-			WithArgs(ct.CourierID, ct.Latitude, ct.Longitude, sqlmock.AnyArg()).
+			WithArgs(ct.CourierID, ct.Latitude, ct.Longitude, sqlmock.AnyArg(), auth.DefaultTenant).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 		c.JSON(http.StatusCreated, ct)
 	})
 	r.GET("/couriers/tracking/:courierId", func(c *gin.Context) {
 		courierId := c.Param("courierId")
-		rows := sqlmock.NewRows([]string{"courier_id", "latitude", "longitude", "updated_at"}).
-			AddRow(courierId, 55.5, 37.5, time.Now())
-		env.mock.ExpectQuery(regexp.QuoteMeta("SELECT courier_id, latitude, longitude, updated_at FROM courier_tracking WHERE courier_id = $1")).
-			WithArgs(courierId).WillReturnRows(rows)
+		rows := sqlmock.NewRows([]string{"courier_id", "tenant_id", "latitude", "longitude", "updated_at"}).
+			AddRow(courierId, auth.DefaultTenant, 55.5, 37.5, time.Now())
+		query := "SELECT courier_id, tenant_id, latitude, longitude, updated_at FROM courier_tracking WHERE courier_id = $1 AND tenant_id = $2"
+		env.mock.ExpectQuery(regexp.QuoteMeta(query)).
+			WithArgs(courierId, auth.DefaultTenant).WillReturnRows(rows)
 		var ct CourierTracking
-		err := env.db.QueryRow("SELECT courier_id, latitude, longitude, updated_at FROM courier_tracking WHERE courier_id = $1", courierId).
-			Scan(&ct.CourierID, &ct.Latitude, &ct.Longitude, &ct.UpdatedAt)
+		err := env.db.QueryRow(query, courierId, auth.DefaultTenant).
+			Scan(&ct.CourierID, &ct.TenantID, &ct.Latitude, &ct.Longitude, &ct.UpdatedAt)
 		assert.NoError(t, err)
 		c.JSON(http.StatusOK, ct)
 	})
@@ -92,3 +94,10 @@ func TestGetCourierTracking(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Contains(t, rr.Body.String(), "courier_id")
 }
+
+func TestCanTrackCourier(t *testing.T) {
+	assert.True(t, canTrackCourier(&auth.Claims{Role: auth.RoleDispatcher}, "c-1"))
+	assert.True(t, canTrackCourier(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-1"}, "c-1"))
+	assert.False(t, canTrackCourier(&auth.Claims{Role: auth.RoleCourier, CourierID: "c-1"}, "c-2"))
+	assert.False(t, canTrackCourier(&auth.Claims{Role: auth.RoleCourier}, ""))
+}
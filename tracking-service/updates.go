@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// ETA — расчёт времени прибытия от order-service (POST /couriers/:id/position).
+type ETA struct {
+	OrderID     string    `json:"order_id"`
+	CourierID   string    `json:"courier_id"`
+	TenantID    string    `json:"tenant_id"`
+	ETA         time.Time `json:"eta"`
+	RemainingKm float64   `json:"remaining_km"`
+	SpeedKmh    float64   `json:"speed_kmh"`
+	VehicleType string    `json:"vehicle_type"`
+	ComputedAt  time.Time `json:"computed_at"`
+}
+
+// Update — сообщение подписчикам WebSocket: type "position" (Position) или "eta" (ETA).
+type Update struct {
+	Type     string           `json:"type"`
+	Position *CourierTracking `json:"position,omitempty"`
+	ETA      *ETA             `json:"eta,omitempty"`
+}
+
+// broadcast рассылает сообщение подписчикам организации tenantID (и администраторам платформы).
+// Клиенты, запись в которых не удалась, отключаются.
+func broadcast(tenantID string, msg Update) {
+	wsClientsMutex.Lock()
+	defer wsClientsMutex.Unlock()
+	for conn, claims := range wsClients {
+		if !auth.SameTenant(claims, tenantID) {
+			continue
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Printf("WebSocket: ошибка отправки, клиент отключён: %v", err)
+			conn.Close()
+			delete(wsClients, conn)
+		}
+	}
+}
+
+// recomputeETAs просит order-service (ORDER_SERVICE_URL) пересчитать ETA заказов курьера по новой позиции.
+var recomputeETAs = requestETAs
+
+func requestETAs(ct CourierTracking) ([]ETA, error) {
+	orderURL := os.Getenv("ORDER_SERVICE_URL")
+	if orderURL == "" {
+		return nil, nil
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"tenant_id":  ct.TenantID,
+		"latitude":   ct.Latitude,
+		"longitude":  ct.Longitude,
+		"updated_at": ct.UpdatedAt,
+	})
+	req, err := http.NewRequest(http.MethodPost, orderURL+"/couriers/"+url.PathEscape(ct.CourierID)+"/position", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authHeader := auth.ServiceAuthHeader(); authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("order-service ответил %s", resp.Status)
+	}
+	var etas []ETA
+	return etas, json.NewDecoder(resp.Body).Decode(&etas)
+}
+
+// publishPositionUpdate рассылает новую позицию курьера и пересчитанные по ней ETA.
+func publishPositionUpdate(ct CourierTracking) {
+	broadcast(ct.TenantID, Update{Type: "position", Position: &ct})
+	etas, err := recomputeETAs(ct)
+	if err != nil {
+		log.Printf("Не удалось пересчитать ETA курьера %s: %v", ct.CourierID, err)
+		return
+	}
+	for i := range etas {
+		broadcast(etas[i].TenantID, Update{Type: "eta", ETA: &etas[i]})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vladimirmorgulis37/kirill-logistics/pkg/auth"
+)
+
+// dialSubscriber подключает к тестовому серверу подписчика WebSocket с заданными claims.
+func dialSubscriber(t *testing.T, claims *auth.Claims) *websocket.Conn {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/tracking/ws", func(c *gin.Context) { wsHandler(c.Writer, c.Request, claims) })
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/tracking/ws", nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	// ждём, пока сервер зарегистрирует клиента
+	assert.Eventually(t, func() bool {
+		wsClientsMutex.Lock()
+		defer wsClientsMutex.Unlock()
+		for _, c := range wsClients {
+			if c == claims {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	return conn
+}
+
+func readUpdate(conn *websocket.Conn) (Update, error) {
+	var u Update
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	err := conn.ReadJSON(&u)
+	return u, err
+}
+
+func TestPublishPositionUpdate(t *testing.T) {
+	recomputeETAs = func(ct CourierTracking) ([]ETA, error) {
+		return []ETA{{OrderID: "o-1", CourierID: ct.CourierID, TenantID: ct.TenantID, ETA: time.Now().Add(10 * time.Minute)}}, nil
+	}
+	defer func() { recomputeETAs = requestETAs }()
+
+	own := dialSubscriber(t, &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-1"})
+	platform := dialSubscriber(t, &auth.Claims{Role: auth.RoleAdmin})
+	other := dialSubscriber(t, &auth.Claims{Role: auth.RoleDispatcher, TenantID: "shop-2"})
+
+	publishPositionUpdate(CourierTracking{CourierID: "c-1", TenantID: "shop-1", Latitude: 55.75, Longitude: 37.61})
+
+	for _, conn := range []*websocket.Conn{own, platform} {
+		u, err := readUpdate(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, "position", u.Type)
+		assert.Equal(t, "c-1", u.Position.CourierID)
+
+		u, err = readUpdate(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, "eta", u.Type)
+		assert.Equal(t, "o-1", u.ETA.OrderID)
+	}
+	_, err := readUpdate(other)
+	assert.Error(t, err, "другая организация не получает обновления")
+}